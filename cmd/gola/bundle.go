@@ -0,0 +1,81 @@
+// (c) 2018, Jeff Chen <jefurry@qq.com>
+//
+// This file is part of Gola
+//
+// Copyright 2018 The Gola Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/jefurry/gola/lua/bundle"
+	"io"
+	"io/ioutil"
+)
+
+const bundleUsage = "bundle [-o file] [-go package] [-name const] [-strip] [-I path]... main.lua"
+
+// runBundle bundles main script with the modules it requires into a lua
+// file, or a go file with -go. The requires which can not be resolved are
+// reported to stderr, they are left to package.path of lua states.
+func runBundle(args []string, stdout, stderr io.Writer) int {
+	var paths stringsFlag
+
+	flags := flag.NewFlagSet("bundle", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	output := flags.String("o", "", "write bundle to `file` instead of stdout")
+	pkg := flags.String("go", "", "generate go source of `package` with bundle as a constant")
+	name := flags.String("name", "Bundle", "`name` of constant of go source")
+	strip := flags.Bool("strip", false, "strip comments from sources")
+	flags.Var(&paths, "I", "search modules in `path`")
+	if err := flags.Parse(args); err != nil {
+		return exitUsage
+	}
+
+	if flags.NArg() != 1 {
+		fmt.Fprintf(stderr, "usage: gola %s\n", bundleUsage)
+
+		return exitUsage
+	}
+
+	b := bundle.New()
+	b.Paths = append(b.Paths, paths...)
+	b.StripComments = *strip
+
+	bd, err := b.Bundle(flags.Arg(0))
+	if err != nil {
+		fmt.Fprintf(stderr, "gola: %s\n", err)
+
+		return exitFailure
+	}
+
+	for _, u := range bd.Unresolved {
+		fmt.Fprintf(stderr, "gola: %s\n", u)
+	}
+
+	source := bd.Lua()
+	if *pkg != "" {
+		if source, err = bd.Go(*pkg, *name); err != nil {
+			fmt.Fprintf(stderr, "gola: %s\n", err)
+
+			return exitFailure
+		}
+	}
+
+	if *output == "" {
+		stdout.Write(source)
+
+		return exitOK
+	}
+
+	if err := ioutil.WriteFile(*output, source, 0644); err != nil {
+		fmt.Fprintf(stderr, "gola: %s\n", err)
+
+		return exitFailure
+	}
+
+	return exitOK
+}
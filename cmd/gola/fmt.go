@@ -0,0 +1,161 @@
+// (c) 2018, Jeff Chen <jefurry@qq.com>
+//
+// This file is part of Gola
+//
+// Copyright 2018 The Gola Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"github.com/jefurry/gola/lua/format"
+	"github.com/pmezard/go-difflib/difflib"
+	"io"
+	"io/ioutil"
+	"os"
+)
+
+const fmtUsage = "fmt [-l] [-d] [-w] [path]..."
+
+// runFmt formats lua files in paths, directories are walked recursively
+// except hidden ones, and the source is read from stdin without paths.
+// With -l or -d, it exits with exitFailure if any file is not formatted.
+func runFmt(args []string, stdout, stderr io.Writer) int {
+	flags := flag.NewFlagSet("fmt", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	list := flags.Bool("l", false, "list files whose formatting differs")
+	diff := flags.Bool("d", false, "print diffs instead of formatted sources")
+	write := flags.Bool("w", false, "write result to source files instead of stdout")
+	if err := flags.Parse(args); err != nil {
+		return exitUsage
+	}
+
+	f := &formatter{
+		list:   *list,
+		diff:   *diff,
+		write:  *write,
+		stdout: stdout,
+	}
+
+	paths := flags.Args()
+	if len(paths) == 0 {
+		if f.write {
+			fmt.Fprintf(stderr, "gola: cannot use -w with standard input\n")
+
+			return exitUsage
+		}
+
+		src, err := ioutil.ReadAll(os.Stdin)
+		if err != nil {
+			fmt.Fprintf(stderr, "gola: %s\n", err)
+
+			return exitFailure
+		}
+
+		if err := f.format("<standard input>", src); err != nil {
+			fmt.Fprintf(stderr, "gola: %s\n", err)
+
+			return exitFailure
+		}
+
+		return f.exitCode()
+	}
+
+	files, err := findFiles(paths, ".lua")
+	if err != nil {
+		fmt.Fprintf(stderr, "gola: %s\n", err)
+
+		return exitFailure
+	}
+
+	code := exitOK
+	for _, path := range files {
+		src, err := ioutil.ReadFile(path)
+		if err == nil {
+			err = f.format(path, src)
+		}
+
+		if err != nil {
+			fmt.Fprintf(stderr, "gola: %s: %s\n", path, err)
+			code = exitFailure
+		}
+	}
+
+	if code != exitOK {
+		return code
+	}
+
+	return f.exitCode()
+}
+
+type (
+	formatter struct {
+		list, diff, write bool
+		stdout            io.Writer
+		// whether any file is not formatted.
+		changed bool
+	}
+)
+
+func (f *formatter) format(name string, src []byte) error {
+	res, err := format.Source(src)
+	if err != nil {
+		return err
+	}
+
+	if bytes.Equal(src, res) {
+		if !f.list && !f.diff && !f.write {
+			f.stdout.Write(res)
+		}
+
+		return nil
+	}
+
+	f.changed = true
+	if f.list {
+		fmt.Fprintln(f.stdout, name)
+	}
+
+	if f.diff {
+		d, err := difflib.GetUnifiedDiffString(difflib.UnifiedDiff{
+			A:        difflib.SplitLines(string(src)),
+			B:        difflib.SplitLines(string(res)),
+			FromFile: name + ".orig",
+			ToFile:   name,
+			Context:  3,
+		})
+		if err != nil {
+			return err
+		}
+
+		io.WriteString(f.stdout, d)
+	}
+
+	if f.write {
+		fi, err := os.Stat(name)
+		if err != nil {
+			return err
+		}
+
+		return ioutil.WriteFile(name, res, fi.Mode().Perm())
+	}
+
+	if !f.list && !f.diff {
+		f.stdout.Write(res)
+	}
+
+	return nil
+}
+
+// exitCode returns exitFailure if any file is not formatted with -l or -d.
+func (f *formatter) exitCode() int {
+	if f.changed && (f.list || f.diff) {
+		return exitFailure
+	}
+
+	return exitOK
+}
@@ -0,0 +1,89 @@
+// (c) 2018, Jeff Chen <jefurry@qq.com>
+//
+// This file is part of Gola
+//
+// Copyright 2018 The Gola Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"github.com/jefurry/gola/lua/lint"
+	"github.com/jefurry/gola/lua/reng"
+	"io"
+	"strings"
+)
+
+const lintUsage = "lint [-json] [-reng] [-globals name,...] [path]..."
+
+// runLint checks lua files in paths, directories are walked recursively
+// except hidden ones. It exits with exitFailure if any problem is found.
+func runLint(args []string, stdout, stderr io.Writer) int {
+	flags := flag.NewFlagSet("lint", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	asJSON := flags.Bool("json", false, "print problems as JSON")
+	forReng := flags.Bool("reng", false, "check against the default policy of rule engine")
+	globals := flags.String("globals", "", "comma separated `names` of extra globals")
+	if err := flags.Parse(args); err != nil {
+		return exitUsage
+	}
+
+	l := lint.New()
+	if *globals != "" {
+		l.Globals = append(l.Globals, strings.Split(*globals, ",")...)
+	}
+
+	if *forReng {
+		l.Policy = reng.DefaultPolicy()
+		// rule actions and conditions see facts.
+		l.Globals = append(l.Globals, "facts")
+	}
+
+	paths := flags.Args()
+	if len(paths) == 0 {
+		paths = []string{"."}
+	}
+
+	files, err := findFiles(paths, ".lua")
+	if err != nil {
+		fmt.Fprintf(stderr, "gola: %s\n", err)
+
+		return exitFailure
+	}
+
+	diags := make([]*lint.Diagnostic, 0)
+	for _, path := range files {
+		d, err := l.LintFile(path)
+		if err != nil {
+			fmt.Fprintf(stderr, "gola: %s\n", err)
+
+			return exitFailure
+		}
+
+		diags = append(diags, d...)
+	}
+
+	if *asJSON {
+		enc := json.NewEncoder(stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(diags); err != nil {
+			fmt.Fprintf(stderr, "gola: %s\n", err)
+
+			return exitFailure
+		}
+	} else {
+		for _, d := range diags {
+			fmt.Fprintln(stdout, d)
+		}
+	}
+
+	if len(diags) > 0 {
+		return exitFailure
+	}
+
+	return exitOK
+}
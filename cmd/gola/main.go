@@ -0,0 +1,168 @@
+// (c) 2018, Jeff Chen <jefurry@qq.com>
+//
+// This file is part of Gola
+//
+// Copyright 2018 The Gola Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+// Command gola is the command line tool of Gola.
+//
+// Usage:
+//
+//	gola <command> [arguments]
+//
+// The commands are:
+//
+//	bundle       bundle lua script with the modules it requires
+//	fmt          format lua sources
+//	lint         check lua sources for common mistakes
+//	rules test   run test suites of rule sets
+//	repl         run interactive lua interpreter
+//	run          run lua script with all libraries of gola
+//	test         run lua tests of *_test.lua files
+//	version      print version of gola
+package main
+
+import (
+	"fmt"
+	"github.com/jefurry/gola/config"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Exit codes of commands.
+const (
+	exitOK      = 0
+	exitFailure = 1
+	exitUsage   = 2
+)
+
+type (
+	command struct {
+		usage string
+		run   func(args []string, stdout, stderr io.Writer) int
+	}
+)
+
+var commands = map[string]*command{
+	"bundle": &command{
+		usage: bundleUsage,
+		run:   runBundle,
+	},
+	"fmt": &command{
+		usage: fmtUsage,
+		run:   runFmt,
+	},
+	"lint": &command{
+		usage: lintUsage,
+		run:   runLint,
+	},
+	"rules": &command{
+		usage: rulesUsage,
+		run:   runRules,
+	},
+	"repl": &command{
+		usage: replUsage,
+		run:   runRepl,
+	},
+	"run": &command{
+		usage: runUsage,
+		run:   runRun,
+	},
+	"test": &command{
+		usage: testUsage,
+		run:   runTest,
+	},
+	"version": &command{
+		usage: "version",
+		run:   runVersion,
+	},
+}
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdout, os.Stderr))
+}
+
+func run(args []string, stdout, stderr io.Writer) int {
+	if len(args) == 0 {
+		usage(stderr)
+
+		return exitUsage
+	}
+
+	cmd, ok := commands[args[0]]
+	if !ok {
+		fmt.Fprintf(stderr, "gola: unknown command %q\n", args[0])
+		usage(stderr)
+
+		return exitUsage
+	}
+
+	return cmd.run(args[1:], stdout, stderr)
+}
+
+func usage(w io.Writer) {
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	fmt.Fprintf(w, "Usage:\n\n")
+	for _, name := range names {
+		fmt.Fprintf(w, "\tgola %s\n", commands[name].usage)
+	}
+}
+
+func runVersion(args []string, stdout, stderr io.Writer) int {
+	fmt.Fprintf(stdout, "%s %s\n", config.PROJECT_NAME, config.VERSION)
+
+	return exitOK
+}
+
+// findFiles returns the files with suffix in paths, directories are walked
+// recursively except hidden ones, and files given are returned as is.
+func findFiles(paths []string, suffix string) ([]string, error) {
+	files := make([]string, 0)
+	for _, path := range paths {
+		fi, err := os.Stat(path)
+		if err != nil {
+			return nil, err
+		}
+
+		if !fi.IsDir() {
+			files = append(files, path)
+
+			continue
+		}
+
+		err = filepath.Walk(path, func(p string, fi os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+
+			if fi.IsDir() {
+				if p != path && strings.HasPrefix(fi.Name(), ".") {
+					return filepath.SkipDir
+				}
+
+				return nil
+			}
+
+			if strings.HasSuffix(fi.Name(), suffix) {
+				files = append(files, p)
+			}
+
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return files, nil
+}
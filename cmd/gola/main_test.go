@@ -0,0 +1,464 @@
+// (c) 2018, Jeff Chen <jefurry@qq.com>
+//
+// This file is part of Gola
+//
+// Copyright 2018 The Gola Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRun(t *testing.T) {
+	var stdout, stderr bytes.Buffer
+
+	if !assert.Equal(t, exitUsage, run(nil, &stdout, &stderr), "exit code mismatching") {
+		return
+	}
+
+	if !assert.Equal(t, exitUsage, run([]string{"any"}, &stdout, &stderr), "exit code mismatching") {
+		return
+	}
+
+	if !assert.Equal(t, exitOK, run([]string{"version"}, &stdout, &stderr), "exit code mismatching") {
+		return
+	}
+
+	if !assert.Equal(t, exitUsage, run([]string{"rules", "test"}, &stdout, &stderr), "exit code mismatching") {
+		return
+	}
+}
+
+func TestRulesTest(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gola-cmd")
+	if !assert.NoError(t, err, "TempDir should succeed") {
+		return
+	}
+
+	defer os.RemoveAll(dir)
+
+	for name, data := range map[string]string{
+		"vip.yaml": `
+name: vip
+rules:
+  - name: vip
+    condition: facts.vip
+    action: facts.discount = 0.2
+`,
+		"pass.yaml": `
+rule_set: vip.yaml
+cases:
+  - name: vip
+    facts: {vip: true}
+    fired: [vip]
+    expect: {discount: 0.2}
+`,
+		"fail.yaml": `
+rule_set: vip.yaml
+cases:
+  - name: vip
+    facts: {vip: true}
+    expect: {discount: 0.3}
+`,
+	} {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(data), 0644); !assert.NoError(t, err, "WriteFile should succeed") {
+			return
+		}
+	}
+
+	var stdout, stderr bytes.Buffer
+	code := run([]string{"rules", "test", filepath.Join(dir, "pass.yaml")}, &stdout, &stderr)
+	if !assert.Equal(t, exitOK, code, "exit code mismatching: %s", stdout.String()) {
+		return
+	}
+
+	stdout.Reset()
+	code = run([]string{"rules", "test", filepath.Join(dir, "pass.yaml"), filepath.Join(dir, "fail.yaml")}, &stdout, &stderr)
+	if !assert.Equal(t, exitFailure, code, "exit code mismatching") {
+		return
+	}
+
+	if !assert.Contains(t, stdout.String(), "-    \"discount\": 0.3\n\t+    \"discount\": 0.2\n", "diff mismatching") {
+		return
+	}
+}
+
+func TestRunScript(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gola-cmd")
+	if !assert.NoError(t, err, "TempDir should succeed") {
+		return
+	}
+
+	defer os.RemoveAll(dir)
+
+	for name, source := range map[string]string{
+		"args.lua": `
+			local a, b = ...
+			assert(arg[0]:match("args.lua$") and arg[1] == a and arg[2] == b)
+			return tonumber(a) + tonumber(b)
+		`,
+		"exit.lua": `
+			local code = tonumber(...)
+			pcall(function() os.exit(code) end)
+			error("unreachable")
+		`,
+		"mod.lua": `return {value = 42}`,
+	} {
+		if !assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, name), []byte(source), 0644), "WriteFile should succeed") {
+			return
+		}
+	}
+
+	var archive bytes.Buffer
+	w := zip.NewWriter(&archive)
+	f, err := w.Create("plugin/init.lua")
+	if !assert.NoError(t, err, "Create should succeed") {
+		return
+	}
+
+	if _, err := f.Write([]byte(`return {value = 7}`)); !assert.NoError(t, err, "Write should succeed") {
+		return
+	}
+
+	if !assert.NoError(t, w.Close(), "Close should succeed") {
+		return
+	}
+
+	plugins := filepath.Join(dir, "plugins.zip")
+	if !assert.NoError(t, ioutil.WriteFile(plugins, archive.Bytes(), 0644), "WriteFile should succeed") {
+		return
+	}
+
+	var stdout, stderr bytes.Buffer
+	for _, v := range []struct {
+		args []string
+		code int
+	}{
+		{[]string{"run"}, exitUsage},
+		{[]string{"run", filepath.Join(dir, "args.lua"), "1", "2"}, 3},
+		{[]string{"run", filepath.Join(dir, "exit.lua"), "4"}, 4},
+		{[]string{"run", filepath.Join(dir, "exit.lua"), "0"}, exitOK},
+		{[]string{"run", filepath.Join(dir, "missing.lua")}, exitFailure},
+		{[]string{"run", "-e", "x = 1", "-e", "assert(x == 1)"}, exitOK},
+		{[]string{"run", "-e", "os.exit(false)"}, exitFailure},
+		{[]string{"run", "-e", "os.exit(-1)", "-e", "error('unreachable')"}, -1},
+		{[]string{"run", "-e", "error('boom')"}, exitFailure},
+		{[]string{"run", "-I", dir, "-l", "mod", "-e", "os.exit(mod.value)"}, 42},
+		{[]string{"run", "-z", plugins, "-l", "plugin", "-e", "os.exit(plugin.value)"}, 7},
+		{[]string{"run", "-z", filepath.Join(dir, "missing.zip"), "-e", ""}, exitFailure},
+		{[]string{"run", "-l", "json", "-e", "assert(json.encode({1}) == '[1]')"}, exitOK},
+		{[]string{"run", "-l", "missing", "-e", ""}, exitFailure},
+	} {
+		if !assert.Equal(t, v.code, run(v.args, &stdout, &stderr), "exit code mismatching: %v", v.args) {
+			return
+		}
+	}
+
+	if !assert.Contains(t, stderr.String(), "(command line):1: boom", "error mismatching") {
+		return
+	}
+}
+
+func TestTest(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gola-cmd")
+	if !assert.NoError(t, err, "TempDir should succeed") {
+		return
+	}
+
+	defer os.RemoveAll(dir)
+
+	for name, source := range map[string]string{
+		"calc.lua": `return {add = function(a, b) return a + b end}`,
+		"calc_test.lua": `
+			local testing = require("testing")
+			local calc = require("calc")
+
+			testing.describe("calc", function()
+				testing.it("adds", function()
+					testing.assert.equal(3, calc.add(1, 2))
+				end)
+
+				testing.it("fails", function()
+					testing.assert.equal({1, 2}, {1, 3})
+				end)
+
+				testing.skip("later")
+			end)
+		`,
+		"sub/ok_test.lua": `
+			local testing = require("testing")
+
+			testing.it("json", function()
+				testing.assert.equal("[1]", require("json").encode({1}))
+			end)
+		`,
+		"sub/calc.lua":      `error("not required")`,
+		"broken/x_test.lua": `testing.it(`,
+	} {
+		path := filepath.Join(dir, name)
+		if !assert.NoError(t, os.MkdirAll(filepath.Dir(path), 0755), "MkdirAll should succeed") {
+			return
+		}
+
+		if !assert.NoError(t, ioutil.WriteFile(path, []byte(source), 0644), "WriteFile should succeed") {
+			return
+		}
+	}
+
+	var stdout, stderr bytes.Buffer
+	if !assert.Equal(t, exitFailure, run([]string{"test", filepath.Join(dir, "calc_test.lua"), filepath.Join(dir, "sub")}, &stdout, &stderr), "exit code mismatching") {
+		return
+	}
+
+	tap := stdout.String()
+	for _, s := range []string{
+		"TAP version 13\n1..4\n",
+		"ok 1 - " + filepath.Join(dir, "calc_test.lua") + ": calc > adds\n",
+		"not ok 2 - " + filepath.Join(dir, "calc_test.lua") + ": calc > fails\n  ---\n  message: |\n",
+		"    -  2\n    +  3\n     }\n  ...\n",
+		"ok 3 - " + filepath.Join(dir, "calc_test.lua") + ": calc > later # SKIP\n",
+		"ok 4 - " + filepath.Join(dir, "sub", "ok_test.lua") + ": json\n",
+	} {
+		if !assert.Contains(t, tap, s, "TAP mismatching") {
+			return
+		}
+	}
+
+	stdout.Reset()
+	if !assert.Equal(t, exitOK, run([]string{"test", "-run", "adds|json", filepath.Join(dir, "calc_test.lua"), filepath.Join(dir, "sub")}, &stdout, &stderr), "exit code mismatching") {
+		return
+	}
+
+	if !assert.Contains(t, stdout.String(), "1..2\n", "TAP mismatching") {
+		return
+	}
+
+	stdout.Reset()
+	if !assert.Equal(t, exitFailure, run([]string{"test", "-format", "junit", dir}, &stdout, &stderr), "exit code mismatching") {
+		return
+	}
+
+	junit := stdout.String()
+	for _, s := range []string{
+		`<testsuites tests="5" failures="2" skipped="1"`,
+		`<testsuite name="` + filepath.Join(dir, "broken", "x_test.lua") + `" tests="1" failures="1" skipped="0"`,
+		`<testcase classname="` + filepath.Join(dir, "calc_test.lua") + ` &gt; calc" name="fails"`,
+		`<failure message="`,
+		`<skipped></skipped>`,
+	} {
+		if !assert.Contains(t, junit, s, "JUnit XML mismatching") {
+			return
+		}
+	}
+
+	if !assert.Equal(t, exitUsage, run([]string{"test", "-format", "xml"}, &stdout, &stderr), "exit code mismatching") {
+		return
+	}
+
+	// the tests and the chunks of test files are stopped after timeout.
+	for name, source := range map[string]string{
+		"loop/test_test.lua": `require("testing").it("loops", function() while true do end end)`,
+		"load/load_test.lua": `while true do end`,
+	} {
+		path := filepath.Join(dir, name)
+		if !assert.NoError(t, os.MkdirAll(filepath.Dir(path), 0755), "MkdirAll should succeed") {
+			return
+		}
+
+		if !assert.NoError(t, ioutil.WriteFile(path, []byte(source), 0644), "WriteFile should succeed") {
+			return
+		}
+
+		stdout.Reset()
+		if !assert.Equal(t, exitFailure, run([]string{"test", "-timeout", "50ms", filepath.Dir(path)}, &stdout, &stderr), "exit code mismatching") {
+			return
+		}
+
+		if !assert.Contains(t, stdout.String(), "timed out after 50ms", "TAP mismatching") {
+			return
+		}
+	}
+}
+
+func TestLint(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gola-cmd")
+	if !assert.NoError(t, err, "TempDir should succeed") {
+		return
+	}
+
+	defer os.RemoveAll(dir)
+
+	for name, source := range map[string]string{
+		"ok.lua":       `local json = require("json") print(json.encode({}))`,
+		"bad.lua":      "local x = 1\nprnit(1)",
+		"rule/ok.lua":  `facts.discount = facts.vip and 0.2 or 0`,
+		"rule/bad.lua": `print(facts.vip)`,
+	} {
+		path := filepath.Join(dir, name)
+		if !assert.NoError(t, os.MkdirAll(filepath.Dir(path), 0755), "MkdirAll should succeed") {
+			return
+		}
+
+		if !assert.NoError(t, ioutil.WriteFile(path, []byte(source), 0644), "WriteFile should succeed") {
+			return
+		}
+	}
+
+	var stdout, stderr bytes.Buffer
+	if !assert.Equal(t, exitOK, run([]string{"lint", filepath.Join(dir, "ok.lua")}, &stdout, &stderr), "exit code mismatching") {
+		return
+	}
+
+	if !assert.Equal(t, exitFailure, run([]string{"lint", filepath.Join(dir, "bad.lua")}, &stdout, &stderr), "exit code mismatching") {
+		return
+	}
+
+	if !assert.Equal(t, filepath.Join(dir, "bad.lua")+":1: unused local x (unused-local)\n"+
+		filepath.Join(dir, "bad.lua")+":2: undefined global prnit (undefined-global)\n", stdout.String(), "output mismatching") {
+		return
+	}
+
+	stdout.Reset()
+	if !assert.Equal(t, exitFailure, run([]string{"lint", "-json", "-reng", filepath.Join(dir, "rule")}, &stdout, &stderr), "exit code mismatching") {
+		return
+	}
+
+	var diags []map[string]interface{}
+	if !assert.NoError(t, json.Unmarshal(stdout.Bytes(), &diags), "Unmarshal should succeed") {
+		return
+	}
+
+	if !assert.Equal(t, []map[string]interface{}{{
+		"file":    filepath.Join(dir, "rule", "bad.lua"),
+		"line":    float64(1),
+		"code":    "stripped",
+		"message": "print is removed by rule engine policy",
+	}}, diags, "diagnostics mismatching") {
+		return
+	}
+}
+
+func TestFmt(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gola-cmd")
+	if !assert.NoError(t, err, "TempDir should succeed") {
+		return
+	}
+
+	defer os.RemoveAll(dir)
+
+	good := filepath.Join(dir, "good.lua")
+	bad := filepath.Join(dir, "bad.lua")
+	if !assert.NoError(t, ioutil.WriteFile(good, []byte("print(1)\n"), 0644), "WriteFile should succeed") {
+		return
+	}
+
+	if !assert.NoError(t, ioutil.WriteFile(bad, []byte("if x then\nprint( 1 )\nend"), 0644), "WriteFile should succeed") {
+		return
+	}
+
+	var stdout, stderr bytes.Buffer
+	if !assert.Equal(t, exitFailure, run([]string{"fmt", "-l", dir}, &stdout, &stderr), "exit code mismatching") {
+		return
+	}
+
+	if !assert.Equal(t, bad+"\n", stdout.String(), "output mismatching") {
+		return
+	}
+
+	stdout.Reset()
+	if !assert.Equal(t, exitFailure, run([]string{"fmt", "-d", bad}, &stdout, &stderr), "exit code mismatching") {
+		return
+	}
+
+	if !assert.Contains(t, stdout.String(), "-print( 1 )\n+\tprint(1)\n", "diff mismatching") {
+		return
+	}
+
+	stdout.Reset()
+	if !assert.Equal(t, exitOK, run([]string{"fmt", "-w", dir}, &stdout, &stderr), "exit code mismatching") {
+		return
+	}
+
+	formatted, err := ioutil.ReadFile(bad)
+	if !assert.NoError(t, err, "ReadFile should succeed") {
+		return
+	}
+
+	if !assert.Equal(t, "if x then\n\tprint(1)\nend\n", string(formatted), "formatted source mismatching") {
+		return
+	}
+
+	if !assert.Equal(t, exitOK, run([]string{"fmt", "-l", dir}, &stdout, &stderr), "exit code mismatching") {
+		return
+	}
+
+	if !assert.Equal(t, "", stdout.String(), "output mismatching") {
+		return
+	}
+}
+
+func TestBundle(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gola-cmd")
+	if !assert.NoError(t, err, "TempDir should succeed") {
+		return
+	}
+
+	defer os.RemoveAll(dir)
+
+	for name, source := range map[string]string{
+		"main.lua":      "local greet = require('greet')\nrequire(os.getenv('MODULE'))\nprint(greet())",
+		"lib/greet.lua": "-- greeting\nreturn function() return 'hi' end",
+	} {
+		path := filepath.Join(dir, name)
+		if !assert.NoError(t, os.MkdirAll(filepath.Dir(path), 0755), "MkdirAll should succeed") {
+			return
+		}
+
+		if !assert.NoError(t, ioutil.WriteFile(path, []byte(source), 0644), "WriteFile should succeed") {
+			return
+		}
+	}
+
+	var stdout, stderr bytes.Buffer
+	if !assert.Equal(t, exitUsage, run([]string{"bundle"}, &stdout, &stderr), "exit code mismatching") {
+		return
+	}
+
+	output := filepath.Join(dir, "bundle.lua")
+	stderr.Reset()
+	if !assert.Equal(t, exitOK, run([]string{"bundle", "-strip", "-I", filepath.Join(dir, "lib"), "-o", output, filepath.Join(dir, "main.lua")}, &stdout, &stderr), "exit code mismatching") {
+		return
+	}
+
+	if !assert.Equal(t, "gola: "+filepath.Join(dir, "main.lua")+":2: dynamic require can not be resolved\n", stderr.String(), "output mismatching") {
+		return
+	}
+
+	source, err := ioutil.ReadFile(output)
+	if !assert.NoError(t, err, "ReadFile should succeed") {
+		return
+	}
+
+	if !assert.Contains(t, string(source), "package.preload[\"greet\"] = function(...)\nreturn function() return 'hi' end\nend\n", "bundle mismatching") {
+		return
+	}
+
+	stdout.Reset()
+	if !assert.Equal(t, exitOK, run([]string{"bundle", "-go", "scripts", filepath.Join(dir, "main.lua")}, &stdout, &stderr), "exit code mismatching") {
+		return
+	}
+
+	if !assert.Contains(t, stdout.String(), "package scripts\n\n// Bundle is lua source bundled from main.lua.\nconst Bundle = ", "go source mismatching") {
+		return
+	}
+}
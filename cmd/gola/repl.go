@@ -0,0 +1,595 @@
+// (c) 2018, Jeff Chen <jefurry@qq.com>
+//
+// This file is part of Gola
+//
+// Copyright 2018 The Gola Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"github.com/mitchellh/go-homedir"
+	"github.com/yuin/gopher-lua"
+	"github.com/yuin/gopher-lua/parse"
+	"golang.org/x/crypto/ssh/terminal"
+	"io"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const replUsage = "repl [-history file]"
+
+const (
+	defaultHistory = "~/.gola_history"
+	// the maximum number of lines saved in history file.
+	maxHistory = 1000
+
+	replPrompt         = "> "
+	replContinuePrompt = ">> "
+
+	// the maximum depth and items of tables printed.
+	maxFormatDepth = 3
+	maxFormatItems = 50
+
+	keyCtrlC = 3
+	keyCtrlN = 14
+	keyCtrlP = 16
+	keyTab   = '\t'
+)
+
+var (
+	completeRegexp = regexp.MustCompile(`(?:[A-Za-z_][A-Za-z0-9_]*[.:])*[A-Za-z_]?[A-Za-z0-9_]*$`)
+	identRegexp    = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+	// up and down keys are read as ^P and ^N, since the terminal browses
+	// only the lines entered in session otherwise.
+	arrowReplacer = strings.NewReplacer("\x1b[A", "\x10", "\x1b[B", "\x0e")
+
+	luaKeywords = []string{
+		"and", "break", "do", "else", "elseif", "end", "false", "for", "function",
+		"if", "in", "local", "nil", "not", "or", "repeat", "return", "then",
+		"true", "until", "while",
+	}
+)
+
+type (
+	// repl evaluates lines in a lua state of runner, the lines are buffered
+	// until they are a complete chunk.
+	repl struct {
+		*runner
+		out io.Writer
+		// lines of incomplete chunk.
+		lines []string
+		// lines entered in session.
+		history []string
+		// lines loaded from history file.
+		saved []string
+		// the number of lines browsed back in history, and the line being
+		// edited before browsing.
+		browsed int
+		pending string
+	}
+
+	// termIO is the terminal of repl.
+	termIO struct {
+		r io.Reader
+		w io.Writer
+	}
+)
+
+func (t *termIO) Read(p []byte) (int, error) {
+	n, err := t.r.Read(p)
+	if n > 0 {
+		n = copy(p, arrowReplacer.Replace(string(p[:n])))
+	}
+
+	return n, err
+}
+
+func (t *termIO) Write(p []byte) (int, error) {
+	return t.w.Write(p)
+}
+
+// runRepl runs read-eval-print loop in a lua state with all libraries of gola.
+// Lines are evaluated as expressions first, and then as statements, the values
+// returned are printed. Incomplete chunks are continued on next lines.
+func runRepl(args []string, stdout, stderr io.Writer) int {
+	flags := flag.NewFlagSet("repl", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	history := flags.String("history", defaultHistory, "history `file`, empty to disable")
+	if err := flags.Parse(args); err != nil {
+		return exitUsage
+	}
+
+	r := newRepl(stdout)
+	defer r.close()
+
+	fd := int(os.Stdin.Fd())
+	if !terminal.IsTerminal(fd) {
+		return r.loop(os.Stdin)
+	}
+
+	path := *history
+	if path != "" {
+		var err error
+		if path, err = homedir.Expand(path); err != nil {
+			fmt.Fprintf(stderr, "gola: %s\n", err)
+
+			return exitFailure
+		}
+	}
+
+	code, err := r.interactive(fd, path)
+	if err != nil {
+		fmt.Fprintf(stderr, "gola: %s\n", err)
+
+		return exitFailure
+	}
+
+	return code
+}
+
+func newRepl(out io.Writer) *repl {
+	return &repl{
+		runner:  newRunner(nil),
+		out:     out,
+		history: make([]string, 0),
+	}
+}
+
+// loop evaluates lines of reader without prompts.
+func (r *repl) loop(reader io.Reader) int {
+	scanner := bufio.NewScanner(reader)
+	for scanner.Scan() {
+		r.input(scanner.Text())
+		if r.exited {
+			return r.code
+		}
+	}
+
+	if len(r.lines) > 0 {
+		fmt.Fprintln(r.out, "incomplete chunk at end of input")
+
+		return exitFailure
+	}
+
+	return exitOK
+}
+
+// interactive evaluates lines of terminal with line editing, completion and
+// history, the terminal is in raw mode only while reading lines.
+func (r *repl) interactive(fd int, path string) (int, error) {
+	r.saved = r.loadHistory(path)
+
+	tio := &termIO{
+		r: os.Stdin,
+		w: os.Stdout,
+	}
+
+	term := terminal.NewTerminal(tio, replPrompt)
+	if width, height, err := terminal.GetSize(fd); err == nil && width > 0 {
+		term.SetSize(width, height)
+	}
+
+	term.AutoCompleteCallback = func(line string, pos int, key rune) (string, int, bool) {
+		switch key {
+		case keyCtrlC:
+			r.lines = nil
+			r.browsed = 0
+			term.SetPrompt(replPrompt)
+			term.Write([]byte("^C\n"))
+
+			return "", 0, true
+		case keyCtrlP:
+			return r.browse(line, 1)
+		case keyCtrlN:
+			return r.browse(line, -1)
+		case keyTab:
+			return r.completeLine(term, line, pos)
+		}
+
+		return "", 0, false
+	}
+
+	state, err := terminal.MakeRaw(fd)
+	if err != nil {
+		return exitFailure, err
+	}
+
+	defer r.saveHistory(path)
+
+	for {
+		line, err := term.ReadLine()
+		if err != nil {
+			terminal.Restore(fd, state)
+			if err == io.EOF {
+				fmt.Fprintln(r.out)
+
+				return exitOK, nil
+			}
+
+			return exitFailure, err
+		}
+
+		r.browsed = 0
+		if strings.TrimSpace(line) != "" {
+			r.history = append(r.history, line)
+		}
+
+		terminal.Restore(fd, state)
+		r.input(line)
+		if r.exited {
+			return r.code, nil
+		}
+
+		if _, err := terminal.MakeRaw(fd); err != nil {
+			return exitFailure, err
+		}
+
+		if len(r.lines) > 0 {
+			term.SetPrompt(replContinuePrompt)
+		} else {
+			term.SetPrompt(replPrompt)
+		}
+	}
+}
+
+// input buffers line, and evaluates the buffered lines if they are a complete chunk.
+func (r *repl) input(line string) {
+	r.lines = append(r.lines, line)
+	source := strings.Join(r.lines, "\n")
+
+	fn, err := r.compile(source)
+	if fn == nil && err == nil {
+		// incomplete chunk.
+		return
+	}
+
+	r.lines = nil
+	if err != nil {
+		fmt.Fprintln(r.out, strings.TrimSpace(err.Error()))
+
+		return
+	}
+
+	L := r.L
+	top := L.GetTop()
+	L.Push(fn)
+	err = L.PCall(0, lua.MultRet, nil)
+	if r.exited {
+		return
+	}
+
+	if err != nil {
+		fmt.Fprintln(r.out, strings.TrimSpace(err.Error()))
+
+		return
+	}
+
+	if n := L.GetTop(); n > top {
+		values := make([]string, 0, n-top)
+		for i := top + 1; i <= n; i++ {
+			values = append(values, r.format(L.Get(i), 0, make(map[*lua.LTable]bool)))
+		}
+
+		fmt.Fprintln(r.out, strings.Join(values, "\t"))
+	}
+	L.SetTop(top)
+}
+
+// compile compiles source as an expression or as statements, it returns
+// nil function and nil error if source is incomplete. Source starting with
+// `=` is an expression like lua 5.1.
+func (r *repl) compile(source string) (*lua.LFunction, error) {
+	if strings.HasPrefix(source, "=") {
+		source = source[1:]
+	}
+
+	expr := "return " + source
+	exprErr := checkSyntax(expr)
+	if exprErr == nil {
+		return r.L.Load(strings.NewReader(expr), "stdin")
+	}
+
+	err := checkSyntax(source)
+	if err == nil {
+		return r.L.Load(strings.NewReader(source), "stdin")
+	}
+
+	// errors at end of source are incomplete chunks.
+	for _, err := range []error{exprErr, err} {
+		if perr, ok := err.(*parse.Error); ok && perr.Pos.Line < 0 {
+			return nil, nil
+		}
+	}
+
+	return r.L.Load(strings.NewReader(source), "stdin")
+}
+
+// format formats value like a lua literal, userdata are shown with their type names.
+func (r *repl) format(lv lua.LValue, depth int, seen map[*lua.LTable]bool) string {
+	L := r.L
+	if mt, ok := L.GetMetatable(lv).(*lua.LTable); ok {
+		if _, ok := mt.RawGetString("__tostring").(*lua.LFunction); ok {
+			s := L.ToStringMeta(lv).String()
+			if name := r.typeName(mt); name != "" {
+				return fmt.Sprintf("%s(%s)", name, s)
+			}
+
+			return s
+		}
+	}
+
+	switch v := lv.(type) {
+	case lua.LString:
+		return strconv.Quote(string(v))
+	case *lua.LUserData:
+		if mt, ok := v.Metatable.(*lua.LTable); ok {
+			if name := r.typeName(mt); name != "" {
+				return fmt.Sprintf("%s: %p", name, v)
+			}
+		}
+	case *lua.LTable:
+		return r.formatTable(v, depth, seen)
+	}
+
+	return lv.String()
+}
+
+func (r *repl) formatTable(tbl *lua.LTable, depth int, seen map[*lua.LTable]bool) string {
+	if seen[tbl] {
+		return "<cycle>"
+	}
+
+	if depth >= maxFormatDepth {
+		return "{...}"
+	}
+
+	seen[tbl] = true
+	defer delete(seen, tbl)
+
+	// the sequence part of table.
+	items := make([]string, 0)
+	n := 0
+	for v := tbl.RawGetInt(1); v != lua.LNil; v = tbl.RawGetInt(n + 1) {
+		items = append(items, r.format(v, depth+1, seen))
+		n += 1
+	}
+
+	keys := make([]lua.LValue, 0)
+	tbl.ForEach(func(k, v lua.LValue) {
+		if num, ok := k.(lua.LNumber); ok && float64(num) == float64(int(num)) && int(num) >= 1 && int(num) <= n {
+			return
+		}
+
+		keys = append(keys, k)
+	})
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i].String() < keys[j].String()
+	})
+
+	for _, k := range keys {
+		key := k.String()
+		if s, ok := k.(lua.LString); !ok || !identRegexp.MatchString(string(s)) {
+			key = "[" + r.format(k, depth+1, seen) + "]"
+		}
+
+		items = append(items, fmt.Sprintf("%s = %s", key, r.format(tbl.RawGet(k), depth+1, seen)))
+	}
+
+	if len(items) == 0 {
+		return "{}"
+	}
+
+	if len(items) > maxFormatItems {
+		items = append(items[:maxFormatItems], "...")
+	}
+
+	return "{" + strings.Join(items, ", ") + "}"
+}
+
+// typeName returns name of type metatable registered by L.NewTypeMetatable.
+func (r *repl) typeName(mt *lua.LTable) string {
+	name := ""
+	if reg, ok := r.L.Get(lua.RegistryIndex).(*lua.LTable); ok {
+		reg.ForEach(func(k, v lua.LValue) {
+			if s, ok := k.(lua.LString); ok && v == mt {
+				name = string(s)
+			}
+		})
+	}
+
+	return name
+}
+
+// completeLine completes word before pos of line, the candidates are printed if
+// they have no longer common prefix.
+func (r *repl) completeLine(term *terminal.Terminal, line string, pos int) (string, int, bool) {
+	word, candidates := r.complete(line[:pos])
+	if len(candidates) == 0 {
+		return "", 0, false
+	}
+
+	common := commonPrefix(candidates)
+	if len(common) > len(word) {
+		return line[:pos] + common[len(word):] + line[pos:], pos + len(common) - len(word), true
+	}
+
+	if len(candidates) > 1 {
+		term.Write([]byte(strings.Join(candidates, "  ") + "\n"))
+	}
+
+	return line, pos, true
+}
+
+// complete returns the partial name at end of prefix, and the globals or table
+// fields starting with it. The fields are looked up without calling metamethods.
+func (r *repl) complete(prefix string) (string, []string) {
+	expr := completeRegexp.FindString(prefix)
+	sep := strings.LastIndexAny(expr, ".:")
+	word := expr[sep+1:]
+
+	candidates := make([]string, 0)
+	seen := make(map[string]bool)
+	add := func(name string) {
+		if strings.HasPrefix(name, word) && !seen[name] {
+			seen[name] = true
+			candidates = append(candidates, name)
+		}
+	}
+
+	var lv lua.LValue = r.L.G.Global
+	if sep < 0 {
+		for _, keyword := range luaKeywords {
+			add(keyword)
+		}
+	} else {
+		for _, name := range strings.FieldsFunc(expr[:sep], func(c rune) bool {
+			return c == '.' || c == ':'
+		}) {
+			if lv = r.field(lv, name); lv == lua.LNil {
+				return word, nil
+			}
+		}
+	}
+
+	for _, name := range r.fields(lv) {
+		add(name)
+	}
+	sort.Strings(candidates)
+
+	return word, candidates
+}
+
+// field returns field of table or userdata by name.
+func (r *repl) field(lv lua.LValue, name string) lua.LValue {
+	for i := 0; i < maxFormatDepth && lv != lua.LNil; i++ {
+		if tbl, ok := lv.(*lua.LTable); ok {
+			if v := tbl.RawGetString(name); v != lua.LNil {
+				return v
+			}
+		}
+
+		lv = r.index(lv)
+	}
+
+	return lua.LNil
+}
+
+// fields returns names of fields of table or userdata, including the fields
+// of `__index` tables of metatables.
+func (r *repl) fields(lv lua.LValue) []string {
+	names := make([]string, 0)
+	for i := 0; i < maxFormatDepth && lv != lua.LNil; i++ {
+		if tbl, ok := lv.(*lua.LTable); ok {
+			tbl.ForEach(func(k, v lua.LValue) {
+				if s, ok := k.(lua.LString); ok && identRegexp.MatchString(string(s)) {
+					names = append(names, string(s))
+				}
+			})
+		}
+
+		lv = r.index(lv)
+	}
+
+	return names
+}
+
+// index returns `__index` table of metatable of value, or nil.
+func (r *repl) index(lv lua.LValue) lua.LValue {
+	mt, ok := r.L.GetMetatable(lv).(*lua.LTable)
+	if !ok {
+		return lua.LNil
+	}
+
+	if tbl, ok := mt.RawGetString("__index").(*lua.LTable); ok {
+		return tbl
+	}
+
+	return lua.LNil
+}
+
+// loadHistory returns lines of history file.
+func (r *repl) loadHistory(path string) []string {
+	if path == "" {
+		return nil
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil
+	}
+
+	lines := make([]string, 0)
+	for _, line := range strings.Split(string(data), "\n") {
+		if strings.TrimSpace(line) != "" {
+			lines = append(lines, line)
+		}
+	}
+
+	return lines
+}
+
+// browse replaces line with the line of history steps back, the saved lines
+// are followed by the lines entered in session.
+func (r *repl) browse(line string, steps int) (string, int, bool) {
+	browsed := r.browsed + steps
+	if browsed < 0 || browsed > len(r.saved)+len(r.history) {
+		return "", 0, false
+	}
+
+	if r.browsed == 0 {
+		r.pending = line
+	}
+	r.browsed = browsed
+
+	entry := r.pending
+	if browsed > 0 {
+		if i := len(r.history) - browsed; i >= 0 {
+			entry = r.history[i]
+		} else {
+			entry = r.saved[len(r.saved)+i]
+		}
+	}
+
+	return entry, len(entry), true
+}
+
+// saveHistory saves the last lines of history to file.
+func (r *repl) saveHistory(path string) {
+	if path == "" {
+		return
+	}
+
+	lines := append(append([]string{}, r.saved...), r.history...)
+	if len(lines) > maxHistory {
+		lines = lines[len(lines)-maxHistory:]
+	}
+
+	ioutil.WriteFile(path, []byte(strings.Join(lines, "\n")+"\n"), 0600)
+}
+
+func checkSyntax(source string) error {
+	_, err := parse.Parse(strings.NewReader(source), "stdin")
+
+	return err
+}
+
+func commonPrefix(names []string) string {
+	prefix := names[0]
+	for _, name := range names[1:] {
+		for !strings.HasPrefix(name, prefix) {
+			prefix = prefix[:len(prefix)-1]
+		}
+	}
+
+	return prefix
+}
@@ -0,0 +1,167 @@
+// (c) 2018, Jeff Chen <jefurry@qq.com>
+//
+// This file is part of Gola
+//
+// Copyright 2018 The Gola Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"strings"
+	"testing"
+)
+
+func TestRepl(t *testing.T) {
+	var out bytes.Buffer
+
+	r := newRepl(&out)
+	defer r.close()
+
+	code := r.loop(strings.NewReader(`
+t = {1, "two", x = {y = true}, [10] = 0}
+t
+function double(n)
+  return n * 2
+end
+double(
+  21
+)
+= 1, nil
+time = require("time")
+time.unix(0, 0):UTC()
+x = )
+`))
+	if !assert.Equal(t, exitOK, code, "exit code mismatching") {
+		return
+	}
+
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if !assert.Len(t, lines, 5, "output mismatching: %s", out.String()) {
+		return
+	}
+
+	if !assert.Equal(t, `{1, "two", [10] = 0, x = {y = true}}`, lines[0], "output mismatching") {
+		return
+	}
+
+	if !assert.Equal(t, "42", lines[1], "output mismatching") {
+		return
+	}
+
+	if !assert.Equal(t, "1\tnil", lines[2], "output mismatching") {
+		return
+	}
+
+	if !assert.True(t, strings.HasPrefix(lines[3], "time.TIME*(1970-01-01 00:00:00"), "output mismatching: %s", lines[3]) {
+		return
+	}
+
+	if !assert.Contains(t, lines[4], "syntax error", "output mismatching") {
+		return
+	}
+
+	out.Reset()
+	if !assert.Equal(t, 7, r.loop(strings.NewReader("os.exit(7)\nprint(1)\n")), "exit code mismatching") {
+		return
+	}
+}
+
+func TestReplIncomplete(t *testing.T) {
+	var out bytes.Buffer
+
+	r := newRepl(&out)
+	defer r.close()
+
+	if !assert.Equal(t, exitFailure, r.loop(strings.NewReader("if true then\n")), "exit code mismatching") {
+		return
+	}
+}
+
+func TestReplComplete(t *testing.T) {
+	var out bytes.Buffer
+
+	r := newRepl(&out)
+	defer r.close()
+
+	r.loop(strings.NewReader(`
+config = {name = "gola", nested = {value = 1}}
+t = require("time").now()
+`))
+
+	for _, v := range []struct {
+		prefix     string
+		word       string
+		candidates []string
+	}{
+		{"x = conf", "conf", []string{"config"}},
+		{"config.n", "n", []string{"name", "nested"}},
+		{"config.nested.v", "v", []string{"value"}},
+		{"string.up", "up", []string{"upper"}},
+		{"t:un", "un", []string{"unix", "unixNano"}},
+		{"whi", "whi", []string{"while"}},
+		{"missing.x", "x", nil},
+	} {
+		word, candidates := r.complete(v.prefix)
+		if !assert.Equal(t, v.word, word, "word mismatching: %s", v.prefix) {
+			return
+		}
+
+		if !assert.Equal(t, v.candidates, candidates, "candidates mismatching: %s", v.prefix) {
+			return
+		}
+	}
+}
+
+func TestReplHistory(t *testing.T) {
+	var out bytes.Buffer
+
+	r := newRepl(&out)
+	defer r.close()
+
+	// saved lines are browsed as they are, without being replayed.
+	r.saved = []string{"print('a\tb')", "x = 1"}
+	r.history = []string{"y = 2"}
+
+	for _, v := range []struct {
+		steps int
+		line  string
+		ok    bool
+	}{
+		{1, "y = 2", true},
+		{1, "x = 1", true},
+		{1, "print('a\tb')", true},
+		{1, "", false},
+		{-1, "x = 1", true},
+		{-1, "y = 2", true},
+		{-1, "pending", true},
+		{-1, "", false},
+	} {
+		line, pos, ok := r.browse("pending", v.steps)
+		if !assert.Equal(t, v.ok, ok, "ok mismatching") {
+			return
+		}
+
+		if !assert.Equal(t, v.line, line, "line mismatching") {
+			return
+		}
+
+		if !assert.Equal(t, len(v.line), pos, "position mismatching") {
+			return
+		}
+	}
+
+	tio := &termIO{r: strings.NewReader("a\x1b[A\x1b[Bb\x1b[C")}
+	p := make([]byte, 16)
+	n, err := tio.Read(p)
+	if !assert.NoError(t, err, "Read should succeed") {
+		return
+	}
+
+	if !assert.Equal(t, "a\x10\x0eb\x1b[C", string(p[:n]), "keys mismatching") {
+		return
+	}
+}
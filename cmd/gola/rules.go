@@ -0,0 +1,114 @@
+// (c) 2018, Jeff Chen <jefurry@qq.com>
+//
+// This file is part of Gola
+//
+// Copyright 2018 The Gola Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"github.com/jefurry/gola/lua/reng"
+	"io"
+	"strings"
+)
+
+const rulesUsage = "rules test [-v] <suite>..."
+
+func runRules(args []string, stdout, stderr io.Writer) int {
+	if len(args) == 0 || args[0] != "test" {
+		fmt.Fprintf(stderr, "usage: gola %s\n", rulesUsage)
+
+		return exitUsage
+	}
+
+	return runRulesTest(args[1:], stdout, stderr)
+}
+
+// runRulesTest runs test suites of rule sets, and exits with exitFailure
+// if any fixture failed.
+func runRulesTest(args []string, stdout, stderr io.Writer) int {
+	flags := flag.NewFlagSet("rules test", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	verbose := flags.Bool("v", false, "print results of passed fixtures")
+	if err := flags.Parse(args); err != nil {
+		return exitUsage
+	}
+
+	if flags.NArg() == 0 {
+		fmt.Fprintf(stderr, "usage: gola %s\n", rulesUsage)
+
+		return exitUsage
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	lpm, err := reng.Default(ctx)
+	if err != nil {
+		fmt.Fprintf(stderr, "gola: %s\n", err)
+
+		return exitFailure
+	}
+	defer lpm.Shutdown(context.Background())
+
+	code := exitOK
+	for _, path := range flags.Args() {
+		suite, err := reng.LoadSuite(path)
+		if err == nil {
+			var results []*reng.TestResult
+			if results, err = suite.Run(ctx, lpm); err == nil {
+				if !report(stdout, path, results, *verbose) {
+					code = exitFailure
+				}
+
+				continue
+			}
+		}
+
+		fmt.Fprintf(stdout, "FAIL\t%s\t%s\n", path, err)
+		code = exitFailure
+	}
+
+	return code
+}
+
+// report prints results of suite, and returns whether all fixtures passed.
+func report(w io.Writer, path string, results []*reng.TestResult, verbose bool) bool {
+	failed := 0
+	for _, r := range results {
+		switch {
+		case r.Err != nil:
+			fmt.Fprintf(w, "--- FAIL: %s (%s)\n\t%s\n", r.Name, r.Duration, r.Err)
+		case !r.Passed:
+			fmt.Fprintf(w, "--- FAIL: %s (%s)\n", r.Name, r.Duration)
+			for _, line := range strings.SplitAfter(r.Diff, "\n") {
+				if line != "" {
+					fmt.Fprintf(w, "\t%s", line)
+				}
+			}
+		default:
+			if verbose {
+				fmt.Fprintf(w, "--- PASS: %s (%s)\n", r.Name, r.Duration)
+			}
+
+			continue
+		}
+
+		failed += 1
+	}
+
+	if failed > 0 {
+		fmt.Fprintf(w, "FAIL\t%s\t%d of %d failed\n", path, failed, len(results))
+
+		return false
+	}
+
+	fmt.Fprintf(w, "ok\t%s\t%d passed\n", path, len(results))
+
+	return true
+}
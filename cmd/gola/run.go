@@ -0,0 +1,247 @@
+// (c) 2018, Jeff Chen <jefurry@qq.com>
+//
+// This file is part of Gola
+//
+// Copyright 2018 The Gola Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"archive/zip"
+	"context"
+	"flag"
+	"fmt"
+	"github.com/jefurry/gola/lua/base"
+	"github.com/jefurry/gola/lua/libs"
+	"github.com/yuin/gopher-lua"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+)
+
+const runUsage = "run [-e chunk]... [-l module]... [-I path]... [-z archive]... [script.lua|- [args...]]"
+
+type (
+	// stringsFlag is a flag which may be repeated.
+	stringsFlag []string
+
+	// runner runs chunks in a lua state with all libraries of gola.
+	runner struct {
+		L      *lua.LState
+		cancel context.CancelFunc
+		// whether os.exit is called, and the exit code of it.
+		exited bool
+		code   int
+	}
+)
+
+func (f *stringsFlag) String() string {
+	return strings.Join(*f, ",")
+}
+
+func (f *stringsFlag) Set(value string) error {
+	*f = append(*f, value)
+
+	return nil
+}
+
+// runRun runs inline chunks and script. The modules of -l are required first
+// and assigned to globals of their names, then the chunks of -e are executed
+// in order, and then the script with `arg` table and arguments as `...`.
+// The zip archives of -z are searched by `require` before package.path.
+// It exits with the code of os.exit, the integer returned by script, or
+// exitFailure if an error is raised.
+func runRun(args []string, stdout, stderr io.Writer) int {
+	var chunks, modules, paths, archives stringsFlag
+
+	flags := flag.NewFlagSet("run", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	flags.Var(&chunks, "e", "execute lua `chunk`")
+	flags.Var(&modules, "l", "require `module` before running script")
+	flags.Var(&paths, "I", "add `path` to package.path")
+	flags.Var(&archives, "z", "search modules in zip `archive` before package.path")
+	if err := flags.Parse(args); err != nil {
+		return exitUsage
+	}
+
+	if flags.NArg() == 0 && len(chunks) == 0 {
+		fmt.Fprintf(stderr, "usage: gola %s\n", runUsage)
+
+		return exitUsage
+	}
+
+	for _, path := range paths {
+		if err := base.AddDefaultPath(path); err != nil {
+			fmt.Fprintf(stderr, "gola: %s\n", err)
+
+			return exitFailure
+		}
+	}
+
+	r := newRunner(flags.Args())
+	defer r.close()
+
+	for _, archive := range archives {
+		rc, err := zip.OpenReader(archive)
+		if err != nil {
+			fmt.Fprintf(stderr, "gola: %s\n", err)
+
+			return exitFailure
+		}
+		defer rc.Close()
+
+		if err := base.AddSource(r.L, base.ZipSource(&rc.Reader)); err != nil {
+			fmt.Fprintf(stderr, "gola: %s\n", err)
+
+			return exitFailure
+		}
+	}
+
+	code, err := r.run(modules, chunks, flags.Args())
+	if err != nil {
+		fmt.Fprintf(stderr, "gola: %s\n", err)
+
+		return exitFailure
+	}
+
+	return code
+}
+
+func newRunner(args []string) *runner {
+	L := lua.NewState()
+	libs.OpenLibs(L)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	L.SetContext(ctx)
+
+	r := &runner{
+		L:      L,
+		cancel: cancel,
+	}
+
+	// os.exit stops the state instead of the process.
+	if mod, ok := L.GetGlobal(lua.OsLibName).(*lua.LTable); ok {
+		mod.RawSetString("exit", L.NewFunction(r.exit))
+	}
+
+	// arg[0] is the script, and arg[-1] is the interpreter.
+	tbl := L.NewTable()
+	tbl.RawSetInt(-1, lua.LString(os.Args[0]))
+	for i, arg := range args {
+		tbl.RawSetInt(i, lua.LString(arg))
+	}
+	L.SetGlobal("arg", tbl)
+
+	return r
+}
+
+func (r *runner) run(modules, chunks, args []string) (int, error) {
+	L := r.L
+
+	for _, module := range modules {
+		err := L.CallByParam(lua.P{
+			Fn:      L.GetGlobal("require"),
+			NRet:    1,
+			Protect: true,
+		}, lua.LString(module))
+		if r.exited {
+			return r.code, nil
+		}
+
+		if err != nil {
+			return exitFailure, err
+		}
+
+		L.SetGlobal(module, L.Get(-1))
+		L.Pop(1)
+	}
+
+	for _, chunk := range chunks {
+		fn, err := L.Load(strings.NewReader(chunk), "(command line)")
+		if err == nil {
+			L.Push(fn)
+			err = L.PCall(0, 0, nil)
+		}
+
+		if r.exited {
+			return r.code, nil
+		}
+
+		if err != nil {
+			return exitFailure, err
+		}
+	}
+
+	if len(args) == 0 {
+		return exitOK, nil
+	}
+
+	fn, err := r.load(args[0])
+	if err != nil {
+		return exitFailure, err
+	}
+
+	top := L.GetTop()
+	L.Push(fn)
+	for _, arg := range args[1:] {
+		L.Push(lua.LString(arg))
+	}
+
+	err = L.PCall(len(args)-1, lua.MultRet, nil)
+	if r.exited {
+		return r.code, nil
+	}
+
+	if err != nil {
+		return exitFailure, err
+	}
+
+	if L.GetTop() > top {
+		if n, ok := L.Get(top + 1).(lua.LNumber); ok {
+			return int(n), nil
+		}
+	}
+
+	return exitOK, nil
+}
+
+// load loads script, or stdin if script is "-".
+func (r *runner) load(script string) (*lua.LFunction, error) {
+	if script != "-" {
+		return r.L.LoadFile(script)
+	}
+
+	data, err := ioutil.ReadAll(os.Stdin)
+	if err != nil {
+		return nil, err
+	}
+
+	return r.L.Load(strings.NewReader(string(data)), "stdin")
+}
+
+// exit is os.exit of runner, it accepts an integer or a boolean like lua 5.2.
+func (r *runner) exit(L *lua.LState) int {
+	code := exitOK
+	switch lv := L.Get(1).(type) {
+	case lua.LNumber:
+		code = int(lv)
+	case lua.LBool:
+		if !bool(lv) {
+			code = exitFailure
+		}
+	}
+
+	r.exited = true
+	r.code = code
+	r.cancel()
+
+	return 0
+}
+
+func (r *runner) close() {
+	r.cancel()
+	r.L.Close()
+}
@@ -0,0 +1,321 @@
+// (c) 2018, Jeff Chen <jefurry@qq.com>
+//
+// This file is part of Gola
+//
+// Copyright 2018 The Gola Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"encoding/xml"
+	"flag"
+	"fmt"
+	"github.com/jefurry/gola/lua/libs/testing"
+	"github.com/yuin/gopher-lua"
+	"io"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+)
+
+const testUsage = "test [-format tap|junit] [-run regexp] [-timeout duration] [path]..."
+
+// the default maximum time of loading a test file, and running a test or hook.
+const defaultTestTimeout = time.Minute
+
+// Output formats of test.
+const (
+	formatTAP   = "tap"
+	formatJUnit = "junit"
+)
+
+type (
+	// fileResult is the result of a test in file.
+	fileResult struct {
+		*testing.Result
+		path string
+	}
+
+	junitSuites struct {
+		XMLName  xml.Name      `xml:"testsuites"`
+		Tests    int           `xml:"tests,attr"`
+		Failures int           `xml:"failures,attr"`
+		Skipped  int           `xml:"skipped,attr"`
+		Time     string        `xml:"time,attr"`
+		Suites   []*junitSuite `xml:"testsuite"`
+	}
+
+	junitSuite struct {
+		Name     string       `xml:"name,attr"`
+		Tests    int          `xml:"tests,attr"`
+		Failures int          `xml:"failures,attr"`
+		Skipped  int          `xml:"skipped,attr"`
+		Time     string       `xml:"time,attr"`
+		Cases    []*junitCase `xml:"testcase"`
+	}
+
+	junitCase struct {
+		ClassName string        `xml:"classname,attr"`
+		Name      string        `xml:"name,attr"`
+		Time      string        `xml:"time,attr"`
+		Failure   *junitFailure `xml:"failure,omitempty"`
+		Skipped   *struct{}     `xml:"skipped,omitempty"`
+	}
+
+	junitFailure struct {
+		Message string `xml:"message,attr"`
+		Text    string `xml:",chardata"`
+	}
+)
+
+// runTest runs the lua tests of *_test.lua files in paths, each file in a
+// fresh state with all libraries of gola, and prints the results in TAP or
+// JUnit XML. It exits with exitFailure if any test failed.
+func runTest(args []string, stdout, stderr io.Writer) int {
+	flags := flag.NewFlagSet("test", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	format := flags.String("format", formatTAP, "output `format`, tap or junit")
+	pattern := flags.String("run", "", "run only tests whose full name matches `regexp`")
+	timeout := flags.Duration("timeout", defaultTestTimeout, "fail each test after `duration`, 0 to disable")
+	if err := flags.Parse(args); err != nil {
+		return exitUsage
+	}
+
+	if *format != formatTAP && *format != formatJUnit {
+		fmt.Fprintf(stderr, "usage: gola %s\n", testUsage)
+
+		return exitUsage
+	}
+
+	var match func(string) bool
+	if *pattern != "" {
+		re, err := regexp.Compile(*pattern)
+		if err != nil {
+			fmt.Fprintf(stderr, "gola: %s\n", err)
+
+			return exitUsage
+		}
+
+		match = re.MatchString
+	}
+
+	paths := flags.Args()
+	if len(paths) == 0 {
+		paths = []string{"."}
+	}
+
+	files, err := findFiles(paths, "_test.lua")
+	if err != nil {
+		fmt.Fprintf(stderr, "gola: %s\n", err)
+
+		return exitFailure
+	}
+
+	if len(files) == 0 {
+		fmt.Fprintln(stderr, "gola: no test files")
+
+		return exitOK
+	}
+
+	results := make([]*fileResult, 0)
+	for _, path := range files {
+		results = append(results, testFile(path, match, *timeout)...)
+	}
+
+	if *format == formatJUnit {
+		err = writeJUnit(stdout, files, results)
+	} else {
+		writeTAP(stdout, results)
+	}
+
+	if err != nil {
+		fmt.Fprintf(stderr, "gola: %s\n", err)
+
+		return exitFailure
+	}
+
+	for _, r := range results {
+		if r.Status == testing.StatusFail {
+			return exitFailure
+		}
+	}
+
+	return exitOK
+}
+
+// testFile runs tests of file, the modules in directory of file can be
+// required. An error of loading file is reported as a failed test of file.
+// Loading file, and each test and hook of it, fail after timeout.
+func testFile(path string, match func(string) bool, timeout time.Duration) []*fileResult {
+	r := newRunner([]string{path})
+	defer r.close()
+
+	L := r.L
+	if mod, ok := L.GetGlobal(lua.LoadLibName).(*lua.LTable); ok {
+		dir := filepath.Join(filepath.Dir(path), "?.lua")
+		mod.RawSetString("path", lua.LString(dir+";"+lua.LVAsString(mod.RawGetString("path"))))
+	}
+
+	start := time.Now()
+	fn, err := L.LoadFile(path)
+	if err == nil {
+		err = loadTests(L, fn, timeout)
+	}
+
+	if err == nil && r.exited {
+		err = fmt.Errorf("os.exit(%d) called", r.code)
+	}
+
+	if err != nil {
+		msg := err.Error()
+		if aerr, ok := err.(*lua.ApiError); ok {
+			msg = aerr.Object.String()
+		}
+
+		return []*fileResult{&fileResult{
+			Result: &testing.Result{
+				Status:   testing.StatusFail,
+				Message:  msg,
+				Duration: time.Since(start),
+			},
+			path: path,
+		}}
+	}
+
+	results := make([]*fileResult, 0)
+	for _, result := range testing.RunTimeout(L, match, timeout) {
+		results = append(results, &fileResult{
+			Result: result,
+			path:   path,
+		})
+	}
+
+	return results
+}
+
+// loadTests runs chunk of test file, which registers the tests.
+func loadTests(L *lua.LState, fn *lua.LFunction, timeout time.Duration) error {
+	L.Push(fn)
+	if timeout <= 0 {
+		return L.PCall(0, 0, nil)
+	}
+
+	parent := L.Context()
+	defer L.SetContext(parent)
+
+	ctx, cancel := context.WithTimeout(parent, timeout)
+	defer cancel()
+	L.SetContext(ctx)
+
+	err := L.PCall(0, 0, nil)
+	if err != nil && ctx.Err() == context.DeadlineExceeded && parent.Err() == nil {
+		return fmt.Errorf("timed out after %s", timeout)
+	}
+
+	return err
+}
+
+func (r *fileResult) name() string {
+	if r.Test == "" {
+		return r.path
+	}
+
+	return r.path + ": " + r.Name()
+}
+
+// writeTAP writes results in Test Anything Protocol version 13.
+func writeTAP(w io.Writer, results []*fileResult) {
+	fmt.Fprintf(w, "TAP version 13\n1..%d\n", len(results))
+	for i, r := range results {
+		switch r.Status {
+		case testing.StatusPass:
+			fmt.Fprintf(w, "ok %d - %s\n", i+1, r.name())
+		case testing.StatusSkip:
+			fmt.Fprintf(w, "ok %d - %s # SKIP\n", i+1, r.name())
+		default:
+			fmt.Fprintf(w, "not ok %d - %s\n", i+1, r.name())
+			fmt.Fprintf(w, "  ---\n  message: |\n")
+			for _, line := range strings.Split(r.Message, "\n") {
+				fmt.Fprintf(w, "    %s\n", line)
+			}
+			fmt.Fprintf(w, "  ...\n")
+		}
+	}
+}
+
+// writeJUnit writes results in JUnit XML, a test suite per file.
+func writeJUnit(w io.Writer, files []string, results []*fileResult) error {
+	suites := &junitSuites{
+		Suites: make([]*junitSuite, 0, len(files)),
+	}
+
+	var total time.Duration
+	for _, path := range files {
+		suite := &junitSuite{
+			Name:  path,
+			Cases: make([]*junitCase, 0),
+		}
+
+		var elapsed time.Duration
+		for _, r := range results {
+			if r.path != path {
+				continue
+			}
+
+			c := &junitCase{
+				ClassName: strings.Join(append([]string{path}, r.Suites...), " > "),
+				Name:      r.Test,
+				Time:      seconds(r.Duration),
+			}
+			if c.Name == "" {
+				c.Name = path
+			}
+
+			switch r.Status {
+			case testing.StatusFail:
+				c.Failure = &junitFailure{
+					Message: strings.SplitN(r.Message, "\n", 2)[0],
+					Text:    r.Message,
+				}
+				suite.Failures++
+			case testing.StatusSkip:
+				c.Skipped = &struct{}{}
+				suite.Skipped++
+			}
+
+			suite.Tests++
+			suite.Cases = append(suite.Cases, c)
+			elapsed += r.Duration
+		}
+
+		suite.Time = seconds(elapsed)
+		suites.Tests += suite.Tests
+		suites.Failures += suite.Failures
+		suites.Skipped += suite.Skipped
+		suites.Suites = append(suites.Suites, suite)
+		total += elapsed
+	}
+	suites.Time = seconds(total)
+
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(suites); err != nil {
+		return err
+	}
+
+	_, err := io.WriteString(w, "\n")
+
+	return err
+}
+
+func seconds(d time.Duration) string {
+	return fmt.Sprintf("%.3f", d.Seconds())
+}
@@ -0,0 +1,251 @@
+// (c) 2018, Jeff Chen <jefurry@qq.com>
+//
+// This file is part of Gola
+//
+// Copyright 2018 The Gola Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package base
+
+import (
+	"archive/zip"
+	"bytes"
+	"fmt"
+	"github.com/pkg/errors"
+	"github.com/yuin/gopher-lua"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+)
+
+const (
+	sourcesKey = "base.SOURCES*"
+)
+
+var (
+	errNoLoaders = errors.New("package.loaders must be a table, the package library is not opened")
+)
+
+// patterns of module files in sources, like package.path.
+var sourcePatterns = []string{"?.lua", "?/init.lua"}
+
+type (
+	// Source is a virtual filesystem of lua modules searched by `require`.
+	Source interface {
+		// ReadFile returns content of file at slash-separated path, the
+		// error is os.ErrNotExist if the file does not exist.
+		ReadFile(name string) ([]byte, error)
+	}
+
+	// MapSource is an in-memory source of files by slash-separated path.
+	MapSource map[string]string
+
+	zipSource struct {
+		files map[string]*zip.File
+	}
+
+	fileSystemSource struct {
+		fs http.FileSystem
+	}
+
+	// sources of a lua state, searched in order.
+	sources struct {
+		list []Source
+	}
+)
+
+// ZipSource creates source of files in zip archive r, e.g. the Reader of
+// zip.OpenReader for plugins.
+func ZipSource(r *zip.Reader) Source {
+	zs := &zipSource{
+		files: make(map[string]*zip.File, len(r.File)),
+	}
+
+	for _, f := range r.File {
+		zs.files[path.Clean(f.Name)] = f
+	}
+
+	return zs
+}
+
+// FileSystemSource creates source of files in fs, e.g. http.Dir or the
+// filesystems of assets embedded into go binary.
+func FileSystemSource(fs http.FileSystem) Source {
+	return &fileSystemSource{
+		fs: fs,
+	}
+}
+
+// AddSource adds source to the end of sources of L.
+// Note: The sources are searched by `require` after package.preload and
+// before package.path.
+func AddSource(L *lua.LState, src Source) error {
+	s, err := getSources(L)
+	if err != nil {
+		return err
+	}
+
+	s.list = append(s.list, src)
+
+	return nil
+}
+
+// SetSources replaces sources of L, they are searched in order.
+func SetSources(L *lua.LState, list ...Source) error {
+	s, err := getSources(L)
+	if err != nil {
+		return err
+	}
+
+	s.list = append(make([]Source, 0, len(list)), list...)
+
+	return nil
+}
+
+// Sources returns sources of L in order of searching.
+func Sources(L *lua.LState) []Source {
+	registry := L.Get(lua.RegistryIndex).(*lua.LTable)
+	if ud, ok := registry.RawGetString(sourcesKey).(*lua.LUserData); ok {
+		if s, ok := ud.Value.(*sources); ok {
+			return append(make([]Source, 0, len(s.list)), s.list...)
+		}
+	}
+
+	return []Source{}
+}
+
+// getSources returns sources of L, the loader of sources is inserted into
+// package.loaders after the loader of package.preload at the first call.
+func getSources(L *lua.LState) (*sources, error) {
+	registry := L.Get(lua.RegistryIndex).(*lua.LTable)
+	if ud, ok := registry.RawGetString(sourcesKey).(*lua.LUserData); ok {
+		if s, ok := ud.Value.(*sources); ok {
+			return s, nil
+		}
+	}
+
+	loaders, ok := registry.RawGetString("_LOADERS").(*lua.LTable)
+	if !ok {
+		return nil, errNoLoaders
+	}
+
+	s := &sources{
+		list: make([]Source, 0),
+	}
+
+	ud := L.NewUserData()
+	ud.Value = s
+	registry.RawSetString(sourcesKey, ud)
+
+	pos := 2
+	if loaders.Len() == 0 {
+		pos = 1
+	}
+	loaders.Insert(pos, L.NewClosure(sourcesLoader, ud))
+
+	return s, nil
+}
+
+// sourcesLoader loads module from sources, like the loaders of package.
+func sourcesLoader(L *lua.LState) int {
+	name := L.CheckString(1)
+	s := L.CheckUserData(lua.UpvalueIndex(1)).Value.(*sources)
+
+	file := strings.Replace(name, ".", "/", -1)
+	messages := make([]string, 0)
+	for i, src := range s.list {
+		for _, pattern := range sourcePatterns {
+			p := strings.Replace(pattern, "?", file, -1)
+			data, err := src.ReadFile(p)
+			if err != nil {
+				if os.IsNotExist(errors.Cause(err)) {
+					messages = append(messages, fmt.Sprintf("no file '%s' in source %d", p, i+1))
+
+					continue
+				}
+
+				L.RaiseError("error loading module '%s' from source %d: %s", name, i+1, err)
+			}
+
+			fn, err := L.Load(bytes.NewReader(trimShebang(data)), p)
+			if err != nil {
+				L.RaiseError("%s", err)
+			}
+
+			L.Push(fn)
+
+			return 1
+		}
+	}
+
+	if len(messages) == 0 {
+		return 0
+	}
+
+	L.Push(lua.LString(strings.Join(messages, "\n\t")))
+
+	return 1
+}
+
+// trimShebang empties the shebang line, which is skipped by lua.LoadFile.
+func trimShebang(data []byte) []byte {
+	if !bytes.HasPrefix(data, []byte("#")) {
+		return data
+	}
+
+	if i := bytes.IndexByte(data, '\n'); i >= 0 {
+		return data[i:]
+	}
+
+	return nil
+}
+
+func (ms MapSource) ReadFile(name string) ([]byte, error) {
+	data, ok := ms[path.Clean(name)]
+	if !ok {
+		return nil, os.ErrNotExist
+	}
+
+	return []byte(data), nil
+}
+
+func (zs *zipSource) ReadFile(name string) ([]byte, error) {
+	f, ok := zs.files[path.Clean(name)]
+	if !ok || f.FileInfo().IsDir() {
+		return nil, os.ErrNotExist
+	}
+
+	rc, err := f.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+
+	return ioutil.ReadAll(rc)
+}
+
+func (fs *fileSystemSource) ReadFile(name string) ([]byte, error) {
+	f, err := fs.fs.Open("/" + path.Clean(name))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, os.ErrNotExist
+		}
+
+		return nil, err
+	}
+	defer f.Close()
+
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	if fi.IsDir() {
+		return nil, os.ErrNotExist
+	}
+
+	return ioutil.ReadAll(f)
+}
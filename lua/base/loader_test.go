@@ -0,0 +1,152 @@
+// (c) 2018, Jeff Chen <jefurry@qq.com>
+//
+// This file is part of Gola
+//
+// Copyright 2018 The Gola Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package base
+
+import (
+	"archive/zip"
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"github.com/yuin/gopher-lua"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSources(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gola-base")
+	if !assert.NoError(t, err, "TempDir should succeed") {
+		return
+	}
+	defer os.RemoveAll(dir)
+
+	for name, source := range map[string]string{
+		"disk.lua":       `return "disk"`,
+		"shadowed.lua":   `return "disk"`,
+		"fs/init.lua":    `return "fs"`,
+		"fs/nested.lua":  `return "fs.nested"`,
+		"both.lua":       `return "fs"`,
+		"with/error.lua": `return =`,
+	} {
+		path := filepath.Join(dir, name)
+		if !assert.NoError(t, os.MkdirAll(filepath.Dir(path), 0755), "MkdirAll should succeed") {
+			return
+		}
+
+		if !assert.NoError(t, ioutil.WriteFile(path, []byte(source), 0644), "WriteFile should succeed") {
+			return
+		}
+	}
+
+	var buf bytes.Buffer
+	w := zip.NewWriter(&buf)
+	for name, source := range map[string]string{
+		"plugin/init.lua": "#!/usr/bin/env gola\nreturn 'zip'",
+		"both.lua":        `return "zip"`,
+	} {
+		f, err := w.Create(name)
+		if !assert.NoError(t, err, "Create should succeed") {
+			return
+		}
+
+		if _, err := f.Write([]byte(source)); !assert.NoError(t, err, "Write should succeed") {
+			return
+		}
+	}
+
+	if !assert.NoError(t, w.Close(), "Close should succeed") {
+		return
+	}
+
+	zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if !assert.NoError(t, err, "NewReader should succeed") {
+		return
+	}
+
+	bare := lua.NewState(lua.Options{SkipOpenLibs: true})
+	defer bare.Close()
+
+	if !assert.Error(t, AddSource(bare, MapSource{}), "AddSource should fail without package library") {
+		return
+	}
+
+	L := lua.NewState()
+	defer L.Close()
+
+	memory := MapSource{
+		"shadowed.lua": `return "memory"`,
+		"memory.lua":   `return "memory"`,
+	}
+	if !assert.NoError(t, AddSource(L, memory), "AddSource should succeed") {
+		return
+	}
+
+	if !assert.NoError(t, AddSource(L, ZipSource(zr)), "AddSource should succeed") {
+		return
+	}
+
+	fs := FileSystemSource(http.Dir(dir))
+	if !assert.NoError(t, AddSource(L, fs), "AddSource should succeed") {
+		return
+	}
+
+	if !assert.Len(t, Sources(L), 3, "length of sources mismatching") {
+		return
+	}
+
+	L.SetField(L.GetGlobal(lua.LoadLibName), "path", lua.LString(filepath.Join(dir, "?.lua")))
+
+	for name, value := range map[string]string{
+		"disk":      "disk",
+		"shadowed":  "memory",
+		"memory":    "memory",
+		"plugin":    "zip",
+		"both":      "zip",
+		"fs":        "fs",
+		"fs.nested": "fs.nested",
+	} {
+		if !assert.NoError(t, L.DoString(`result = require("`+name+`")`), "require should succeed: %s", name) {
+			return
+		}
+
+		if !assert.Equal(t, value, L.GetGlobal("result").String(), "module mismatching: %s", name) {
+			return
+		}
+	}
+
+	if !assert.Error(t, L.DoString(`require("with.error")`), "require should fail with syntax error") {
+		return
+	}
+
+	err = L.DoString(`require("missing")`)
+	if !assert.Error(t, err, "require should fail") {
+		return
+	}
+
+	if !assert.Contains(t, err.Error(), "no file 'missing.lua' in source 1", "error mismatching") {
+		return
+	}
+
+	// the order of sources is configurable.
+	L2 := lua.NewState()
+	defer L2.Close()
+
+	if !assert.NoError(t, SetSources(L2, fs, ZipSource(zr)), "SetSources should succeed") {
+		return
+	}
+
+	if !assert.NoError(t, L2.DoString(`result = require("both")`), "require should succeed") {
+		return
+	}
+
+	if !assert.Equal(t, "fs", L2.GetGlobal("result").String(), "module mismatching") {
+		return
+	}
+}
@@ -0,0 +1,297 @@
+// (c) 2018, Jeff Chen <jefurry@qq.com>
+//
+// This file is part of Gola
+//
+// Copyright 2018 The Gola Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+// Package bundle implements bundling of a Lua script and the local modules it
+// requires into a single file.
+//
+// The calls of `require` with constant names are resolved statically against
+// the directory of main script and the search paths, like `?.lua` and
+// `?/init.lua` of package.path, and the modules found are inlined into
+// package.preload of the bundle. The modules of gola libraries are left to
+// the lua states running the bundle, even if local files of the same names
+// exist.
+package bundle
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/jefurry/gola/lua/format"
+	"github.com/jefurry/gola/lua/libs"
+	"github.com/pkg/errors"
+	"github.com/yuin/gopher-lua"
+	goformat "go/format"
+	"go/token"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+var ErrInvalidIdentifier = errors.New("invalid go identifier")
+
+type (
+	// Bundler resolves the modules required by main script.
+	Bundler struct {
+		// Directories to search modules, after the directory of main script.
+		Paths []string
+
+		// Whether to strip comments from sources.
+		StripComments bool
+	}
+
+	// Module is a lua module inlined into bundle.
+	Module struct {
+		Name   string
+		Path   string
+		Source []byte
+	}
+
+	// Unresolved is a call of `require` which can not be resolved statically,
+	// the module is left to package.path of lua states running the bundle. The
+	// modules of gola libraries shadowing local files are reported too.
+	Unresolved struct {
+		File    string
+		Line    int
+		Message string
+	}
+
+	// Bundle is main script with the modules it requires.
+	Bundle struct {
+		Main       *Module
+		Modules    []*Module
+		Unresolved []*Unresolved
+	}
+)
+
+var (
+	golaModules     map[string]bool
+	golaModulesOnce sync.Once
+)
+
+// New creates bundler.
+func New() *Bundler {
+	return &Bundler{
+		Paths: make([]string, 0),
+	}
+}
+
+// Bundle resolves the modules required by main script recursively.
+// Note: Syntax errors of main script and modules are returned as error.
+func (b *Bundler) Bundle(main string) (*Bundle, error) {
+	m, err := b.load("", main)
+	if err != nil {
+		return nil, err
+	}
+
+	bd := &Bundle{
+		Main:       m,
+		Modules:    make([]*Module, 0),
+		Unresolved: make([]*Unresolved, 0),
+	}
+
+	paths := append([]string{filepath.Dir(main)}, b.Paths...)
+	seen := map[string]bool{}
+	queue := []*Module{m}
+	for len(queue) > 0 {
+		m, queue = queue[0], queue[1:]
+
+		requires, err := findRequires(m)
+		if err != nil {
+			return nil, err
+		}
+
+		// the comments are stripped after requires found, so that the lines
+		// of unresolved requires are the lines of source files.
+		if b.StripComments {
+			if m.Source, err = format.StripComments(m.Source); err != nil {
+				return nil, errors.Wrap(err, m.Path)
+			}
+		}
+
+		for _, r := range requires {
+			if r.name == "" {
+				bd.unresolved(m, r.line, "dynamic require can not be resolved")
+
+				continue
+			}
+
+			if seen[r.name] {
+				continue
+			}
+			seen[r.name] = true
+
+			// the modules of gola libraries are loaded before package.path,
+			// so they are not shadowed by local files.
+			path := search(paths, r.name)
+			if modules()[r.name] {
+				if path != "" {
+					bd.unresolved(m, r.line, fmt.Sprintf("module %q of gola libraries shadows %s", r.name, path))
+				}
+
+				continue
+			}
+
+			if path == "" {
+				bd.unresolved(m, r.line, fmt.Sprintf("module %q not found", r.name))
+
+				continue
+			}
+
+			mod, err := b.load(r.name, path)
+			if err != nil {
+				return nil, err
+			}
+
+			bd.Modules = append(bd.Modules, mod)
+			queue = append(queue, mod)
+		}
+	}
+
+	return bd, nil
+}
+
+func (b *Bundler) load(name, path string) (*Module, error) {
+	src, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Module{
+		Name:   name,
+		Path:   path,
+		Source: src,
+	}, nil
+}
+
+func (bd *Bundle) unresolved(m *Module, line int, msg string) {
+	bd.Unresolved = append(bd.Unresolved, &Unresolved{
+		File:    m.Path,
+		Line:    line,
+		Message: msg,
+	})
+}
+
+// Lua returns lua source of bundle, the modules are assigned to
+// package.preload before main script.
+func (bd *Bundle) Lua() []byte {
+	var buf bytes.Buffer
+
+	// the shebang line of main script is kept first.
+	main := bd.Main.Source
+	if bytes.HasPrefix(main, []byte("#")) {
+		i := bytes.IndexByte(main, '\n')
+		if i < 0 {
+			i = len(main)
+		}
+		buf.Write(main[:i])
+		buf.WriteString("\n")
+		main = main[i:]
+	}
+
+	fmt.Fprintf(&buf, "-- Code generated by gola bundle from %s; DO NOT EDIT.\n", filepath.Base(bd.Main.Path))
+
+	for _, m := range bd.Modules {
+		fmt.Fprintf(&buf, "\npackage.preload[%s] = function(...)\n", strconv.Quote(m.Name))
+		buf.Write(bytes.TrimRight(trimShebang(m.Source), "\r\n"))
+		buf.WriteString("\nend\n")
+	}
+
+	buf.WriteString("\n")
+	buf.Write(bytes.TrimLeft(main, "\n"))
+
+	return buf.Bytes()
+}
+
+// Go returns go source of package pkg with the lua source of bundle as
+// constant name.
+func (bd *Bundle) Go(pkg, name string) ([]byte, error) {
+	if !isGoIdent(pkg) || pkg == "_" {
+		return nil, errors.Wrapf(ErrInvalidIdentifier, "package %q", pkg)
+	}
+
+	if !isGoIdent(name) {
+		return nil, errors.Wrapf(ErrInvalidIdentifier, "constant %q", name)
+	}
+
+	var buf bytes.Buffer
+	base := filepath.Base(bd.Main.Path)
+	fmt.Fprintf(&buf, "// Code generated by gola bundle from %s; DO NOT EDIT.\n\n", base)
+	fmt.Fprintf(&buf, "package %s\n\n", pkg)
+	fmt.Fprintf(&buf, "// %s is lua source bundled from %s.\n", name, base)
+	fmt.Fprintf(&buf, "const %s = %s\n", name, strconv.Quote(string(bd.Lua())))
+
+	return goformat.Source(buf.Bytes())
+}
+
+func (u *Unresolved) String() string {
+	return fmt.Sprintf("%s:%d: %s", u.File, u.Line, u.Message)
+}
+
+// search returns the file of module in paths, or "" if not found.
+func search(paths []string, name string) string {
+	rel := filepath.FromSlash(strings.Replace(name, ".", "/", -1))
+	for _, dir := range paths {
+		for _, path := range []string{
+			filepath.Join(dir, rel+".lua"),
+			filepath.Join(dir, rel, "init.lua"),
+		} {
+			if fi, err := os.Stat(path); err == nil && !fi.IsDir() {
+				return path
+			}
+		}
+	}
+
+	return ""
+}
+
+// modules returns the names of modules loaded or preloaded by libs.OpenLibs.
+func modules() map[string]bool {
+	golaModulesOnce.Do(func() {
+		L := lua.NewState()
+		defer L.Close()
+		libs.OpenLibs(L)
+
+		golaModules = make(map[string]bool)
+		pkg, ok := L.GetGlobal(lua.LoadLibName).(*lua.LTable)
+		if !ok {
+			return
+		}
+
+		for _, field := range []string{"loaded", "preload"} {
+			if tbl, ok := L.GetField(pkg, field).(*lua.LTable); ok {
+				tbl.ForEach(func(k, _ lua.LValue) {
+					if name, ok := k.(lua.LString); ok {
+						golaModules[string(name)] = true
+					}
+				})
+			}
+		}
+	})
+
+	return golaModules
+}
+
+func trimShebang(src []byte) []byte {
+	if !bytes.HasPrefix(src, []byte("#")) {
+		return src
+	}
+
+	// the line is kept empty so that the line numbers are not changed.
+	if i := bytes.IndexByte(src, '\n'); i >= 0 {
+		return src[i:]
+	}
+
+	return nil
+}
+
+// isGoIdent reports whether s is a go identifier, keywords are not.
+func isGoIdent(s string) bool {
+	return token.IsIdentifier(s)
+}
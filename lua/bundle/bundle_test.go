@@ -0,0 +1,170 @@
+// (c) 2018, Jeff Chen <jefurry@qq.com>
+//
+// This file is part of Gola
+//
+// Copyright 2018 The Gola Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package bundle
+
+import (
+	"github.com/jefurry/gola/lua/libs"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/yuin/gopher-lua"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func writeFiles(t *testing.T, files map[string]string) (string, bool) {
+	dir, err := ioutil.TempDir("", "gola-bundle")
+	if !assert.NoError(t, err, "TempDir should succeed") {
+		return "", false
+	}
+
+	for name, source := range files {
+		path := filepath.Join(dir, name)
+		if !assert.NoError(t, os.MkdirAll(filepath.Dir(path), 0755), "MkdirAll should succeed") {
+			return dir, false
+		}
+
+		if !assert.NoError(t, ioutil.WriteFile(path, []byte(source), 0644), "WriteFile should succeed") {
+			return dir, false
+		}
+	}
+
+	return dir, true
+}
+
+func TestBundle(t *testing.T) {
+	dir, ok := writeFiles(t, map[string]string{
+		"main.lua": `#!/usr/bin/env gola
+			-- main script
+			local json = require("json")
+			local greet = require "app.greet"
+			local name = "util"
+			local dyn = pcall(require, name) and require(name)
+			local missing = pcall(function() return require("missing") end)
+			return json.encode({greet("gola"), missing})`,
+		"app/greet.lua": `local util = require("util") -- helper
+			return function(name) return util.upper("hello, " .. name) end`,
+		"app/util.lua": `return {}`,
+		"json.lua":     `error("shadowed by gola library")`,
+		"lib/util/init.lua": `
+			local require = function() end
+			require("ignored")
+			return {upper = string.upper}`,
+	})
+	defer os.RemoveAll(dir)
+	if !ok {
+		return
+	}
+
+	b := New()
+	b.Paths = append(b.Paths, filepath.Join(dir, "lib"))
+	b.StripComments = true
+
+	bd, err := b.Bundle(filepath.Join(dir, "main.lua"))
+	if !assert.NoError(t, err, "Bundle should succeed") {
+		return
+	}
+
+	names := make([]string, 0, len(bd.Modules))
+	for _, m := range bd.Modules {
+		names = append(names, m.Name)
+	}
+
+	if !assert.Equal(t, []string{"app.greet", "util"}, names, "modules mismatching") {
+		return
+	}
+
+	if !assert.Equal(t, filepath.Join(dir, "lib", "util", "init.lua"), bd.Modules[1].Path, "path of module mismatching") {
+		return
+	}
+
+	unresolved := make([]string, 0, len(bd.Unresolved))
+	for _, u := range bd.Unresolved {
+		unresolved = append(unresolved, u.String())
+	}
+
+	main := filepath.Join(dir, "main.lua")
+	if !assert.Equal(t, []string{
+		main + ":3: module \"json\" of gola libraries shadows " + filepath.Join(dir, "json.lua"),
+		main + ":6: dynamic require can not be resolved",
+		main + ":7: module \"missing\" not found",
+	}, unresolved, "unresolved requires mismatching") {
+		return
+	}
+
+	source := string(bd.Lua())
+	if !assert.True(t, strings.HasPrefix(source, "#!/usr/bin/env gola\n-- Code generated by gola bundle from main.lua; DO NOT EDIT.\n"), "header mismatching: %s", source) {
+		return
+	}
+
+	if !assert.NotContains(t, source, "-- helper", "comments should be stripped") {
+		return
+	}
+
+	// the bundle runs without the files of modules.
+	if !assert.NoError(t, os.RemoveAll(dir), "RemoveAll should succeed") {
+		return
+	}
+
+	path := filepath.Join(dir, "bundle.lua")
+	if !assert.NoError(t, os.MkdirAll(dir, 0755), "MkdirAll should succeed") {
+		return
+	}
+
+	if !assert.NoError(t, ioutil.WriteFile(path, []byte(source), 0644), "WriteFile should succeed") {
+		return
+	}
+
+	L := lua.NewState()
+	defer L.Close()
+	libs.OpenLibs(L)
+
+	if !assert.NoError(t, L.DoFile(path), "DoFile should succeed") {
+		return
+	}
+
+	if !assert.Equal(t, `["HELLO, GOLA",false]`, L.Get(-1).String(), "result mismatching") {
+		return
+	}
+}
+
+func TestBundleGo(t *testing.T) {
+	dir, ok := writeFiles(t, map[string]string{
+		"main.lua": `return "x"`,
+	})
+	defer os.RemoveAll(dir)
+	if !ok {
+		return
+	}
+
+	bd, err := New().Bundle(filepath.Join(dir, "main.lua"))
+	if !assert.NoError(t, err, "Bundle should succeed") {
+		return
+	}
+
+	for _, v := range [][2]string{{"main", "1x"}, {"func", "Main"}, {"_", "Main"}, {"main", "type"}} {
+		if _, err := bd.Go(v[0], v[1]); !assert.Equal(t, ErrInvalidIdentifier, errors.Cause(err), "Go should fail with invalid name: %v", v) {
+			return
+		}
+	}
+
+	source, err := bd.Go("scripts", "Main")
+	if !assert.NoError(t, err, "Go should succeed") {
+		return
+	}
+
+	if !assert.Equal(t, "// Code generated by gola bundle from main.lua; DO NOT EDIT.\n\n"+
+		"package scripts\n\n"+
+		"// Main is lua source bundled from main.lua.\n"+
+		"const Main = \"-- Code generated by gola bundle from main.lua; DO NOT EDIT.\\n\\nreturn \\\"x\\\"\"\n", string(source), "go source mismatching") {
+		return
+	}
+}
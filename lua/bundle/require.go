@@ -0,0 +1,197 @@
+// (c) 2018, Jeff Chen <jefurry@qq.com>
+//
+// This file is part of Gola
+//
+// Copyright 2018 The Gola Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package bundle
+
+import (
+	"bytes"
+	"github.com/yuin/gopher-lua/ast"
+	"github.com/yuin/gopher-lua/parse"
+)
+
+type (
+	// require is a call of global `require`, name is "" if the argument is
+	// not a constant string.
+	require struct {
+		name string
+		line int
+	}
+
+	// finder finds the calls of `require` in chunk.
+	finder struct {
+		// the local names in scopes, to skip the calls of a local `require`.
+		scopes   []map[string]bool
+		requires []*require
+	}
+)
+
+// findRequires returns the calls of `require` in source of module in order.
+func findRequires(m *Module) ([]*require, error) {
+	chunk, err := parse.Parse(bytes.NewReader(trimShebang(m.Source)), m.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	f := &finder{
+		scopes:   make([]map[string]bool, 0),
+		requires: make([]*require, 0),
+	}
+	f.block(chunk)
+
+	return f.requires, nil
+}
+
+func (f *finder) block(stmts []ast.Stmt, names ...string) {
+	f.scopes = append(f.scopes, make(map[string]bool))
+	f.declare(names...)
+
+	for _, stmt := range stmts {
+		f.stmt(stmt)
+	}
+
+	f.scopes = f.scopes[:len(f.scopes)-1]
+}
+
+func (f *finder) declare(names ...string) {
+	scope := f.scopes[len(f.scopes)-1]
+	for _, name := range names {
+		scope[name] = true
+	}
+}
+
+func (f *finder) local(name string) bool {
+	for _, scope := range f.scopes {
+		if scope[name] {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (f *finder) stmt(stmt ast.Stmt) {
+	switch s := stmt.(type) {
+	case *ast.AssignStmt:
+		f.exprs(s.Lhs)
+		f.exprs(s.Rhs)
+	case *ast.LocalAssignStmt:
+		// `local function f` is visible in its body.
+		if len(s.Exprs) == 1 && len(s.Names) == 1 {
+			if _, ok := s.Exprs[0].(*ast.FunctionExpr); ok {
+				f.declare(s.Names...)
+			}
+		}
+
+		f.exprs(s.Exprs)
+		f.declare(s.Names...)
+	case *ast.FuncCallStmt:
+		f.expr(s.Expr)
+	case *ast.DoBlockStmt:
+		f.block(s.Stmts)
+	case *ast.WhileStmt:
+		f.expr(s.Condition)
+		f.block(s.Stmts)
+	case *ast.RepeatStmt:
+		// the condition of `until` sees the locals of body.
+		f.block(append(s.Stmts, &ast.FuncCallStmt{Expr: s.Condition}))
+	case *ast.IfStmt:
+		f.expr(s.Condition)
+		f.block(s.Then)
+		f.block(s.Else)
+	case *ast.NumberForStmt:
+		f.expr(s.Init)
+		f.expr(s.Limit)
+		if s.Step != nil {
+			f.expr(s.Step)
+		}
+		f.block(s.Stmts, s.Name)
+	case *ast.GenericForStmt:
+		f.exprs(s.Exprs)
+		f.block(s.Stmts, s.Names...)
+	case *ast.FuncDefStmt:
+		if s.Name.Func != nil {
+			f.expr(s.Name.Func)
+		} else {
+			f.expr(s.Name.Receiver)
+		}
+		f.expr(s.Func)
+	case *ast.ReturnStmt:
+		f.exprs(s.Exprs)
+	}
+}
+
+func (f *finder) exprs(exprs []ast.Expr) {
+	for _, expr := range exprs {
+		f.expr(expr)
+	}
+}
+
+func (f *finder) expr(expr ast.Expr) {
+	switch e := expr.(type) {
+	case *ast.AttrGetExpr:
+		f.expr(e.Object)
+		f.expr(e.Key)
+	case *ast.TableExpr:
+		for _, field := range e.Fields {
+			if field.Key != nil {
+				f.expr(field.Key)
+			}
+			f.expr(field.Value)
+		}
+	case *ast.FuncCallExpr:
+		if e.Func != nil {
+			f.expr(e.Func)
+		} else {
+			f.expr(e.Receiver)
+		}
+		f.exprs(e.Args)
+		f.call(e)
+	case *ast.LogicalOpExpr:
+		f.expr(e.Lhs)
+		f.expr(e.Rhs)
+	case *ast.RelationalOpExpr:
+		f.expr(e.Lhs)
+		f.expr(e.Rhs)
+	case *ast.StringConcatOpExpr:
+		f.expr(e.Lhs)
+		f.expr(e.Rhs)
+	case *ast.ArithmeticOpExpr:
+		f.expr(e.Lhs)
+		f.expr(e.Rhs)
+	case *ast.UnaryMinusOpExpr:
+		f.expr(e.Expr)
+	case *ast.UnaryNotOpExpr:
+		f.expr(e.Expr)
+	case *ast.UnaryLenOpExpr:
+		f.expr(e.Expr)
+	case *ast.FunctionExpr:
+		params := make([]string, 0)
+		if e.ParList != nil {
+			params = e.ParList.Names
+		}
+		f.block(e.Stmts, params...)
+	}
+}
+
+func (f *finder) call(e *ast.FuncCallExpr) {
+	fn, ok := e.Func.(*ast.IdentExpr)
+	if !ok || fn.Value != "require" || f.local(fn.Value) {
+		return
+	}
+
+	r := &require{
+		line: e.Line(),
+	}
+	if len(e.Args) == 1 {
+		if s, ok := e.Args[0].(*ast.StringExpr); ok {
+			r.name = s.Value
+		}
+	}
+
+	f.requires = append(f.requires, r)
+}
@@ -0,0 +1,312 @@
+// (c) 2018, Jeff Chen <jefurry@qq.com>
+//
+// This file is part of Gola
+//
+// Copyright 2018 The Gola Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+// Package format implements canonical formatting of Lua sources.
+//
+// Each statement starts a line, and so does the keyword closing a block which
+// is not empty. The other line breaks of source are kept, at most one blank
+// line in a row, and the lines inside blocks, brackets and table constructors
+// left open on the previous lines are indented by a tab more than the line
+// opening them. The tokens are separated by a space, except around `.` and
+// `:`, inside brackets, before `,` and `;`, after unary operators, and before
+// the parentheses of calls and the brackets of indexes, the string and table
+// arguments of calls are preceded by a space. Comments and long strings are
+// kept verbatim.
+package format
+
+import (
+	"bytes"
+	"github.com/pkg/errors"
+	"github.com/yuin/gopher-lua/parse"
+	"strings"
+)
+
+const indent = "\t"
+
+var ErrTokenMismatch = errors.New("formatted source does not match tokens of source")
+
+type (
+	// opener is a block or bracket, and indentation of the line opening it.
+	opener struct {
+		indent int
+		// whether `[` is followed by a space, so is `]` preceded by.
+		padded bool
+	}
+
+	printer struct {
+		buf    bytes.Buffer
+		indent int
+		stack  []*opener
+		// the last token written, and whether the current line is empty.
+		last      *token
+		lineStart bool
+	}
+)
+
+// Source formats lua source in canonical style, the source must be valid.
+func Source(src []byte) ([]byte, error) {
+	shebang, text := splitShebang(string(src))
+
+	if _, err := parse.Parse(strings.NewReader(text), "<source>"); err != nil {
+		return nil, err
+	}
+
+	tokens, err := scan(text)
+	if err != nil {
+		return nil, err
+	}
+
+	markStatements(tokens)
+	for i, tok := range tokens {
+		if i > 0 && tok.newline && tok.breaks == 0 {
+			tok.breaks = 1
+		}
+	}
+
+	p := &printer{
+		stack: make([]*opener, 0),
+	}
+	if shebang != "" {
+		p.buf.WriteString(shebang)
+		if len(tokens) > 0 {
+			p.buf.WriteString("\n")
+			if tokens[0].breaks > 1 {
+				p.buf.WriteString("\n")
+			}
+		}
+	}
+
+	for i := 0; i < len(tokens); {
+		j := i + 1
+		for j < len(tokens) && tokens[j].breaks == 0 {
+			j++
+		}
+
+		p.printLine(tokens[i:j])
+		i = j
+	}
+
+	if p.buf.Len() > 0 {
+		p.buf.WriteString("\n")
+	}
+
+	out := p.buf.Bytes()
+	if err := check(tokens, string(out[len(shebang):])); err != nil {
+		return nil, err
+	}
+
+	return out, nil
+}
+
+// splitShebang splits the shebang line from source, the shebang line is
+// kept as is by formatting.
+func splitShebang(src string) (string, string) {
+	if !strings.HasPrefix(src, "#") {
+		return "", src
+	}
+
+	i := strings.Index(src, "\n")
+	if i < 0 {
+		i = len(src)
+	}
+
+	return strings.TrimRight(src[:i], " \t\r"), src[i:]
+}
+
+// check checks that formatted source has the same tokens as source.
+func check(tokens []*token, formatted string) error {
+	result, err := scan(formatted)
+	if err != nil {
+		return errors.Wrap(ErrTokenMismatch, err.Error())
+	}
+
+	if len(result) != len(tokens) {
+		return ErrTokenMismatch
+	}
+
+	for i, tok := range tokens {
+		if result[i].text != tok.text {
+			return errors.Wrapf(ErrTokenMismatch, "%q != %q", result[i].text, tok.text)
+		}
+	}
+
+	return nil
+}
+
+// printLine prints tokens of a line.
+func (p *printer) printLine(tokens []*token) {
+	if p.last != nil {
+		p.buf.WriteString("\n")
+		if tokens[0].breaks > 1 {
+			p.buf.WriteString("\n")
+		}
+	}
+
+	// the line is indented one level deeper than the line of innermost
+	// opener, and the closing tokens at the start of line indent it as the
+	// line of their openers.
+	p.indent = 0
+	if len(p.stack) > 0 {
+		p.indent = p.stack[len(p.stack)-1].indent + 1
+	}
+
+	n := 0
+	for n < len(tokens) && isCloser(tokens[n]) {
+		if o := p.close(); o != nil {
+			p.indent = o.indent
+		}
+		n++
+	}
+
+	p.buf.WriteString(strings.Repeat(indent, p.indent))
+	p.lineStart = true
+
+	for i, tok := range tokens {
+		var o *opener
+		if i >= n && isCloser(tok) {
+			o = p.close()
+		}
+
+		p.write(tok, o)
+
+		if isOpener(tok) {
+			p.open()
+		}
+	}
+}
+
+// write writes token with separator of the previous token on the line, o is
+// the opener closed by token if any.
+func (p *printer) write(tok *token, o *opener) {
+	if tok.kind == tokenOperator && (tok.text == "-" || tok.text == "#") {
+		tok.unary = p.last == nil || !isOperand(p.last)
+	}
+
+	if !p.lineStart && (needSpace(p.last, tok) || o != nil && o.padded) {
+		p.buf.WriteString(" ")
+
+		// `[ [[x]] ]` is balanced.
+		if p.last.kind == tokenOperator && p.last.text == "[" && len(p.stack) > 0 {
+			p.stack[len(p.stack)-1].padded = true
+		}
+	}
+
+	p.buf.WriteString(tok.text)
+	p.last = tok
+	p.lineStart = false
+}
+
+func (p *printer) open() {
+	p.stack = append(p.stack, &opener{
+		indent: p.indent,
+	})
+}
+
+// close closes the innermost opener, `else` and `elseif` close `then`.
+func (p *printer) close() *opener {
+	if len(p.stack) == 0 {
+		return nil
+	}
+
+	o := p.stack[len(p.stack)-1]
+	p.stack = p.stack[:len(p.stack)-1]
+
+	return o
+}
+
+func isOpener(tok *token) bool {
+	if tok.kind != tokenKeyword && tok.kind != tokenOperator {
+		return false
+	}
+
+	switch tok.text {
+	case "function", "do", "then", "repeat", "else", "(", "[", "{":
+		return true
+	}
+
+	return false
+}
+
+func isCloser(tok *token) bool {
+	if tok.kind != tokenKeyword && tok.kind != tokenOperator {
+		return false
+	}
+
+	switch tok.text {
+	case "end", "until", "else", "elseif", ")", "]", "}":
+		return true
+	}
+
+	return false
+}
+
+// isOperand reports whether token ends an operand, after which `-` is binary.
+func isOperand(tok *token) bool {
+	switch tok.kind {
+	case tokenName, tokenNumber, tokenString:
+		return true
+	case tokenKeyword:
+		switch tok.text {
+		case "end", "true", "false", "nil":
+			return true
+		}
+	case tokenOperator:
+		switch tok.text {
+		case ")", "]", "}", "...":
+			return true
+		}
+	}
+
+	return false
+}
+
+// needSpace reports whether tokens on a line are separated by a space.
+func needSpace(prev, tok *token) bool {
+	if tok.kind == tokenComment {
+		return true
+	}
+
+	if tok.kind == tokenOperator {
+		switch tok.text {
+		case ",", ";", ")", "]", "}", ".", ":":
+			return false
+		}
+	}
+
+	if prev.kind == tokenOperator {
+		switch prev.text {
+		case "(", "{":
+			return false
+		case "[":
+			// `[[` starts a long string.
+			return tok.kind == tokenString && strings.HasPrefix(tok.text, "[")
+		case ",", ";":
+			return true
+		case ".", ":":
+			return false
+		case "-", "#":
+			// `- -x` must not be joined into a comment.
+			if prev.unary {
+				return strings.HasPrefix(tok.text, "-")
+			}
+		}
+	}
+
+	switch tok.text {
+	case "(":
+		if tok.kind == tokenOperator {
+			return !isOperand(prev) && prev.text != "function"
+		}
+	case "[":
+		if tok.kind == tokenOperator {
+			return !isOperand(prev)
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,118 @@
+// (c) 2018, Jeff Chen <jefurry@qq.com>
+//
+// This file is part of Gola
+//
+// Copyright 2018 The Gola Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package format
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestSource(t *testing.T) {
+	for _, v := range []struct {
+		source, formatted string
+	}{
+		{
+			"local   a,b =1 ,  2",
+			"local a, b = 1, 2\n",
+		},
+		{
+			"if a then\n  b( c ,d )\nelseif c then\n    print ( 'x' )\nelse\nend",
+			"if a then\n\tb(c, d)\nelseif c then\n\tprint('x')\nelse\nend\n",
+		},
+		{
+			"local t = { 1,2 ;x=-1, [ 'y' ]={ } }\nlocal u = {\n1,\n  {\n  2 }\n}",
+			"local t = {1, 2; x = -1, ['y'] = {}}\nlocal u = {\n\t1,\n\t{\n\t\t2}\n}\n",
+		},
+		{
+			"print(-x, a-b, a - -b, #t+1, not x, 2^-1, a..b)",
+			"print(-x, a - b, a - -b, #t + 1, not x, 2 ^ -1, a .. b)\n",
+		},
+		{
+			"obj:method (1) . field [2] = require'x'\nfoo{1}\nfoo {1}\nlocal f = function ( ... ) end",
+			"obj:method(1).field[2] = require 'x'\nfoo {1}\nfoo {1}\nlocal f = function(...) end\n",
+		},
+		{
+			"foo(function()\nreturn 1\nend, {\nx = 1,\n})",
+			"foo(function()\n\treturn 1\nend, {\n\tx = 1,\n})\n",
+		},
+		{
+			"repeat\nx = x+1\nuntil x>10\nwhile true do\nbreak\nend",
+			"repeat\n\tx = x + 1\nuntil x > 10\nwhile true do\n\tbreak\nend\n",
+		},
+		{
+			"\n\n-- comment   \nlocal x = 1 --[[ inline ]]\n\n\n\nlocal s = [==[\n  kept  as is\n]==]",
+			"-- comment\nlocal x = 1 --[[ inline ]]\n\nlocal s = [==[\n  kept  as is\n]==]\n",
+		},
+		{
+			"#!/usr/bin/env gola\nprint( 1 )",
+			"#!/usr/bin/env gola\nprint(1)\n",
+		},
+		{
+			"t[ [[x]] ] = 1\nt[ [==[x]==]]=2",
+			"t[ [[x]] ] = 1\nt[ [==[x]==] ] = 2\n",
+		},
+		{
+			"local x=1 local y=2; print(x) x, y = y, x",
+			"local x = 1\nlocal y = 2;\nprint(x)\nx, y = y, x\n",
+		},
+		{
+			"if a then return end while x do x = f(x) end for i = 1, 2 do end",
+			"if a then\n\treturn\nend\nwhile x do\n\tx = f(x)\nend\nfor i = 1, 2 do end\n",
+		},
+		{
+			"if a then b() elseif c then else d() end repeat x = x - 1 until x < 0",
+			"if a then\n\tb()\nelseif c then else\n\td()\nend\nrepeat\n\tx = x - 1\nuntil x < 0\n",
+		},
+		{
+			"local t = {f = function(x) return x end, g = function() end} t.f(1) --[[ c ]] t.g()",
+			"local t = {f = function(x)\n\treturn x\nend, g = function() end}\nt.f(1) --[[ c ]]\nt.g()\n",
+		},
+		{
+			"local function f(...) local a, b = ... return a or b, #{...} end print(f \"x\") do local s = [[a]] .. - -1 end",
+			"local function f(...)\n\tlocal a, b = ...\n\treturn a or b, #{...}\nend\nprint(f \"x\")\ndo\n\tlocal s = [[a]] .. - -1\nend\n",
+		},
+	} {
+		formatted, err := Source([]byte(v.source))
+		if !assert.NoError(t, err, "Source should succeed: %s", v.source) {
+			return
+		}
+
+		if !assert.Equal(t, v.formatted, string(formatted), "formatted source mismatching") {
+			return
+		}
+
+		again, err := Source(formatted)
+		if !assert.NoError(t, err, "Source should succeed: %s", formatted) {
+			return
+		}
+
+		if !assert.Equal(t, string(formatted), string(again), "formatting should be idempotent") {
+			return
+		}
+	}
+}
+
+func TestSourceError(t *testing.T) {
+	_, err := Source([]byte("local x = = 1"))
+	if !assert.Error(t, err, "Source should fail with syntax error") {
+		return
+	}
+}
+
+func TestStripComments(t *testing.T) {
+	source := "#!/usr/bin/env gola\n-- header\n--[[ long\ncomment ]]\nlocal x = 1 -- trailing   \nlocal s = '--not' .. [[\n-- kept\n]]\nprint(x,--[[inline]]s)\n\t-- indented\nreturn x --[==[ end ]==]"
+	stripped, err := StripComments([]byte(source))
+	if !assert.NoError(t, err, "StripComments should succeed") {
+		return
+	}
+
+	if !assert.Equal(t, "#!/usr/bin/env gola\nlocal x = 1\nlocal s = '--not' .. [[\n-- kept\n]]\nprint(x, s)\nreturn x", string(stripped), "stripped source mismatching") {
+		return
+	}
+}
@@ -0,0 +1,234 @@
+// (c) 2018, Jeff Chen <jefurry@qq.com>
+//
+// This file is part of Gola
+//
+// Copyright 2018 The Gola Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package format
+
+import (
+	"github.com/pkg/errors"
+	"strings"
+)
+
+// Kinds of token.
+const (
+	tokenName = iota
+	tokenKeyword
+	tokenNumber
+	tokenString
+	tokenOperator
+	tokenComment
+)
+
+var ErrUnterminated = errors.New("unterminated string or comment")
+
+type (
+	token struct {
+		kind int
+		// source text, long strings and comments are kept verbatim.
+		text string
+		// newlines between the previous token and the token.
+		breaks int
+		// whether `-` or `#` is unary operator, set by printer.
+		unary bool
+		// whether the token starts a statement, or closes a block which is
+		// not empty, set by statements.
+		newline bool
+		// offset of the token in source.
+		pos int
+	}
+
+	scanner struct {
+		src  string
+		pos  int
+		line int
+	}
+)
+
+var keywords = map[string]bool{
+	"and": true, "break": true, "do": true, "else": true, "elseif": true,
+	"end": true, "false": true, "for": true, "function": true, "if": true,
+	"in": true, "local": true, "nil": true, "not": true, "or": true,
+	"repeat": true, "return": true, "then": true, "true": true, "until": true,
+	"while": true,
+}
+
+// operators in order of matching, the longer first.
+var operators = []string{
+	"...", "..", "==", "~=", "<=", ">=",
+	"+", "-", "*", "/", "%", "^", "#", "<", ">", "=",
+	"(", ")", "{", "}", "[", "]", ";", ":", ",", ".",
+}
+
+// scan splits source into tokens, the white spaces are dropped.
+func scan(src string) ([]*token, error) {
+	s := &scanner{
+		src:  src,
+		line: 1,
+	}
+
+	tokens := make([]*token, 0)
+	for {
+		breaks := s.skipSpace()
+		if s.pos >= len(s.src) {
+			return tokens, nil
+		}
+
+		pos := s.pos
+		tok, err := s.next()
+		if err != nil {
+			return nil, errors.Wrapf(err, "line %d", s.line)
+		}
+
+		tok.pos = pos
+		tok.breaks = breaks
+		s.line += strings.Count(tok.text, "\n")
+		tokens = append(tokens, tok)
+	}
+}
+
+func (s *scanner) skipSpace() int {
+	breaks := 0
+	for s.pos < len(s.src) {
+		switch s.src[s.pos] {
+		case '\n':
+			breaks++
+			s.line++
+		case ' ', '\t', '\r', '\f', '\v':
+		default:
+			return breaks
+		}
+
+		s.pos++
+	}
+
+	return breaks
+}
+
+func (s *scanner) next() (*token, error) {
+	start := s.pos
+	c := s.src[s.pos]
+
+	switch {
+	case strings.HasPrefix(s.src[s.pos:], "--"):
+		s.pos += 2
+		if level := s.longBracket(); level >= 0 {
+			if err := s.skipLong(level); err != nil {
+				return nil, err
+			}
+		} else {
+			for s.pos < len(s.src) && s.src[s.pos] != '\n' {
+				s.pos++
+			}
+		}
+
+		return &token{
+			kind: tokenComment,
+			text: strings.TrimRight(s.src[start:s.pos], " \t\r"),
+		}, nil
+	case c == '[' && s.longBracket() >= 0:
+		if err := s.skipLong(s.longBracket()); err != nil {
+			return nil, err
+		}
+
+		return &token{kind: tokenString, text: s.src[start:s.pos]}, nil
+	case c == '"' || c == '\'':
+		s.pos++
+		for s.pos < len(s.src) && s.src[s.pos] != c {
+			if s.src[s.pos] == '\\' {
+				s.pos++
+			}
+			s.pos++
+		}
+
+		if s.pos >= len(s.src) {
+			return nil, ErrUnterminated
+		}
+		s.pos++
+
+		return &token{kind: tokenString, text: s.src[start:s.pos]}, nil
+	case isDigit(c) || c == '.' && s.pos+1 < len(s.src) && isDigit(s.src[s.pos+1]):
+		for s.pos < len(s.src) {
+			c := s.src[s.pos]
+			if isAlnum(c) || c == '.' {
+				s.pos++
+			} else if (c == '+' || c == '-') && (s.src[s.pos-1] == 'e' || s.src[s.pos-1] == 'E') &&
+				!strings.HasPrefix(strings.ToLower(s.src[start:]), "0x") {
+				s.pos++
+			} else {
+				break
+			}
+		}
+
+		return &token{kind: tokenNumber, text: s.src[start:s.pos]}, nil
+	case isAlpha(c):
+		for s.pos < len(s.src) && isAlnum(s.src[s.pos]) {
+			s.pos++
+		}
+
+		text := s.src[start:s.pos]
+		kind := tokenName
+		if keywords[text] {
+			kind = tokenKeyword
+		}
+
+		return &token{kind: kind, text: text}, nil
+	}
+
+	for _, op := range operators {
+		if strings.HasPrefix(s.src[s.pos:], op) {
+			s.pos += len(op)
+
+			return &token{kind: tokenOperator, text: op}, nil
+		}
+	}
+
+	return nil, errors.Errorf("unexpected character %q", c)
+}
+
+// longBracket returns the level of long bracket at current position, like
+// `[==[`, or -1 if there is no long bracket.
+func (s *scanner) longBracket() int {
+	if s.pos >= len(s.src) || s.src[s.pos] != '[' {
+		return -1
+	}
+
+	i := s.pos + 1
+	for i < len(s.src) && s.src[i] == '=' {
+		i++
+	}
+
+	if i < len(s.src) && s.src[i] == '[' {
+		return i - s.pos - 1
+	}
+
+	return -1
+}
+
+// skipLong skips long string or comment of level from the open bracket.
+func (s *scanner) skipLong(level int) error {
+	closing := "]" + strings.Repeat("=", level) + "]"
+	end := strings.Index(s.src[s.pos+level+2:], closing)
+	if end < 0 {
+		return ErrUnterminated
+	}
+
+	s.pos += level + 2 + end + len(closing)
+
+	return nil
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+func isAlpha(c byte) bool {
+	return c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+}
+
+func isAlnum(c byte) bool {
+	return isAlpha(c) || isDigit(c)
+}
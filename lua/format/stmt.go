@@ -0,0 +1,330 @@
+// (c) 2018, Jeff Chen <jefurry@qq.com>
+//
+// This file is part of Gola
+//
+// Copyright 2018 The Gola Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package format
+
+type (
+	// statements walks tokens of a valid chunk like the parser of lua, and
+	// marks the tokens which start lines in canonical formatting.
+	statements struct {
+		// tokens without comments.
+		tokens []*token
+		pos    int
+	}
+)
+
+var binaryOperators = map[string]bool{
+	"+": true, "-": true, "*": true, "/": true, "%": true, "^": true, "..": true,
+	"==": true, "~=": true, "<": true, "<=": true, ">": true, ">=": true,
+	"and": true, "or": true,
+}
+
+// markStatements sets newline of the tokens starting statements, and of the
+// tokens closing blocks which are not empty.
+func markStatements(tokens []*token) {
+	s := &statements{
+		tokens: make([]*token, 0, len(tokens)),
+	}
+
+	for _, tok := range tokens {
+		if tok.kind != tokenComment {
+			s.tokens = append(s.tokens, tok)
+		}
+	}
+
+	for s.peek() != nil {
+		pos := s.pos
+		s.block()
+
+		// skips the token not expected in chunk.
+		if s.pos == pos {
+			s.next()
+		}
+	}
+}
+
+func (s *statements) peek() *token {
+	if s.pos < len(s.tokens) {
+		return s.tokens[s.pos]
+	}
+
+	return nil
+}
+
+func (s *statements) next() *token {
+	tok := s.peek()
+	if tok != nil {
+		s.pos++
+	}
+
+	return tok
+}
+
+// is reports whether the next token is keyword or operator text.
+func (s *statements) is(text string) bool {
+	tok := s.peek()
+
+	return tok != nil && (tok.kind == tokenKeyword || tok.kind == tokenOperator) && tok.text == text
+}
+
+// skip skips the next token if it is keyword or operator text.
+func (s *statements) skip(text string) bool {
+	if s.is(text) {
+		s.pos++
+
+		return true
+	}
+
+	return false
+}
+
+// closeBlock skips the keyword closing block of n statements.
+func (s *statements) closeBlock(text string, n int) {
+	if s.is(text) && n > 0 {
+		s.peek().newline = true
+	}
+
+	s.skip(text)
+}
+
+func (s *statements) blockEnd() bool {
+	return s.peek() == nil || s.is("end") || s.is("else") || s.is("elseif") || s.is("until")
+}
+
+// block walks statements until the end of block, and returns the number of them.
+func (s *statements) block() int {
+	n := 0
+	for !s.blockEnd() {
+		pos := s.pos
+		s.peek().newline = true
+		s.statement()
+		s.skip(";")
+		n++
+
+		if s.pos == pos {
+			break
+		}
+	}
+
+	return n
+}
+
+func (s *statements) statement() {
+	tok := s.peek()
+	if tok.kind != tokenKeyword {
+		s.exprStatement()
+
+		return
+	}
+
+	switch tok.text {
+	case "if":
+		s.next()
+		s.expr()
+		s.skip("then")
+		n := s.block()
+		for s.is("elseif") {
+			s.closeBlock("elseif", n)
+			s.expr()
+			s.skip("then")
+			n = s.block()
+		}
+
+		if s.is("else") {
+			s.closeBlock("else", n)
+			n = s.block()
+		}
+		s.closeBlock("end", n)
+	case "while":
+		s.next()
+		s.expr()
+		s.skip("do")
+		s.closeBlock("end", s.block())
+	case "do":
+		s.next()
+		s.closeBlock("end", s.block())
+	case "for":
+		s.next()
+		for !s.is("do") && s.peek() != nil {
+			if s.skip("=") || s.skip("in") || s.skip(",") {
+				s.exprList()
+			} else {
+				s.next()
+			}
+		}
+		s.skip("do")
+		s.closeBlock("end", s.block())
+	case "repeat":
+		s.next()
+		s.closeBlock("until", s.block())
+		s.expr()
+	case "function":
+		s.next()
+		for !s.is("(") && s.peek() != nil {
+			s.next()
+		}
+		s.body()
+	case "local":
+		s.next()
+		if s.skip("function") {
+			s.next()
+			s.body()
+
+			return
+		}
+
+		for s.peek() != nil && (s.peek().kind == tokenName || s.is(",")) {
+			s.next()
+		}
+
+		if s.skip("=") {
+			s.exprList()
+		}
+	case "return":
+		s.next()
+		if !s.blockEnd() && !s.is(";") {
+			s.exprList()
+		}
+	case "break":
+		s.next()
+	default:
+		s.exprStatement()
+	}
+}
+
+// exprStatement walks assignment or function call.
+func (s *statements) exprStatement() {
+	s.suffixedExpr()
+	for s.skip(",") {
+		s.suffixedExpr()
+	}
+
+	if s.skip("=") {
+		s.exprList()
+	}
+}
+
+// body walks parameters and block of function.
+func (s *statements) body() {
+	if s.skip("(") {
+		for !s.is(")") && s.peek() != nil {
+			s.next()
+		}
+		s.skip(")")
+	}
+
+	s.closeBlock("end", s.block())
+}
+
+func (s *statements) exprList() {
+	s.expr()
+	for s.skip(",") {
+		s.expr()
+	}
+}
+
+func (s *statements) expr() {
+	for {
+		for s.skip("not") || s.skip("-") || s.skip("#") {
+		}
+
+		s.simpleExpr()
+
+		tok := s.peek()
+		if tok == nil || tok.kind != tokenKeyword && tok.kind != tokenOperator || !binaryOperators[tok.text] {
+			return
+		}
+		s.next()
+	}
+}
+
+func (s *statements) simpleExpr() {
+	tok := s.peek()
+	if tok == nil {
+		return
+	}
+
+	switch {
+	case tok.kind == tokenNumber || tok.kind == tokenString:
+		s.next()
+	case s.is("nil") || s.is("true") || s.is("false") || s.is("..."):
+		s.next()
+	case s.is("function"):
+		s.next()
+		s.body()
+	case s.is("{"):
+		s.table()
+	default:
+		s.suffixedExpr()
+	}
+}
+
+// suffixedExpr walks name or parenthesized expression with fields, indexes and calls.
+func (s *statements) suffixedExpr() {
+	if s.skip("(") {
+		s.expr()
+		s.skip(")")
+	} else if tok := s.peek(); tok != nil && tok.kind == tokenName {
+		s.next()
+	} else {
+		return
+	}
+
+	for {
+		tok := s.peek()
+		switch {
+		case s.skip("."):
+			s.next()
+		case s.skip("["):
+			s.expr()
+			s.skip("]")
+		case s.skip(":"):
+			s.next()
+			s.args()
+		case s.is("(") || s.is("{") || tok != nil && tok.kind == tokenString:
+			s.args()
+		default:
+			return
+		}
+	}
+}
+
+func (s *statements) args() {
+	switch {
+	case s.skip("("):
+		if !s.is(")") {
+			s.exprList()
+		}
+		s.skip(")")
+	case s.is("{"):
+		s.table()
+	default:
+		s.next()
+	}
+}
+
+func (s *statements) table() {
+	s.skip("{")
+	for !s.is("}") && s.peek() != nil {
+		pos := s.pos
+		if s.skip("[") {
+			s.expr()
+			s.skip("]")
+			s.skip("=")
+		} else if tok := s.peek(); tok.kind == tokenName && s.pos+1 < len(s.tokens) &&
+			s.tokens[s.pos+1].kind == tokenOperator && s.tokens[s.pos+1].text == "=" {
+			s.pos += 2
+		}
+		s.expr()
+
+		if !s.skip(",") && !s.skip(";") && s.pos == pos {
+			s.next()
+		}
+	}
+	s.skip("}")
+}
@@ -0,0 +1,73 @@
+// (c) 2018, Jeff Chen <jefurry@qq.com>
+//
+// This file is part of Gola
+//
+// Copyright 2018 The Gola Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package format
+
+import (
+	"bytes"
+	"strings"
+)
+
+// StripComments removes comments from lua source, the lines of comments are
+// removed, and the rest of source is kept as is, except the shebang line.
+func StripComments(src []byte) ([]byte, error) {
+	shebang, text := splitShebang(string(src))
+	tokens, err := scan(text)
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString(shebang)
+
+	// dropLine drops the rest of line after a comment on its own line, and
+	// dropSpace drops the spaces after a comment at the end of line.
+	end, dropLine, dropSpace := 0, false, false
+	space := func(ws string) string {
+		if dropLine {
+			if i := strings.Index(ws, "\n"); i >= 0 {
+				ws = ws[i+1:]
+			}
+		} else if dropSpace {
+			ws = strings.TrimLeft(ws, " \t\r")
+		}
+		dropLine, dropSpace = false, false
+
+		return ws
+	}
+
+	for i, tok := range tokens {
+		ws := space(text[end:tok.pos])
+		end = tok.pos + len(tok.text)
+
+		if tok.kind != tokenComment {
+			buf.WriteString(ws)
+			buf.WriteString(tok.text)
+
+			continue
+		}
+
+		switch {
+		case i+1 < len(tokens) && tokens[i+1].breaks == 0:
+			// the comment between tokens is replaced by a space.
+			if ws == "" {
+				ws = " "
+			}
+			buf.WriteString(ws)
+		case i == 0 || tok.breaks > 0:
+			buf.WriteString(ws[:strings.LastIndex(ws, "\n")+1])
+			dropLine = true
+		default:
+			dropSpace = true
+		}
+	}
+
+	buf.WriteString(space(text[end:]))
+
+	return buf.Bytes(), nil
+}
@@ -27,6 +27,7 @@ import (
 	"github.com/jefurry/gola/lua/libs/path"
 	"github.com/jefurry/gola/lua/libs/socket"
 	"github.com/jefurry/gola/lua/libs/sys"
+	"github.com/jefurry/gola/lua/libs/testing"
 	"github.com/jefurry/gola/lua/libs/time"
 	"github.com/jefurry/gola/lua/libs/url"
 	"github.com/jefurry/gola/lua/libs/xmlpath"
@@ -56,6 +57,7 @@ func OpenLibs(L *lua.LState) {
 	xmlpath.Open(L)
 	socket.Open(L)
 	lfs.Open(L)
+	testing.Open(L)
 
 	moon.Open(L)
 }
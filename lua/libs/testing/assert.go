@@ -0,0 +1,273 @@
+// (c) 2018, Jeff Chen <jefurry@qq.com>
+//
+// This file is part of Gola
+//
+// Copyright 2018 The Gola Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package testing
+
+import (
+	"fmt"
+	"github.com/pmezard/go-difflib/difflib"
+	"github.com/yuin/gopher-lua"
+	"math"
+	"strings"
+)
+
+// The assertions raise an error if failed, the optional last argument is
+// the message prepended to the error.
+var testingAssertFuncs = map[string]lua.LGFunction{
+	"equal":      testingAssertEqual,
+	"notEqual":   testingAssertNotEqual,
+	"same":       testingAssertSame,
+	"truthy":     testingAssertTruthy,
+	"falsy":      testingAssertFalsy,
+	"isNil":      testingAssertIsNil,
+	"notNil":     testingAssertNotNil,
+	"near":       testingAssertNear,
+	"match":      testingAssertMatch,
+	"errors":     testingAssertErrors,
+	"called":     testingAssertCalled,
+	"notCalled":  testingAssertNotCalled,
+	"calledWith": testingAssertCalledWith,
+	"fail":       testingAssertFail,
+}
+
+// testingAssertEqual asserts that expected and actual are deep equal, the
+// difference of tables is shown as unified diff.
+func testingAssertEqual(L *lua.LState) int {
+	expected, actual := L.CheckAny(1), L.CheckAny(2)
+	if deepEqual(expected, actual) {
+		return 0
+	}
+
+	_, ok1 := expected.(*lua.LTable)
+	_, ok2 := actual.(*lua.LTable)
+	if !ok1 || !ok2 {
+		failf(L, 3, "expected: %s\nactual:   %s", dump(expected), dump(actual))
+
+		return 0
+	}
+
+	diff, _ := difflib.GetUnifiedDiffString(difflib.UnifiedDiff{
+		A:        difflib.SplitLines(dump(expected)),
+		B:        difflib.SplitLines(dump(actual)),
+		FromFile: "expected",
+		ToFile:   "actual",
+		Context:  3,
+	})
+	failf(L, 3, "tables are not equal:\n%s", strings.TrimRight(diff, "\n"))
+
+	return 0
+}
+
+func testingAssertNotEqual(L *lua.LState) int {
+	expected, actual := L.CheckAny(1), L.CheckAny(2)
+	if deepEqual(expected, actual) {
+		failf(L, 3, "expected values to differ: %s", dump(actual))
+	}
+
+	return 0
+}
+
+// testingAssertSame asserts that expected and actual are the same value,
+// i.e. tables are compared by reference.
+func testingAssertSame(L *lua.LState) int {
+	expected, actual := L.CheckAny(1), L.CheckAny(2)
+	if !L.RawEqual(expected, actual) {
+		failf(L, 3, "expected same value\nexpected: %s\nactual:   %s", expected.String(), actual.String())
+	}
+
+	return 0
+}
+
+func testingAssertTruthy(L *lua.LState) int {
+	lv := L.CheckAny(1)
+	if !lua.LVAsBool(lv) {
+		failf(L, 2, "expected truthy value, got %s", dump(lv))
+	}
+
+	return 0
+}
+
+func testingAssertFalsy(L *lua.LState) int {
+	lv := L.CheckAny(1)
+	if lua.LVAsBool(lv) {
+		failf(L, 2, "expected falsy value, got %s", dump(lv))
+	}
+
+	return 0
+}
+
+func testingAssertIsNil(L *lua.LState) int {
+	lv := L.Get(1)
+	if lv != lua.LNil {
+		failf(L, 2, "expected nil, got %s", dump(lv))
+	}
+
+	return 0
+}
+
+func testingAssertNotNil(L *lua.LState) int {
+	if L.Get(1) == lua.LNil {
+		failf(L, 2, "expected non-nil value")
+	}
+
+	return 0
+}
+
+// testingAssertNear asserts that the difference of numbers is not greater
+// than delta.
+func testingAssertNear(L *lua.LState) int {
+	expected := L.CheckNumber(1)
+	actual := L.CheckNumber(2)
+	delta := L.CheckNumber(3)
+	if math.Abs(float64(expected-actual)) > float64(delta) {
+		failf(L, 4, "expected %s to be within %s of %s", actual.String(), delta.String(), expected.String())
+	}
+
+	return 0
+}
+
+// testingAssertMatch asserts that string matches lua pattern.
+func testingAssertMatch(L *lua.LState) int {
+	s := L.CheckString(1)
+	pattern := L.CheckString(2)
+
+	L.Push(L.GetField(L.GetGlobal("string"), "find"))
+	L.Push(lua.LString(s))
+	L.Push(lua.LString(pattern))
+	L.Call(2, 1)
+	found := L.Get(-1)
+	L.Pop(1)
+
+	if found == lua.LNil {
+		failf(L, 3, "expected %q to match pattern %q", s, pattern)
+	}
+
+	return 0
+}
+
+// testingAssertErrors asserts that fn raises an error, whose message
+// contains the optional plain text, and returns the error value.
+func testingAssertErrors(L *lua.LState) int {
+	fn := L.CheckFunction(1)
+	text := L.OptString(2, "")
+
+	err := L.CallByParam(lua.P{
+		Fn:      fn,
+		NRet:    0,
+		Protect: true,
+	})
+	if err == nil {
+		failf(L, 3, "expected an error")
+
+		return 0
+	}
+
+	var value lua.LValue = lua.LString(err.Error())
+	if aerr, ok := err.(*lua.ApiError); ok {
+		value = aerr.Object
+	}
+
+	if text != "" && !strings.Contains(value.String(), text) {
+		failf(L, 3, "expected error containing %q, got %q", text, value.String())
+
+		return 0
+	}
+
+	L.Push(value)
+
+	return 1
+}
+
+// testingAssertCalled asserts that spy is called, exactly n times if n is given.
+func testingAssertCalled(L *lua.LState) int {
+	n := spyCalls(L, 1).Len()
+	if L.Get(2) == lua.LNil {
+		if n == 0 {
+			failf(L, 3, "expected spy to be called")
+		}
+
+		return 0
+	}
+
+	if expected := L.CheckInt(2); n != expected {
+		failf(L, 3, "expected spy to be called %d times, called %d times", expected, n)
+	}
+
+	return 0
+}
+
+func testingAssertNotCalled(L *lua.LState) int {
+	if n := spyCalls(L, 1).Len(); n != 0 {
+		failf(L, 2, "expected spy not to be called, called %d times", n)
+	}
+
+	return 0
+}
+
+// testingAssertCalledWith asserts that spy is called with arguments deep
+// equal to the rest arguments at least once.
+func testingAssertCalledWith(L *lua.LState) int {
+	calls := spyCalls(L, 1)
+
+	args := L.NewTable()
+	for i := 2; i <= L.GetTop(); i++ {
+		args.Append(L.Get(i))
+	}
+
+	found := false
+	calls.ForEach(func(_, call lua.LValue) {
+		if deepEqual(args, call) {
+			found = true
+		}
+	})
+
+	if !found {
+		lines := make([]string, 0, calls.Len())
+		calls.ForEach(func(_, call lua.LValue) {
+			lines = append(lines, "  "+dumpArgs(call.(*lua.LTable)))
+		})
+
+		L.RaiseError("expected spy to be called with (%s), calls:\n%s", dumpArgs(args), strings.Join(lines, "\n"))
+	}
+
+	return 0
+}
+
+func testingAssertFail(L *lua.LState) int {
+	L.RaiseError("%s", L.OptString(1, "failed"))
+
+	return 0
+}
+
+// failf raises the formatted error, prepended by the message at n if given.
+func failf(L *lua.LState, n int, format string, args ...interface{}) {
+	msg := fmt.Sprintf(format, args...)
+	if L.GetTop() >= n && L.Get(n) != lua.LNil {
+		msg = L.ToStringMeta(L.Get(n)).String() + ": " + msg
+	}
+
+	L.RaiseError("%s", msg)
+}
+
+func spyCalls(L *lua.LState, n int) *lua.LTable {
+	calls, _ := checkSpy(L, n).RawGetString("calls").(*lua.LTable)
+	if calls == nil {
+		return L.NewTable()
+	}
+
+	return calls
+}
+
+func dumpArgs(args *lua.LTable) string {
+	values := make([]string, 0, args.Len())
+	for i := 1; i <= args.Len(); i++ {
+		values = append(values, dumpLine(args.RawGetInt(i)))
+	}
+
+	return strings.Join(values, ", ")
+}
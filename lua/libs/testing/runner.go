@@ -0,0 +1,289 @@
+// (c) 2018, Jeff Chen <jefurry@qq.com>
+//
+// This file is part of Gola
+//
+// Copyright 2018 The Gola Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package testing
+
+import (
+	"context"
+	"github.com/pkg/errors"
+	"github.com/yuin/gopher-lua"
+	"time"
+)
+
+const (
+	testingRunnerKey = "testing.RUNNER*"
+)
+
+type (
+	// runner holds the tests of a lua state.
+	runner struct {
+		root *suite
+		// suite being described.
+		current *suite
+		running bool
+		match   func(name string) bool
+		// mocks to restore, in order of mocking.
+		mocks   []*mock
+		results []*Result
+		// the maximum time of calling a test or hook, 0 indicates no limit.
+		timeout time.Duration
+	}
+
+	// suite is a describe block.
+	suite struct {
+		name       string
+		parent     *suite
+		beforeAll  []*lua.LFunction
+		afterAll   []*lua.LFunction
+		beforeEach []*lua.LFunction
+		afterEach  []*lua.LFunction
+		// *suite or *test in order of registration.
+		nodes []interface{}
+	}
+
+	test struct {
+		name string
+		fn   *lua.LFunction
+		skip bool
+	}
+
+	mock struct {
+		tbl   *lua.LTable
+		key   lua.LValue
+		value lua.LValue
+	}
+)
+
+func newSuite(name string, parent *suite) *suite {
+	return &suite{
+		name:   name,
+		parent: parent,
+		nodes:  make([]interface{}, 0),
+	}
+}
+
+// getRunner returns runner of L, which is created if create is true.
+func getRunner(L *lua.LState, create bool) *runner {
+	registry := L.Get(lua.RegistryIndex).(*lua.LTable)
+	if ud, ok := registry.RawGetString(testingRunnerKey).(*lua.LUserData); ok {
+		if r, ok := ud.Value.(*runner); ok {
+			return r
+		}
+	}
+
+	if !create {
+		return nil
+	}
+
+	root := newSuite("", nil)
+	r := &runner{
+		root:    root,
+		current: root,
+		mocks:   make([]*mock, 0),
+	}
+
+	ud := L.NewUserData()
+	ud.Value = r
+	registry.RawSetString(testingRunnerKey, ud)
+
+	return r
+}
+
+// runSuite runs tests of suite, failure is the error of the enclosing
+// beforeAll hooks, which fails all tests.
+func (r *runner) runSuite(L *lua.LState, s *suite, names []string, failure string) {
+	if !r.selected(s, names) {
+		return
+	}
+
+	mark := len(r.mocks)
+	ran := failure == ""
+	if ran {
+		for _, fn := range s.beforeAll {
+			if err := r.call(L, fn); err != nil {
+				failure = "beforeAll: " + err.Error()
+
+				break
+			}
+		}
+	}
+
+	for _, node := range s.nodes {
+		switch n := node.(type) {
+		case *test:
+			if r.matched(names, n.name) {
+				r.runTest(L, s, names, n, failure)
+			}
+		case *suite:
+			r.runSuite(L, n, append(append([]string{}, names...), n.name), failure)
+		}
+	}
+
+	if !ran {
+		return
+	}
+
+	for _, fn := range s.afterAll {
+		if err := r.call(L, fn); err != nil {
+			r.results = append(r.results, &Result{
+				Suites:  names,
+				Test:    "afterAll",
+				Status:  StatusFail,
+				Message: err.Error(),
+			})
+		}
+	}
+
+	r.restore(L, mark)
+}
+
+func (r *runner) runTest(L *lua.LState, s *suite, names []string, t *test, failure string) {
+	result := &Result{
+		Suites: names,
+		Test:   t.name,
+		Status: StatusPass,
+	}
+	r.results = append(r.results, result)
+
+	if t.skip {
+		result.Status = StatusSkip
+
+		return
+	}
+
+	if failure != "" {
+		result.Status = StatusFail
+		result.Message = failure
+
+		return
+	}
+
+	// suites from the outermost.
+	chain := make([]*suite, 0)
+	for p := s; p != nil; p = p.parent {
+		chain = append([]*suite{p}, chain...)
+	}
+
+	start := time.Now()
+	mark := len(r.mocks)
+	var err error
+	for _, p := range chain {
+		for _, fn := range p.beforeEach {
+			if err = r.call(L, fn); err != nil {
+				result.Message = "beforeEach: " + err.Error()
+
+				break
+			}
+		}
+
+		if err != nil {
+			break
+		}
+	}
+
+	if err == nil {
+		if err = r.call(L, t.fn); err != nil {
+			result.Message = err.Error()
+		}
+	}
+
+	// afterEach hooks run even if test fails, from the innermost.
+	for i := len(chain) - 1; i >= 0; i-- {
+		for _, fn := range chain[i].afterEach {
+			if e := r.call(L, fn); e != nil && err == nil {
+				err = e
+				result.Message = "afterEach: " + e.Error()
+			}
+		}
+	}
+
+	r.restore(L, mark)
+	result.Duration = time.Since(start)
+
+	if err != nil {
+		result.Status = StatusFail
+	}
+}
+
+// selected reports whether suite has any test to run.
+func (r *runner) selected(s *suite, names []string) bool {
+	for _, node := range s.nodes {
+		switch n := node.(type) {
+		case *test:
+			if r.matched(names, n.name) {
+				return true
+			}
+		case *suite:
+			if r.selected(n, append(append([]string{}, names...), n.name)) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+func (r *runner) matched(names []string, name string) bool {
+	if r.match == nil {
+		return true
+	}
+
+	return r.match((&Result{Suites: names, Test: name}).Name())
+}
+
+// restore restores the fields mocked since the first mark mocks in reverse order.
+func (r *runner) restore(L *lua.LState, mark int) {
+	for i := len(r.mocks) - 1; i >= mark; i-- {
+		m := r.mocks[i]
+		L.SetTable(m.tbl, m.key, m.value)
+	}
+
+	r.mocks = r.mocks[:mark]
+}
+
+// call calls fn in protected mode, the error is the error value without
+// stack traceback. fn is stopped after the timeout of runner.
+func (r *runner) call(L *lua.LState, fn *lua.LFunction) error {
+	if r.timeout <= 0 {
+		return call(L, fn)
+	}
+
+	parent := L.Context()
+	if parent == nil {
+		defer L.RemoveContext()
+		parent = context.Background()
+	} else {
+		defer L.SetContext(parent)
+	}
+
+	ctx, cancel := context.WithTimeout(parent, r.timeout)
+	defer cancel()
+	L.SetContext(ctx)
+
+	err := call(L, fn)
+	if err != nil && ctx.Err() == context.DeadlineExceeded && parent.Err() == nil {
+		return errors.Errorf("timed out after %s", r.timeout)
+	}
+
+	return err
+}
+
+func call(L *lua.LState, fn *lua.LFunction) error {
+	err := L.CallByParam(lua.P{
+		Fn:      fn,
+		NRet:    0,
+		Protect: true,
+	})
+	if err != nil {
+		if aerr, ok := err.(*lua.ApiError); ok {
+			return errors.New(aerr.Object.String())
+		}
+	}
+
+	return err
+}
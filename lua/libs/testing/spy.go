@@ -0,0 +1,129 @@
+// (c) 2018, Jeff Chen <jefurry@qq.com>
+//
+// This file is part of Gola
+//
+// Copyright 2018 The Gola Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package testing
+
+import (
+	"fmt"
+	"github.com/yuin/gopher-lua"
+)
+
+const (
+	testingSpyTypeName = TestingLibName + ".SPY*"
+)
+
+func testingRegisterSpyMetatype(L *lua.LState) {
+	// meta table
+	mt := L.NewTypeMetatable(testingSpyTypeName)
+
+	L.SetField(mt, "__call", L.NewFunction(testingSpyCall))
+}
+
+// testingSpy creates a callable spy table, which records arguments of calls
+// in field `calls`, and calls through to fn if fn is not nil.
+func testingSpy(L *lua.LState) int {
+	L.Push(newSpy(L, L.OptFunction(1, nil)))
+
+	return 1
+}
+
+// testingMock replaces field key of table with a spy of fn, or a spy of the
+// original value if fn is nil, and returns the spy.
+// Note: The field is restored after the test or the describe block in which
+// it is mocked.
+func testingMock(L *lua.LState) int {
+	tbl := L.CheckTable(1)
+	key := L.CheckAny(2)
+	fn := L.OptFunction(3, nil)
+
+	value := L.GetTable(tbl, key)
+	if fn == nil {
+		switch value.(type) {
+		case *lua.LFunction:
+			fn = value.(*lua.LFunction)
+		case *lua.LTable:
+			if isSpy(L, value) {
+				fn = value.(*lua.LTable).RawGetString("fn").(*lua.LFunction)
+			}
+		}
+
+		if fn == nil {
+			L.ArgError(2, fmt.Sprintf("function expected, got %s", value.Type()))
+
+			return 0
+		}
+	}
+
+	r := getRunner(L, true)
+	r.mocks = append(r.mocks, &mock{
+		tbl:   tbl,
+		key:   key,
+		value: value,
+	})
+
+	s := newSpy(L, fn)
+	L.SetTable(tbl, key, s)
+	L.Push(s)
+
+	return 1
+}
+
+func testingSpyCall(L *lua.LState) int {
+	s := L.CheckTable(1)
+	top := L.GetTop()
+
+	args := L.NewTable()
+	for i := 2; i <= top; i++ {
+		args.Append(L.Get(i))
+	}
+
+	if calls, ok := s.RawGetString("calls").(*lua.LTable); ok {
+		calls.Append(args)
+	}
+
+	fn, ok := s.RawGetString("fn").(*lua.LFunction)
+	if !ok {
+		return 0
+	}
+
+	L.Push(fn)
+	for i := 2; i <= top; i++ {
+		L.Push(L.Get(i))
+	}
+	L.Call(top-1, lua.MultRet)
+
+	return L.GetTop() - top
+}
+
+func newSpy(L *lua.LState, fn *lua.LFunction) *lua.LTable {
+	s := L.NewTable()
+	s.RawSetString("calls", L.NewTable())
+	if fn != nil {
+		s.RawSetString("fn", fn)
+	}
+	L.SetMetatable(s, L.GetTypeMetatable(testingSpyTypeName))
+
+	return s
+}
+
+func isSpy(L *lua.LState, lv lua.LValue) bool {
+	tbl, ok := lv.(*lua.LTable)
+
+	return ok && L.GetMetatable(tbl) == L.GetTypeMetatable(testingSpyTypeName)
+}
+
+func checkSpy(L *lua.LState, n int) *lua.LTable {
+	lv := L.Get(n)
+	if !isSpy(L, lv) {
+		L.ArgError(n, fmt.Sprintf("spy expected, got %s", lv.Type()))
+
+		return nil
+	}
+
+	return lv.(*lua.LTable)
+}
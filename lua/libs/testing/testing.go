@@ -0,0 +1,211 @@
+// (c) 2018, Jeff Chen <jefurry@qq.com>
+//
+// This file is part of Gola
+//
+// Copyright 2018 The Gola Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+// Package testing implements unit test framework for Lua, like:
+//
+//	local testing = require("testing")
+//	local assert = testing.assert
+//
+//	testing.describe("math", function()
+//	    testing.beforeEach(function() ... end)
+//
+//	    testing.it("adds", function()
+//	        assert.equal(1 + 1, 2)
+//	    end)
+//	end)
+//
+// The tests are registered by loading the chunk, and executed by Run.
+package testing
+
+import (
+	"github.com/yuin/gopher-lua"
+	"strings"
+	"time"
+)
+
+const (
+	TestingLibName = "testing"
+)
+
+// Status of test.
+const (
+	StatusPass = "pass"
+	StatusFail = "fail"
+	StatusSkip = "skip"
+)
+
+type (
+	// Result is the result of a test.
+	Result struct {
+		// Names of the enclosing describe blocks, from the outermost.
+		Suites []string
+		Test   string
+		Status string
+		// Error message if failed.
+		Message  string
+		Duration time.Duration
+	}
+)
+
+func Open(L *lua.LState) {
+	L.PreloadModule(TestingLibName, Loader)
+}
+
+func Loader(L *lua.LState) int {
+	testingRegisterSpyMetatype(L)
+
+	testingmod := L.SetFuncs(L.NewTable(), testingFuncs)
+	testingmod.RawSetString("assert", L.SetFuncs(L.NewTable(), testingAssertFuncs))
+	L.Push(testingmod)
+
+	return 1
+}
+
+var testingFuncs = map[string]lua.LGFunction{
+	"describe":   testingDescribe,
+	"it":         testingIt,
+	"skip":       testingSkip,
+	"beforeAll":  testingBeforeAll,
+	"afterAll":   testingAfterAll,
+	"beforeEach": testingBeforeEach,
+	"afterEach":  testingAfterEach,
+	"spy":        testingSpy,
+	"mock":       testingMock,
+}
+
+// Name returns full name of test, the names of suites and test joined by " > ".
+func (r *Result) Name() string {
+	return strings.Join(append(append([]string{}, r.Suites...), r.Test), " > ")
+}
+
+// Run runs the tests registered in L in order, and returns the results.
+// The tests whose full name does not satisfy match are left out, a nil
+// match runs all tests.
+// Note: Mocks are restored after the test or the suite in which they are
+// mocked, and after all tests if mocked outside of describe blocks.
+func Run(L *lua.LState, match func(name string) bool) []*Result {
+	return RunTimeout(L, match, 0)
+}
+
+// RunTimeout runs the tests like Run, each test and hook fails if it does not
+// return within timeout, a timeout of 0 indicates no limit.
+func RunTimeout(L *lua.LState, match func(name string) bool, timeout time.Duration) []*Result {
+	r := getRunner(L, false)
+	if r == nil {
+		return []*Result{}
+	}
+
+	r.match = match
+	r.timeout = timeout
+	r.running = true
+	r.results = make([]*Result, 0)
+	r.runSuite(L, r.root, nil, "")
+	r.restore(L, 0)
+
+	return r.results
+}
+
+func testingDescribe(L *lua.LState) int {
+	name := L.CheckString(1)
+	fn := L.CheckFunction(2)
+
+	r := getRunner(L, true)
+	if r.running {
+		L.RaiseError("describe is not allowed in running tests")
+
+		return 0
+	}
+
+	s := newSuite(name, r.current)
+	r.current.nodes = append(r.current.nodes, s)
+
+	r.current = s
+	defer func() {
+		r.current = s.parent
+	}()
+
+	L.Push(fn)
+	L.Call(0, 0)
+
+	return 0
+}
+
+func testingIt(L *lua.LState) int {
+	addTest(L, false)
+
+	return 0
+}
+
+func testingSkip(L *lua.LState) int {
+	addTest(L, true)
+
+	return 0
+}
+
+func testingBeforeAll(L *lua.LState) int {
+	s := hookSuite(L)
+	s.beforeAll = append(s.beforeAll, L.CheckFunction(1))
+
+	return 0
+}
+
+func testingAfterAll(L *lua.LState) int {
+	s := hookSuite(L)
+	s.afterAll = append(s.afterAll, L.CheckFunction(1))
+
+	return 0
+}
+
+func testingBeforeEach(L *lua.LState) int {
+	s := hookSuite(L)
+	s.beforeEach = append(s.beforeEach, L.CheckFunction(1))
+
+	return 0
+}
+
+func testingAfterEach(L *lua.LState) int {
+	s := hookSuite(L)
+	s.afterEach = append(s.afterEach, L.CheckFunction(1))
+
+	return 0
+}
+
+func addTest(L *lua.LState, skip bool) {
+	name := L.CheckString(1)
+	fn := L.OptFunction(2, nil)
+	if fn == nil && !skip {
+		L.ArgError(2, "function expected")
+
+		return
+	}
+
+	r := getRunner(L, true)
+	if r.running {
+		L.RaiseError("it is not allowed in running tests")
+
+		return
+	}
+
+	r.current.nodes = append(r.current.nodes, &test{
+		name: name,
+		fn:   fn,
+		skip: skip,
+	})
+}
+
+// hookSuite returns the suite being described, to which hooks are added.
+func hookSuite(L *lua.LState) *suite {
+	r := getRunner(L, true)
+	if r.running {
+		L.RaiseError("hooks are not allowed in running tests")
+
+		return nil
+	}
+
+	return r.current
+}
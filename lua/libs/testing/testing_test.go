@@ -0,0 +1,331 @@
+// (c) 2018, Jeff Chen <jefurry@qq.com>
+//
+// This file is part of Gola
+//
+// Copyright 2018 The Gola Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package testing
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/yuin/gopher-lua"
+	"strings"
+	"testing"
+	"time"
+)
+
+func runCode(t *testing.T, code string, match func(string) bool) []*Result {
+	L := lua.NewState()
+	defer L.Close()
+	Open(L)
+
+	if err := L.DoString(code); !assert.NoError(t, err) {
+		return nil
+	}
+
+	return Run(L, match)
+}
+
+func TestTestingRun(t *testing.T) {
+	code := `
+	local testing = require("testing")
+	local assert = testing.assert
+	local log = {}
+
+	testing.describe("outer", function()
+		testing.beforeAll(function() table.insert(log, "beforeAll") end)
+		testing.afterAll(function() table.insert(log, "afterAll") end)
+		testing.beforeEach(function() table.insert(log, "outer.beforeEach") end)
+		testing.afterEach(function() table.insert(log, "outer.afterEach") end)
+
+		testing.it("passes", function()
+			assert.equal(2, 1 + 1)
+		end)
+
+		testing.describe("inner", function()
+			testing.beforeEach(function() table.insert(log, "inner.beforeEach") end)
+
+			testing.it("fails", function()
+				assert.equal({a = 1, b = {2, 3}}, {a = 1, b = {2, 4}}, "tables")
+			end)
+		end)
+
+		testing.skip("skipped")
+	end)
+
+	testing.it("log", function()
+		assert.equal({
+			"beforeAll",
+			"outer.beforeEach", "outer.afterEach",
+			"outer.beforeEach", "inner.beforeEach", "outer.afterEach",
+			"afterAll",
+		}, log)
+	end)
+	`
+
+	results := runCode(t, code, nil)
+	if !assert.Len(t, results, 4) {
+		return
+	}
+
+	names := make([]string, 0, len(results))
+	for _, r := range results {
+		names = append(names, r.Name())
+	}
+	if !assert.Equal(t, []string{"outer > passes", "outer > inner > fails", "outer > skipped", "log"}, names) {
+		return
+	}
+
+	if !assert.Equal(t, StatusPass, results[0].Status) {
+		return
+	}
+
+	if !assert.Equal(t, StatusFail, results[1].Status) {
+		return
+	}
+
+	msg := results[1].Message
+	if !assert.Contains(t, msg, "tables: tables are not equal:") {
+		return
+	}
+
+	if !assert.Contains(t, msg, "--- expected\n+++ actual\n") {
+		return
+	}
+
+	if !assert.Contains(t, msg, "-    3\n+    4\n") {
+		return
+	}
+
+	if !assert.Equal(t, StatusSkip, results[2].Status) {
+		return
+	}
+
+	if !assert.Equal(t, StatusPass, results[3].Status, results[3].Message) {
+		return
+	}
+}
+
+func TestTestingMatch(t *testing.T) {
+	code := `
+	local testing = require("testing")
+
+	testing.describe("a", function()
+		testing.beforeAll(function() error("not selected") end)
+		testing.it("x", function() end)
+	end)
+
+	testing.describe("b", function()
+		testing.it("x", function() end)
+		testing.it("y", function() end)
+	end)
+	`
+
+	results := runCode(t, code, func(name string) bool {
+		return strings.HasPrefix(name, "b > ")
+	})
+	if !assert.Len(t, results, 2) {
+		return
+	}
+
+	if !assert.Equal(t, "b > y", results[1].Name()) {
+		return
+	}
+
+	if !assert.Equal(t, StatusPass, results[1].Status) {
+		return
+	}
+}
+
+func TestTestingHookError(t *testing.T) {
+	code := `
+	local testing = require("testing")
+
+	testing.describe("a", function()
+		testing.beforeAll(function() error("boom") end)
+		testing.it("x", function() end)
+		testing.it("y", function() end)
+	end)
+	`
+
+	results := runCode(t, code, nil)
+	if !assert.Len(t, results, 2) {
+		return
+	}
+
+	for _, r := range results {
+		if !assert.Equal(t, StatusFail, r.Status) {
+			return
+		}
+
+		if !assert.Contains(t, r.Message, "beforeAll: ") {
+			return
+		}
+
+		if !assert.Contains(t, r.Message, "boom") {
+			return
+		}
+	}
+}
+
+func TestTestingTimeout(t *testing.T) {
+	L := lua.NewState()
+	defer L.Close()
+	Open(L)
+
+	err := L.DoString(`
+	local testing = require("testing")
+
+	testing.it("loops", function() while true do end end)
+	testing.it("passes", function() end)
+	`)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	results := RunTimeout(L, nil, 50*time.Millisecond)
+	if !assert.Len(t, results, 2) {
+		return
+	}
+
+	if !assert.Equal(t, StatusFail, results[0].Status) {
+		return
+	}
+
+	if !assert.Equal(t, "timed out after 50ms", results[0].Message) {
+		return
+	}
+
+	// the context of lua state is removed after tests.
+	if !assert.Equal(t, StatusPass, results[1].Status) {
+		return
+	}
+
+	if !assert.Nil(t, L.Context()) {
+		return
+	}
+}
+
+func TestTestingAssert(t *testing.T) {
+	code := `
+	local testing = require("testing")
+	local assert = testing.assert
+
+	local function fails(fn, text)
+		local msg = assert.errors(fn)
+		assert.match(msg, text)
+	end
+
+	testing.it("assertions", function()
+		assert.equal("a", "a")
+		assert.notEqual({1}, {2})
+		assert.truthy(0)
+		assert.falsy(nil)
+		assert.isNil(nil)
+		assert.notNil(false)
+		assert.near(1.0, 1.05, 0.1)
+		assert.match("hello world", "^hello")
+
+		local t = {}
+		assert.same(t, t)
+		fails(function() assert.same({}, {}) end, "expected same value")
+		fails(function() assert.equal(1, 2) end, "expected: 1\nactual:   2")
+		fails(function() assert.equal("1", 1, "msg") end, 'msg: expected: "1"')
+		fails(function() assert.truthy(false) end, "expected truthy value, got false")
+		fails(function() assert.near(1, 2, 0.5) end, "within")
+		fails(function() assert.errors(function() end) end, "expected an error")
+		fails(function() assert.fail("custom") end, "custom")
+
+		local err = assert.errors(function() error({code = 1}) end)
+		assert.equal({code = 1}, err)
+		assert.errors(function() error("boom") end, "boom")
+
+		local a, b = {}, {}
+		a.self, b.self = a, b
+		assert.equal(a, b)
+	end)
+	`
+
+	results := runCode(t, code, nil)
+	if !assert.Len(t, results, 1) {
+		return
+	}
+
+	if !assert.Equal(t, StatusPass, results[0].Status, results[0].Message) {
+		return
+	}
+}
+
+func TestTestingSpy(t *testing.T) {
+	code := `
+	local testing = require("testing")
+	local assert = testing.assert
+
+	local obj = {}
+	function obj:add(a, b) return a + b end
+	local original = obj.add
+
+	testing.it("spy", function()
+		local double = testing.spy(function(x) return x * 2 end)
+		assert.equal(4, double(2))
+		assert.calledWith(double, 2)
+
+		local s = testing.spy()
+		assert.notCalled(s)
+		assert.isNil(s(2))
+		s("a", {1})
+		assert.called(s)
+		assert.called(s, 2)
+		assert.calledWith(s, "a", {1})
+		assert.equal({{2}, {"a", {1}}}, s.calls)
+
+		local msg = assert.errors(function() assert.calledWith(s, 3) end)
+		assert.match(msg, 'expected spy to be called with %(3%)')
+	end)
+
+	testing.it("mock", function()
+		local s = testing.mock(obj, "add")
+		assert.equal(3, obj:add(1, 2))
+		assert.calledWith(s, obj, 1, 2)
+
+		testing.mock(obj, "add", function() return 0 end)
+		assert.equal(0, obj:add(1, 2))
+	end)
+
+	testing.it("restored", function()
+		assert.same(original, obj.add)
+	end)
+	`
+
+	results := runCode(t, code, nil)
+	if !assert.Len(t, results, 3) {
+		return
+	}
+
+	for _, r := range results {
+		if !assert.Equal(t, StatusPass, r.Status, r.Name()+": "+r.Message) {
+			return
+		}
+	}
+}
+
+func TestTestingNested(t *testing.T) {
+	code := `
+	local testing = require("testing")
+
+	testing.it("x", function()
+		testing.it("y", function() end)
+	end)
+	`
+
+	results := runCode(t, code, nil)
+	if !assert.Len(t, results, 1) {
+		return
+	}
+
+	if !assert.Contains(t, results[0].Message, "it is not allowed in running tests") {
+		return
+	}
+}
@@ -0,0 +1,199 @@
+// (c) 2018, Jeff Chen <jefurry@qq.com>
+//
+// This file is part of Gola
+//
+// Copyright 2018 The Gola Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package testing
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/yuin/gopher-lua"
+	"sort"
+	"strings"
+)
+
+type (
+	// tablePair is a pair of tables under comparison.
+	tablePair struct {
+		a, b *lua.LTable
+	}
+)
+
+// deepEqual reports whether values are equal, tables are equal if they have
+// equal keys and values. Metatables are not compared.
+func deepEqual(a, b lua.LValue) bool {
+	return equalValue(a, b, make(map[tablePair]bool))
+}
+
+func equalValue(a, b lua.LValue, seen map[tablePair]bool) bool {
+	ta, ok1 := a.(*lua.LTable)
+	tb, ok2 := b.(*lua.LTable)
+	if !ok1 || !ok2 {
+		return a == b
+	}
+
+	if ta == tb || seen[tablePair{ta, tb}] {
+		return true
+	}
+	seen[tablePair{ta, tb}] = true
+
+	equal := true
+	n := 0
+	ta.ForEach(func(k, v lua.LValue) {
+		n++
+		if equal && !equalValue(v, tb.RawGet(k), seen) {
+			equal = false
+		}
+	})
+
+	if !equal {
+		return false
+	}
+
+	tb.ForEach(func(_, _ lua.LValue) {
+		n--
+	})
+
+	return n == 0
+}
+
+// dump formats value as lua literal, tables are indented with keys in order.
+func dump(lv lua.LValue) string {
+	buf := &bytes.Buffer{}
+	dumpValue(buf, lv, "", make(map[*lua.LTable]bool))
+
+	return buf.String()
+}
+
+// dumpLine formats value as lua literal in a line.
+func dumpLine(lv lua.LValue) string {
+	s := dump(lv)
+	lines := strings.Split(s, "\n")
+	for i := range lines {
+		lines[i] = strings.TrimSpace(lines[i])
+	}
+
+	s = strings.Join(lines, " ")
+	s = strings.Replace(s, "{ ", "{", -1)
+
+	return strings.Replace(s, " }", "}", -1)
+}
+
+func dumpValue(buf *bytes.Buffer, lv lua.LValue, indent string, seen map[*lua.LTable]bool) {
+	switch value := lv.(type) {
+	case lua.LString:
+		fmt.Fprintf(buf, "%q", string(value))
+	case *lua.LTable:
+		if seen[value] {
+			buf.WriteString("<cycle>")
+
+			return
+		}
+
+		// the sequence part is written without keys.
+		n := 0
+		for value.RawGetInt(n+1) != lua.LNil {
+			n++
+		}
+
+		keys := sortedKeys(value, n)
+		if n == 0 && len(keys) == 0 {
+			buf.WriteString("{}")
+
+			return
+		}
+
+		seen[value] = true
+		defer delete(seen, value)
+
+		buf.WriteString("{\n")
+		for i := 1; i <= n; i++ {
+			buf.WriteString(indent + "  ")
+			dumpValue(buf, value.RawGetInt(i), indent+"  ", seen)
+			if i < n || len(keys) > 0 {
+				buf.WriteString(",")
+			}
+			buf.WriteString("\n")
+		}
+
+		for i, k := range keys {
+			buf.WriteString(indent + "  ")
+			if s, ok := k.(lua.LString); ok && isIdent(string(s)) {
+				buf.WriteString(string(s))
+			} else {
+				buf.WriteString("[")
+				dumpValue(buf, k, indent+"  ", seen)
+				buf.WriteString("]")
+			}
+			buf.WriteString(" = ")
+			dumpValue(buf, value.RawGet(k), indent+"  ", seen)
+			if i < len(keys)-1 {
+				buf.WriteString(",")
+			}
+			buf.WriteString("\n")
+		}
+		buf.WriteString(indent + "}")
+	default:
+		buf.WriteString(lv.String())
+	}
+}
+
+// sortedKeys returns keys of table except the sequence 1..n, numbers first,
+// then strings, then the others by their string.
+func sortedKeys(tbl *lua.LTable, n int) []lua.LValue {
+	keys := make([]lua.LValue, 0)
+	tbl.ForEach(func(k, _ lua.LValue) {
+		if i, ok := k.(lua.LNumber); ok && float64(i) == float64(int(i)) && int(i) >= 1 && int(i) <= n {
+			return
+		}
+
+		keys = append(keys, k)
+	})
+
+	rank := func(lv lua.LValue) int {
+		switch lv.(type) {
+		case lua.LNumber:
+			return 0
+		case lua.LString:
+			return 1
+		}
+
+		return 2
+	}
+
+	sort.Slice(keys, func(i, j int) bool {
+		ri, rj := rank(keys[i]), rank(keys[j])
+		if ri != rj {
+			return ri < rj
+		}
+
+		if ri == 0 {
+			return keys[i].(lua.LNumber) < keys[j].(lua.LNumber)
+		}
+
+		return keys[i].String() < keys[j].String()
+	})
+
+	return keys
+}
+
+func isIdent(s string) bool {
+	if s == "" {
+		return false
+	}
+
+	for i, c := range s {
+		switch {
+		case c == '_', c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z':
+		case i > 0 && c >= '0' && c <= '9':
+		default:
+			return false
+		}
+	}
+
+	return true
+}
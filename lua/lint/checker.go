@@ -0,0 +1,603 @@
+// (c) 2018, Jeff Chen <jefurry@qq.com>
+//
+// This file is part of Gola
+//
+// Copyright 2018 The Gola Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package lint
+
+import (
+	"fmt"
+	"github.com/yuin/gopher-lua/ast"
+	"sort"
+	"strings"
+)
+
+// Kinds of variable.
+const (
+	varLocal = iota
+	varFunction
+	varParam
+	varLoop
+)
+
+type (
+	variable struct {
+		name string
+		line int
+		kind int
+		used bool
+		// name of module if assigned by require.
+		module string
+	}
+
+	scope struct {
+		parent *scope
+		// variables in order of declaration.
+		vars []*variable
+	}
+
+	global struct {
+		// line of the first assignment, 0 if not assigned.
+		line   int
+		read   bool
+		module string
+	}
+
+	// checker checks a chunk, the checks depending on the globals assigned in
+	// chunk are deferred to the end of chunk.
+	checker struct {
+		file string
+		env  *env
+		// env of rule engine policy, nil if not linting for rule engine.
+		policy *env
+		// extra globals of linter.
+		extra   map[string]bool
+		globals map[string]*global
+		// fields assigned to global tables or modules, keyed by "table.field".
+		fields map[string]bool
+		scope  *scope
+		later  []func()
+		diags  []*Diagnostic
+	}
+)
+
+func (c *checker) check(chunk []ast.Stmt) {
+	c.fields = make(map[string]bool)
+	c.later = make([]func(), 0)
+
+	c.block(chunk)
+
+	for _, fn := range c.later {
+		fn()
+	}
+
+	names := make([]string, 0, len(c.globals))
+	for name := range c.globals {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		g := c.globals[name]
+		if g.line > 0 && !g.read && !c.available().global(name) && !c.extra[name] {
+			c.report(g.line, CodeUnusedGlobal, "unused global %s", name)
+		}
+	}
+}
+
+// available returns env of the lua states in which chunk runs.
+func (c *checker) available() *env {
+	if c.policy != nil {
+		return c.policy
+	}
+
+	return c.env
+}
+
+func (c *checker) report(line int, code, format string, args ...interface{}) {
+	c.diags = append(c.diags, &Diagnostic{
+		File:    c.file,
+		Line:    line,
+		Code:    code,
+		Message: fmt.Sprintf(format, args...),
+	})
+}
+
+func (c *checker) openScope() {
+	c.scope = &scope{
+		parent: c.scope,
+		vars:   make([]*variable, 0),
+	}
+}
+
+// closeScope closes scope, and reports unused locals. Locals whose name starts
+// with "_" are ignored.
+func (c *checker) closeScope() {
+	for _, v := range c.scope.vars {
+		if v.used || strings.HasPrefix(v.name, "_") {
+			continue
+		}
+
+		switch v.kind {
+		case varLocal:
+			c.report(v.line, CodeUnusedLocal, "unused local %s", v.name)
+		case varFunction:
+			c.report(v.line, CodeUnusedLocal, "unused local function %s", v.name)
+		}
+	}
+
+	c.scope = c.scope.parent
+}
+
+func (c *checker) declare(name string, line, kind int) *variable {
+	if name != "_" && name != "self" {
+		if v := c.resolve(name); v != nil {
+			c.report(line, CodeShadowing, "local %s shadows local declared at line %d", name, v.line)
+		}
+	}
+
+	v := &variable{
+		name: name,
+		line: line,
+		kind: kind,
+	}
+	c.scope.vars = append(c.scope.vars, v)
+
+	return v
+}
+
+// resolve returns the local variable of name in scope, or nil if name is global.
+func (c *checker) resolve(name string) *variable {
+	for s := c.scope; s != nil; s = s.parent {
+		for i := len(s.vars) - 1; i >= 0; i-- {
+			if s.vars[i].name == name {
+				return s.vars[i]
+			}
+		}
+	}
+
+	return nil
+}
+
+func (c *checker) global(name string) *global {
+	g, ok := c.globals[name]
+	if !ok {
+		g = &global{}
+		c.globals[name] = g
+	}
+
+	return g
+}
+
+func (c *checker) block(stmts []ast.Stmt) {
+	c.openScope()
+	c.stmts(stmts)
+	c.closeScope()
+}
+
+// stmts checks statements in current scope, and reports the statement after
+// a statement which never completes as unreachable.
+func (c *checker) stmts(stmts []ast.Stmt) {
+	reported := false
+	for i, stmt := range stmts {
+		c.stmt(stmt)
+
+		if !reported && i < len(stmts)-1 && c.terminates(stmt) {
+			c.report(stmts[i+1].Line(), CodeUnreachable, "unreachable code")
+			reported = true
+		}
+	}
+}
+
+func (c *checker) stmt(stmt ast.Stmt) {
+	switch s := stmt.(type) {
+	case *ast.AssignStmt:
+		c.exprs(s.Rhs)
+		for i, lhs := range s.Lhs {
+			c.assign(lhs, requiredModule(s.Rhs, i))
+		}
+	case *ast.LocalAssignStmt:
+		// local function is visible in its body.
+		if len(s.Names) == 1 && len(s.Exprs) == 1 {
+			if fn, ok := s.Exprs[0].(*ast.FunctionExpr); ok {
+				c.declare(s.Names[0], s.Line(), varFunction)
+				c.function(fn, false)
+
+				return
+			}
+		}
+
+		c.exprs(s.Exprs)
+		for i, name := range s.Names {
+			v := c.declare(name, s.Line(), varLocal)
+			v.module = requiredModule(s.Exprs, i)
+		}
+	case *ast.FuncCallStmt:
+		c.expr(s.Expr)
+	case *ast.DoBlockStmt:
+		c.block(s.Stmts)
+	case *ast.WhileStmt:
+		c.expr(s.Condition)
+		c.block(s.Stmts)
+	case *ast.RepeatStmt:
+		// the condition sees locals of body.
+		c.openScope()
+		c.stmts(s.Stmts)
+		c.expr(s.Condition)
+		c.closeScope()
+	case *ast.IfStmt:
+		c.expr(s.Condition)
+		c.block(s.Then)
+		c.block(s.Else)
+	case *ast.NumberForStmt:
+		c.expr(s.Init)
+		c.expr(s.Limit)
+		if s.Step != nil {
+			c.expr(s.Step)
+		}
+
+		c.openScope()
+		c.declare(s.Name, s.Line(), varLoop)
+		c.stmts(s.Stmts)
+		c.closeScope()
+	case *ast.GenericForStmt:
+		c.exprs(s.Exprs)
+
+		c.openScope()
+		for _, name := range s.Names {
+			c.declare(name, s.Line(), varLoop)
+		}
+		c.stmts(s.Stmts)
+		c.closeScope()
+	case *ast.FuncDefStmt:
+		if s.Name.Func != nil {
+			c.assign(s.Name.Func, "")
+		} else {
+			c.expr(s.Name.Receiver)
+			c.assignField(s.Name.Receiver, s.Name.Method)
+		}
+
+		c.function(s.Func, s.Name.Func == nil)
+	case *ast.ReturnStmt:
+		c.exprs(s.Exprs)
+	}
+}
+
+// assign checks target of assignment, module is the name of module if the
+// value is required.
+func (c *checker) assign(target ast.Expr, module string) {
+	switch t := target.(type) {
+	case *ast.IdentExpr:
+		if v := c.resolve(t.Value); v != nil {
+			if module != "" {
+				v.module = module
+			}
+
+			return
+		}
+
+		g := c.global(t.Value)
+		if g.line == 0 {
+			g.line = t.Line()
+		}
+
+		if module != "" {
+			g.module = module
+		}
+	case *ast.AttrGetExpr:
+		c.expr(t.Object)
+		c.expr(t.Key)
+		if key, ok := t.Key.(*ast.StringExpr); ok {
+			c.assignField(t.Object, key.Value)
+		}
+	default:
+		c.expr(target)
+	}
+}
+
+// assignField records field assigned to global table or module.
+func (c *checker) assignField(object ast.Expr, field string) {
+	if name := c.tableName(object); name != "" {
+		c.fields[name+"."+field] = true
+	}
+}
+
+func (c *checker) function(fn *ast.FunctionExpr, method bool) {
+	c.openScope()
+	if method {
+		c.declare("self", fn.Line(), varParam)
+	}
+
+	for _, name := range fn.ParList.Names {
+		c.declare(name, fn.Line(), varParam)
+	}
+
+	c.stmts(fn.Stmts)
+	c.closeScope()
+}
+
+func (c *checker) exprs(exprs []ast.Expr) {
+	for _, expr := range exprs {
+		c.expr(expr)
+	}
+}
+
+func (c *checker) expr(expr ast.Expr) {
+	switch e := expr.(type) {
+	case *ast.IdentExpr:
+		c.read(e)
+	case *ast.AttrGetExpr:
+		c.expr(e.Object)
+		c.expr(e.Key)
+		c.attr(e)
+	case *ast.FuncCallExpr:
+		if e.Func != nil {
+			c.expr(e.Func)
+		} else {
+			c.expr(e.Receiver)
+		}
+
+		c.exprs(e.Args)
+		c.call(e)
+	case *ast.FunctionExpr:
+		c.function(e, false)
+	case *ast.TableExpr:
+		for _, field := range e.Fields {
+			if field.Key != nil {
+				c.expr(field.Key)
+			}
+
+			c.expr(field.Value)
+		}
+	case *ast.LogicalOpExpr:
+		c.expr(e.Lhs)
+		c.expr(e.Rhs)
+	case *ast.RelationalOpExpr:
+		c.expr(e.Lhs)
+		c.expr(e.Rhs)
+	case *ast.StringConcatOpExpr:
+		c.expr(e.Lhs)
+		c.expr(e.Rhs)
+	case *ast.ArithmeticOpExpr:
+		c.expr(e.Lhs)
+		c.expr(e.Rhs)
+	case *ast.UnaryMinusOpExpr:
+		c.expr(e.Expr)
+	case *ast.UnaryNotOpExpr:
+		c.expr(e.Expr)
+	case *ast.UnaryLenOpExpr:
+		c.expr(e.Expr)
+	}
+}
+
+// read marks local as used, or checks that global is defined at the end.
+func (c *checker) read(e *ast.IdentExpr) {
+	if v := c.resolve(e.Value); v != nil {
+		v.used = true
+
+		return
+	}
+
+	name, line := e.Value, e.Line()
+	g := c.global(name)
+	g.read = true
+
+	c.later = append(c.later, func() {
+		switch {
+		case c.extra[name], c.available().global(name), g.line > 0:
+		case c.policy != nil && c.env.global(name):
+			c.report(line, CodeStripped, "%s is removed by rule engine policy", name)
+		default:
+			c.report(line, CodeUndefinedGlobal, "undefined global %s", name)
+		}
+	})
+}
+
+// attr checks that field of global table or module is defined.
+func (c *checker) attr(e *ast.AttrGetExpr) {
+	key, ok := e.Key.(*ast.StringExpr)
+	if !ok {
+		return
+	}
+
+	name := c.tableName(e.Object)
+	if name == "" || name == "_G" {
+		return
+	}
+
+	field, line := key.Value, e.Line()
+	c.later = append(c.later, func() {
+		if c.fields[name+"."+field] {
+			return
+		}
+
+		fields, ok := c.tableFields(name)
+		if !ok || c.env.field(fields, field) {
+			return
+		}
+
+		if c.policy != nil && c.env.field(c.env.globals[name], field) {
+			c.report(line, CodeStripped, "%s.%s is removed by rule engine policy", name, field)
+
+			return
+		}
+
+		c.report(line, CodeUndefinedField, "undefined field %s of %s", field, name)
+	})
+}
+
+// call checks arity of known function.
+func (c *checker) call(e *ast.FuncCallExpr) {
+	name := c.funcName(e)
+	if name == "" {
+		return
+	}
+
+	sig, ok := signatures[name]
+	if !ok {
+		return
+	}
+
+	n, multiple := len(e.Args), false
+	if n > 0 {
+		switch e.Args[n-1].(type) {
+		case *ast.FuncCallExpr, *ast.Comma3Expr:
+			n, multiple = n-1, true
+		}
+	}
+
+	if msg := sig.check(name, n, multiple); msg != "" {
+		c.report(e.Line(), CodeArity, "%s", msg)
+	}
+}
+
+// funcName returns the name of called function in signatures, or "" if the
+// function is unknown, e.g. a local function or a method.
+func (c *checker) funcName(e *ast.FuncCallExpr) string {
+	switch fn := e.Func.(type) {
+	case *ast.IdentExpr:
+		if c.resolve(fn.Value) != nil {
+			return ""
+		}
+
+		if g, ok := c.globals[fn.Value]; ok && g.line > 0 {
+			return ""
+		}
+
+		return fn.Value
+	case *ast.AttrGetExpr:
+		key, ok := fn.Key.(*ast.StringExpr)
+		if !ok {
+			return ""
+		}
+
+		name := c.tableName(fn.Object)
+		if name == "" || c.fields[name+"."+key.Value] {
+			return ""
+		}
+
+		return name + "." + key.Value
+	}
+
+	return ""
+}
+
+// tableName returns the name of global table or module of expr, or "" if
+// expr is not a global table or a module.
+func (c *checker) tableName(expr ast.Expr) string {
+	ident, ok := expr.(*ast.IdentExpr)
+	if !ok {
+		return ""
+	}
+
+	if v := c.resolve(ident.Value); v != nil {
+		return v.module
+	}
+
+	if g, ok := c.globals[ident.Value]; ok && g.module != "" {
+		return g.module
+	}
+
+	if c.env.global(ident.Value) {
+		return ident.Value
+	}
+
+	return ""
+}
+
+// tableFields returns fields of global table or module, which are nil if
+// unknown, and false if name is not available.
+func (c *checker) tableFields(name string) (map[string]bool, bool) {
+	if fields, ok := c.env.modules[name]; ok {
+		return fields, true
+	}
+
+	fields, ok := c.available().globals[name]
+
+	return fields, ok
+}
+
+// terminates reports whether statement never completes normally.
+func (c *checker) terminates(stmt ast.Stmt) bool {
+	switch s := stmt.(type) {
+	case *ast.ReturnStmt, *ast.BreakStmt:
+		return true
+	case *ast.DoBlockStmt:
+		return c.terminatesAny(s.Stmts)
+	case *ast.IfStmt:
+		return len(s.Else) > 0 && c.terminatesAny(s.Then) && c.terminatesAny(s.Else)
+	case *ast.FuncCallStmt:
+		if call, ok := s.Expr.(*ast.FuncCallExpr); ok {
+			name := c.funcName(call)
+
+			return name == "error" || name == "os.exit"
+		}
+	case *ast.WhileStmt:
+		_, ok := s.Condition.(*ast.TrueExpr)
+
+		return ok && !hasBreak(s.Stmts)
+	case *ast.RepeatStmt:
+		_, ok := s.Condition.(*ast.FalseExpr)
+
+		return ok && !hasBreak(s.Stmts)
+	}
+
+	return false
+}
+
+func (c *checker) terminatesAny(stmts []ast.Stmt) bool {
+	for _, stmt := range stmts {
+		if c.terminates(stmt) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// hasBreak reports whether statements break the enclosing loop.
+func hasBreak(stmts []ast.Stmt) bool {
+	for _, stmt := range stmts {
+		switch s := stmt.(type) {
+		case *ast.BreakStmt:
+			return true
+		case *ast.DoBlockStmt:
+			if hasBreak(s.Stmts) {
+				return true
+			}
+		case *ast.IfStmt:
+			if hasBreak(s.Then) || hasBreak(s.Else) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// requiredModule returns the module name if the i-th expression is a call
+// of require with a string, like `require("json")`.
+func requiredModule(exprs []ast.Expr, i int) string {
+	if i >= len(exprs) {
+		return ""
+	}
+
+	call, ok := exprs[i].(*ast.FuncCallExpr)
+	if !ok || len(call.Args) != 1 {
+		return ""
+	}
+
+	fn, ok := call.Func.(*ast.IdentExpr)
+	if !ok || fn.Value != "require" {
+		return ""
+	}
+
+	if s, ok := call.Args[0].(*ast.StringExpr); ok {
+		return s.Value
+	}
+
+	return ""
+}
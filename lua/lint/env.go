@@ -0,0 +1,138 @@
+// (c) 2018, Jeff Chen <jefurry@qq.com>
+//
+// This file is part of Gola
+//
+// Copyright 2018 The Gola Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package lint
+
+import (
+	"github.com/jefurry/gola/lua/libs"
+	"github.com/jefurry/gola/lua/reng"
+	"github.com/yuin/gopher-lua"
+	"sync"
+)
+
+type (
+	// env is the globals and modules defined in lua states, the fields of a
+	// table are nil if the value is not a table.
+	env struct {
+		globals map[string]map[string]bool
+		modules map[string]map[string]bool
+	}
+)
+
+var (
+	golaEnv     *env
+	golaEnvOnce sync.Once
+)
+
+// defaultEnv returns the environment of lua states with all libraries of gola.
+func defaultEnv() *env {
+	golaEnvOnce.Do(func() {
+		L := lua.NewState()
+		defer L.Close()
+		libs.OpenLibs(L)
+
+		golaEnv = newEnv(L)
+	})
+
+	return golaEnv
+}
+
+// policyEnv returns the environment of lua states sandboxed by policy.
+func policyEnv(policy *reng.Policy) (*env, error) {
+	L := lua.NewState(lua.Options{SkipOpenLibs: true})
+	defer L.Close()
+
+	if err := policy.Apply(L); err != nil {
+		return nil, err
+	}
+
+	return newEnv(L), nil
+}
+
+func newEnv(L *lua.LState) *env {
+	e := &env{
+		globals: make(map[string]map[string]bool),
+		modules: make(map[string]map[string]bool),
+	}
+
+	L.G.Global.ForEach(func(k, v lua.LValue) {
+		if name, ok := k.(lua.LString); ok {
+			e.globals[string(name)] = tableFields(v)
+		}
+	})
+
+	// the modules of require, preloaded by libs.
+	pkg, ok := L.GetGlobal(lua.LoadLibName).(*lua.LTable)
+	if !ok {
+		return e
+	}
+
+	preload, ok := L.GetField(pkg, "preload").(*lua.LTable)
+	if !ok {
+		return e
+	}
+
+	names := make([]string, 0)
+	preload.ForEach(func(k, _ lua.LValue) {
+		if name, ok := k.(lua.LString); ok {
+			names = append(names, string(name))
+		}
+	})
+
+	for _, name := range names {
+		err := L.CallByParam(lua.P{
+			Fn:      L.GetGlobal("require"),
+			NRet:    1,
+			Protect: true,
+		}, lua.LString(name))
+		if err != nil {
+			e.modules[name] = nil
+
+			continue
+		}
+
+		e.modules[name] = tableFields(L.Get(-1))
+		L.Pop(1)
+	}
+
+	return e
+}
+
+// global reports whether name is a global.
+func (e *env) global(name string) bool {
+	_, ok := e.globals[name]
+
+	return ok
+}
+
+// field reports whether field of global table or module is defined, it is
+// true if the fields are unknown.
+func (e *env) field(fields map[string]bool, name string) bool {
+	return fields == nil || fields[name]
+}
+
+func tableFields(lv lua.LValue) map[string]bool {
+	tbl, ok := lv.(*lua.LTable)
+	if !ok {
+		return nil
+	}
+
+	fields := make(map[string]bool)
+	tbl.ForEach(func(k, _ lua.LValue) {
+		if name, ok := k.(lua.LString); ok {
+			fields[string(name)] = true
+		}
+	})
+
+	// fields of an empty table are unknown, e.g. a proxy of __index.
+	if len(fields) == 0 {
+		return nil
+	}
+
+	return fields
+}
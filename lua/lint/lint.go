@@ -0,0 +1,132 @@
+// (c) 2018, Jeff Chen <jefurry@qq.com>
+//
+// This file is part of Gola
+//
+// Copyright 2018 The Gola Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+// Package lint implements static checks of Lua sources, it reports undefined
+// and unused globals, unused locals, shadowing, unreachable code, wrong arity
+// of known functions, and calls to functions removed by policy of rule engine.
+package lint
+
+import (
+	"fmt"
+	"github.com/jefurry/gola/lua/reng"
+	"github.com/yuin/gopher-lua/parse"
+	"io"
+	"os"
+	"sort"
+	"strings"
+)
+
+// Codes of diagnostics.
+const (
+	CodeSyntax          = "syntax"
+	CodeUndefinedGlobal = "undefined-global"
+	CodeUnusedGlobal    = "unused-global"
+	CodeUndefinedField  = "undefined-field"
+	CodeUnusedLocal     = "unused-local"
+	CodeShadowing       = "shadowing"
+	CodeUnreachable     = "unreachable"
+	CodeArity           = "arity"
+	CodeStripped        = "stripped"
+)
+
+type (
+	// Diagnostic is a problem found in source.
+	Diagnostic struct {
+		File    string `json:"file"`
+		Line    int    `json:"line"`
+		Code    string `json:"code"`
+		Message string `json:"message"`
+	}
+
+	// Linter checks lua sources against the globals and modules of libs.OpenLibs.
+	Linter struct {
+		// Extra globals defined in lua states, e.g. `facts` of rule sets.
+		Globals []string
+
+		// Policy of rule engine, the sources are checked against the globals
+		// of lua states sandboxed by policy if not nil, and the references to
+		// functions removed by policy are reported as CodeStripped.
+		Policy *reng.Policy
+	}
+)
+
+// New creates linter.
+func New() *Linter {
+	return &Linter{
+		Globals: make([]string, 0),
+	}
+}
+
+// Lint checks source read from r, name is the file name of diagnostics.
+// Note: Syntax errors are reported as diagnostics instead of error.
+func (l *Linter) Lint(name string, r io.Reader) ([]*Diagnostic, error) {
+	chunk, err := parse.Parse(r, name)
+	if err != nil {
+		perr, ok := err.(*parse.Error)
+		if !ok {
+			return nil, err
+		}
+
+		line := perr.Pos.Line
+		msg := strings.TrimSpace(perr.Message)
+		if line == parse.EOF {
+			line = 0
+			msg += " at EOF"
+		} else if perr.Token != "" {
+			msg += fmt.Sprintf(" near '%s'", perr.Token)
+		}
+
+		return []*Diagnostic{&Diagnostic{
+			File:    name,
+			Line:    line,
+			Code:    CodeSyntax,
+			Message: msg,
+		}}, nil
+	}
+
+	c := &checker{
+		file:    name,
+		env:     defaultEnv(),
+		extra:   make(map[string]bool, len(l.Globals)),
+		globals: make(map[string]*global),
+		diags:   make([]*Diagnostic, 0),
+	}
+
+	for _, g := range l.Globals {
+		c.extra[g] = true
+	}
+
+	if l.Policy != nil {
+		if c.policy, err = policyEnv(l.Policy); err != nil {
+			return nil, err
+		}
+	}
+
+	c.check(chunk)
+
+	sort.SliceStable(c.diags, func(i, j int) bool {
+		return c.diags[i].Line < c.diags[j].Line
+	})
+
+	return c.diags, nil
+}
+
+// LintFile checks source file.
+func (l *Linter) LintFile(path string) ([]*Diagnostic, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return l.Lint(path, f)
+}
+
+func (d *Diagnostic) String() string {
+	return fmt.Sprintf("%s:%d: %s (%s)", d.File, d.Line, d.Message, d.Code)
+}
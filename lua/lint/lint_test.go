@@ -0,0 +1,175 @@
+// (c) 2018, Jeff Chen <jefurry@qq.com>
+//
+// This file is part of Gola
+//
+// Copyright 2018 The Gola Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package lint
+
+import (
+	"github.com/jefurry/gola/lua/reng"
+	"github.com/stretchr/testify/assert"
+	"strings"
+	"testing"
+)
+
+func lint(t *testing.T, l *Linter, source string) []string {
+	diags, err := l.Lint("test.lua", strings.NewReader(source))
+	if !assert.NoError(t, err, "Lint should succeed") {
+		return nil
+	}
+
+	lines := make([]string, 0, len(diags))
+	for _, d := range diags {
+		lines = append(lines, d.String())
+	}
+
+	return lines
+}
+
+func TestLint(t *testing.T) {
+	for _, v := range []struct {
+		source string
+		diags  []string
+	}{
+		{
+			`local json = require("json")
+			print(json.encode({}), string.format("%d", 1))`,
+			[]string{},
+		},
+		{
+			`prnit("x")
+			local t = {}
+			t.x = undefinedValue`,
+			[]string{
+				"test.lua:1: undefined global prnit (undefined-global)",
+				"test.lua:3: undefined global undefinedValue (undefined-global)",
+			},
+		},
+		{
+			`function helper() return counter end
+			counter = 1
+			unused = 2
+			print(helper())`,
+			[]string{
+				"test.lua:3: unused global unused (unused-global)",
+			},
+		},
+		{
+			`local json = require("json")
+			local s = json.encdoe({})
+			print(s, string.fromat, table.insert)`,
+			[]string{
+				"test.lua:2: undefined field encdoe of json (undefined-field)",
+				"test.lua:3: undefined field fromat of string (undefined-field)",
+			},
+		},
+		{
+			`function string.trim(s) return s end
+			print(string.trim(" x "))`,
+			[]string{},
+		},
+		{
+			`local a, _b = 1, 2
+			local function f() end
+			local function g(x) return g(x) end
+			local h = g
+			local c
+			c = 3
+			for i, v in ipairs({}) do end`,
+			[]string{
+				"test.lua:1: unused local a (unused-local)",
+				"test.lua:2: unused local function f (unused-local)",
+				"test.lua:4: unused local h (unused-local)",
+				"test.lua:5: unused local c (unused-local)",
+			},
+		},
+		{
+			`local x = 1
+			local function f(x)
+				for x = 1, 2 do print(x) end
+				return x
+			end
+			print(f(x))`,
+			[]string{
+				"test.lua:2: local x shadows local declared at line 1 (shadowing)",
+				"test.lua:3: local x shadows local declared at line 2 (shadowing)",
+			},
+		},
+		{
+			`local function f(x)
+				if x then
+					return 1
+				else
+					error("no")
+				end
+				print("unreachable")
+				do return end
+				print("unreachable")
+			end
+			while true do
+				if f(1) then break end
+			end
+			print("reachable")
+			while true do end
+			print("unreachable")`,
+			[]string{
+				"test.lua:7: unreachable code (unreachable)",
+				"test.lua:16: unreachable code (unreachable)",
+			},
+		},
+		{
+			`local json = require("json")
+			local path = require("path.filepath")
+			print(json.encode(1, 2), string.sub("x"), string.sub(string.find("x", "y")))
+			print(path.join(), tostring(), tostring(...), select("#"))
+			string.rep("x", 1, 2, 3)`,
+			[]string{
+				"test.lua:3: json.encode expects 1 argument, got 2 (arity)",
+				"test.lua:3: string.sub expects 2 to 3 arguments, got 1 (arity)",
+				"test.lua:4: tostring expects 1 argument, got 0 (arity)",
+				"test.lua:5: string.rep expects 2 arguments, got 4 (arity)",
+			},
+		},
+		{
+			`local x = `,
+			[]string{
+				"test.lua:0: syntax error at EOF (syntax)",
+			},
+		},
+		{
+			"local x = = 1",
+			[]string{
+				"test.lua:1: syntax error near '=' (syntax)",
+			},
+		},
+	} {
+		if !assert.Equal(t, v.diags, lint(t, New(), v.source), "diagnostics mismatching: %s", v.source) {
+			return
+		}
+	}
+}
+
+func TestLintPolicy(t *testing.T) {
+	l := New()
+	l.Globals = append(l.Globals, "facts")
+	l.Policy = reng.DefaultPolicy()
+
+	source := `
+	print(facts.age)
+	local s = string.rep("x", 2) .. string.dump(tostring)
+	os.time()
+	facts.ok = require("json") ~= nil and s ~= ""
+	`
+
+	if !assert.Equal(t, []string{
+		"test.lua:2: print is removed by rule engine policy (stripped)",
+		"test.lua:3: string.dump is removed by rule engine policy (stripped)",
+		"test.lua:4: os is removed by rule engine policy (stripped)",
+		"test.lua:5: require is removed by rule engine policy (stripped)",
+	}, lint(t, l, source), "diagnostics mismatching") {
+		return
+	}
+}
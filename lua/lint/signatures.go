@@ -0,0 +1,241 @@
+// (c) 2018, Jeff Chen <jefurry@qq.com>
+//
+// This file is part of Gola
+//
+// Copyright 2018 The Gola Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package lint
+
+import (
+	"fmt"
+)
+
+type (
+	// arity is the number of arguments of function, max is -1 if variadic.
+	arity struct {
+		min, max int
+	}
+)
+
+// signatures are the arities of known functions, keyed by "module.function"
+// for functions of modules or global tables, and by name for base functions.
+// Note: Methods of objects are not listed, since their types are unknown.
+var signatures = map[string]arity{
+	// base functions
+	"assert":         {1, -1},
+	"collectgarbage": {0, 2},
+	"dofile":         {0, 1},
+	"error":          {0, 2},
+	"getfenv":        {0, 1},
+	"getmetatable":   {1, 1},
+	"ipairs":         {1, 1},
+	"load":           {1, 2},
+	"loadfile":       {0, 1},
+	"loadstring":     {1, 2},
+	"module":         {1, -1},
+	"next":           {1, 2},
+	"pairs":          {1, 1},
+	"pcall":          {1, -1},
+	"print":          {0, -1},
+	"rawequal":       {2, 2},
+	"rawget":         {2, 2},
+	"rawset":         {3, 3},
+	"require":        {1, 1},
+	"select":         {1, -1},
+	"setfenv":        {2, 2},
+	"setmetatable":   {2, 2},
+	"tonumber":       {1, 2},
+	"tostring":       {1, 1},
+	"type":           {1, 1},
+	"unpack":         {1, 3},
+	"xpcall":         {2, 2},
+
+	// string
+	"string.byte":    {1, 3},
+	"string.char":    {0, -1},
+	"string.dump":    {1, 1},
+	"string.find":    {2, 4},
+	"string.format":  {1, -1},
+	"string.gmatch":  {2, 2},
+	"string.gsub":    {3, 4},
+	"string.len":     {1, 1},
+	"string.lower":   {1, 1},
+	"string.match":   {2, 3},
+	"string.rep":     {2, 2},
+	"string.reverse": {1, 1},
+	"string.sub":     {2, 3},
+	"string.upper":   {1, 1},
+
+	// table
+	"table.concat": {1, 4},
+	"table.insert": {2, 3},
+	"table.maxn":   {1, 1},
+	"table.remove": {1, 2},
+	"table.sort":   {1, 2},
+
+	// math
+	"math.abs":        {1, 1},
+	"math.ceil":       {1, 1},
+	"math.cos":        {1, 1},
+	"math.exp":        {1, 1},
+	"math.floor":      {1, 1},
+	"math.fmod":       {2, 2},
+	"math.log":        {1, 2},
+	"math.max":        {1, -1},
+	"math.min":        {1, -1},
+	"math.modf":       {1, 1},
+	"math.pow":        {2, 2},
+	"math.random":     {0, 2},
+	"math.randomseed": {1, 1},
+	"math.sin":        {1, 1},
+	"math.sqrt":       {1, 1},
+	"math.tan":        {1, 1},
+
+	// coroutine
+	"coroutine.create":  {1, 1},
+	"coroutine.resume":  {1, -1},
+	"coroutine.running": {0, 0},
+	"coroutine.status":  {1, 1},
+	"coroutine.wrap":    {1, 1},
+	"coroutine.yield":   {0, -1},
+
+	// os, with the functions of gola
+	"os.chdir":         {1, 1},
+	"os.chmod":         {2, 2},
+	"os.chown":         {3, 3},
+	"os.clock":         {0, 0},
+	"os.create":        {1, 1},
+	"os.date":          {0, 2},
+	"os.difftime":      {2, 2},
+	"os.execute":       {0, 1},
+	"os.exit":          {0, 1},
+	"os.expand":        {2, 2},
+	"os.expandEnv":     {1, 1},
+	"os.getenv":        {1, 1},
+	"os.hostname":      {0, 0},
+	"os.lookupEnv":     {1, 1},
+	"os.lstat":         {1, 1},
+	"os.mkdir":         {2, 2},
+	"os.mkdirAll":      {2, 2},
+	"os.open":          {1, 1},
+	"os.openFile":      {3, 3},
+	"os.readlink":      {1, 1},
+	"os.remove":        {1, 1},
+	"os.removeAll":     {1, 1},
+	"os.rename":        {2, 2},
+	"os.setenv":        {2, 2},
+	"os.stat":          {1, 1},
+	"os.symlink":       {2, 2},
+	"os.time":          {0, 1},
+	"os.tmpname":       {0, 0},
+	"os.truncate":      {2, 2},
+	"os.unsetenv":      {1, 1},
+	"os.exec.lookPath": {1, 1},
+
+	// package, with the functions of gola
+	"package.addDefaultPath":    {1, 1},
+	"package.removeDefaultPath": {1, 1},
+	"package.resetDefaultPath":  {0, 0},
+	"package.setDefaultPath":    {1, 1},
+
+	// modules of gola
+	"charset.decode":          {2, 2},
+	"charset.encode":          {2, 2},
+	"encoding.base32.decode":  {1, 1},
+	"encoding.base32.encode":  {1, 1},
+	"encoding.base64.decode":  {1, 1},
+	"encoding.base64.encode":  {1, 1},
+	"encoding.hex.decode":     {1, 1},
+	"encoding.hex.decodedLen": {1, 1},
+	"encoding.hex.dump":       {1, 1},
+	"encoding.hex.encode":     {1, 1},
+	"encoding.hex.encodedLen": {1, 1},
+	"event.newEmitter":        {0, 1},
+	"event.newEvent":          {2, 2},
+	"json.decode":             {1, 1},
+	"json.encode":             {1, 1},
+	"path.base":               {1, 1},
+	"path.clean":              {1, 1},
+	"path.dir":                {1, 1},
+	"path.ext":                {1, 1},
+	"path.isAbs":              {1, 1},
+	"path.join":               {0, -1},
+	"path.match":              {2, 2},
+	"path.split":              {1, 1},
+	"path.filepath.abs":       {1, 1},
+	"path.filepath.base":      {1, 1},
+	"path.filepath.clean":     {1, 1},
+	"path.filepath.dir":       {1, 1},
+	"path.filepath.ext":       {1, 1},
+	"path.filepath.glob":      {1, 1},
+	"path.filepath.isAbs":     {1, 1},
+	"path.filepath.join":      {0, -1},
+	"path.filepath.match":     {2, 2},
+	"path.filepath.rel":       {2, 2},
+	"path.filepath.split":     {1, 1},
+	"sys.getsid":              {1, 1},
+	"sys.kill":                {2, 2},
+	"testing.afterAll":        {1, 1},
+	"testing.afterEach":       {1, 1},
+	"testing.beforeAll":       {1, 1},
+	"testing.beforeEach":      {1, 1},
+	"testing.describe":        {2, 2},
+	"testing.it":              {2, 2},
+	"testing.mock":            {2, 3},
+	"testing.skip":            {1, 2},
+	"testing.spy":             {0, 1},
+	"time.date":               {0, 1},
+	"time.fixedZone":          {2, 2},
+	"time.isLeap":             {1, 1},
+	"time.loadLocation":       {1, 1},
+	"time.now":                {0, 0},
+	"time.parse":              {2, 2},
+	"time.parseDuration":      {1, 1},
+	"time.parseInLocation":    {3, 3},
+	"time.since":              {1, 1},
+	"time.sleep":              {1, 1},
+	"time.unix":               {2, 2},
+	"time.until":              {1, 1},
+	"url.build":               {1, 1},
+	"url.build_query_string":  {1, 1},
+	"url.parse":               {1, 1},
+	"url.resolve":             {2, 2},
+	"yaml.dump":               {1, 1},
+	"yaml.parse":              {1, 1},
+}
+
+// check returns a message if n arguments do not match arity, n is the
+// minimum if the last argument is multiple values.
+func (a arity) check(name string, n int, multiple bool) string {
+	switch {
+	case a.max >= 0 && n > a.max && multiple:
+		return fmt.Sprintf("%s expects %s, got at least %d", name, a, n)
+	case a.max >= 0 && n > a.max, !multiple && n < a.min:
+		return fmt.Sprintf("%s expects %s, got %d", name, a, n)
+	}
+
+	return ""
+}
+
+func (a arity) String() string {
+	switch {
+	case a.max < 0:
+		return fmt.Sprintf("at least %s", plural(a.min))
+	case a.min == a.max:
+		return plural(a.min)
+	case a.min == 0:
+		return fmt.Sprintf("at most %s", plural(a.max))
+	}
+
+	return fmt.Sprintf("%d to %d arguments", a.min, a.max)
+}
+
+func plural(n int) string {
+	if n == 1 {
+		return "1 argument"
+	}
+
+	return fmt.Sprintf("%d arguments", n)
+}
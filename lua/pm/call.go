@@ -0,0 +1,269 @@
+// (c) 2018, Jeff Chen <jefurry@qq.com>
+//
+// This file is part of Gola
+//
+// Copyright 2018 The Gola Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package pm
+
+import (
+	"context"
+	"github.com/pkg/errors"
+	"github.com/yuin/gluamapper"
+	"github.com/yuin/gopher-lua"
+	"reflect"
+	"strings"
+	"unicode"
+)
+
+const (
+	// struct tag used for lua table keys, same as gluamapper.
+	luaTagName = "gluamapper"
+)
+
+var (
+	ErrLSFuncNotFound    = errors.New("lua function not found")
+	ErrLSFuncResultTable = errors.New("lua function result is not a table")
+)
+
+// Call calls the lua function named by name with args, and returns the results.
+// The name is a global function like "fn", or a field path like "module.fn",
+// module is looked up in globals and then in loaded modules.
+// Go maps, slices and structs are converted to lua tables, and lua tables are
+// converted back to map[interface{}]interface{} or []interface{}.
+func (lpm *LPM) Call(ctx context.Context, name string, args ...interface{}) ([]interface{}, error) {
+	var rets []interface{}
+
+	_, err := lpm.execute(ctx, name, func(ls *lState) (lua.LValue, error) {
+		lvs, err := lpm.call(ls, name, args...)
+		if err != nil {
+			return lua.LNil, err
+		}
+
+		opt := gluamapper.Option{NameFunc: gluamapper.Id}
+		rets = make([]interface{}, 0, len(lvs))
+		for _, lv := range lvs {
+			rets = append(rets, gluamapper.ToGoValue(lv, opt))
+		}
+
+		return lua.LNil, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return rets, nil
+}
+
+// CallDecode calls the lua function like Call, and decodes the first result into out
+// with gluamapper, out must be a pointer to struct or map.
+func (lpm *LPM) CallDecode(ctx context.Context, name string, out interface{}, args ...interface{}) error {
+	_, err := lpm.execute(ctx, name, func(ls *lState) (lua.LValue, error) {
+		lvs, err := lpm.call(ls, name, args...)
+		if err != nil {
+			return lua.LNil, err
+		}
+
+		if len(lvs) == 0 {
+			return lua.LNil, ErrLSFuncResultTable
+		}
+
+		tbl, ok := lvs[0].(*lua.LTable)
+		if !ok {
+			return lua.LNil, ErrLSFuncResultTable
+		}
+
+		return lua.LNil, gluamapper.Map(tbl, out)
+	})
+
+	return err
+}
+
+// call calls the lua function named by name, and returns all results.
+func (lpm *LPM) call(ls *lState, name string, args ...interface{}) ([]lua.LValue, error) {
+	L := ls.L
+
+	fn, ok := lookup(L, name).(*lua.LFunction)
+	if !ok {
+		return nil, ErrLSFuncNotFound
+	}
+
+	// runs the function in the isolated environment, the environments set by
+	// setfenv are kept without isolation.
+	if lpm.config.isolation&IsolateEnv != 0 && !fn.IsG && fn.Env != L.Env {
+		fn = &lua.LFunction{
+			Env:      L.Env,
+			Proto:    fn.Proto,
+			Upvalues: fn.Upvalues,
+		}
+	}
+
+	lvs := make([]lua.LValue, 0, len(args))
+	for _, arg := range args {
+		lvs = append(lvs, ToLValue(L, arg))
+	}
+
+	top := L.GetTop()
+	if err := L.CallByParam(lua.P{
+		Fn:      fn,
+		NRet:    lua.MultRet,
+		Protect: true,
+	}, lvs...); err != nil {
+		lpm.Close(ls)
+
+		return nil, err
+	}
+
+	rets := make([]lua.LValue, 0, L.GetTop()-top)
+	for i := top + 1; i <= L.GetTop(); i++ {
+		rets = append(rets, L.Get(i))
+	}
+	L.SetTop(top)
+
+	return rets, nil
+}
+
+// lookup finds value by name like "module.fn".
+func lookup(L *lua.LState, name string) lua.LValue {
+	names := strings.Split(name, ".")
+
+	lv := L.GetGlobal(names[0])
+	if lv == lua.LNil {
+		loaded := L.GetField(L.Get(lua.RegistryIndex), "_LOADED")
+		if tbl, ok := loaded.(*lua.LTable); ok {
+			lv = tbl.RawGetString(names[0])
+		}
+	}
+
+	for _, n := range names[1:] {
+		tbl, ok := lv.(*lua.LTable)
+		if !ok {
+			return lua.LNil
+		}
+
+		lv = L.GetField(tbl, n)
+	}
+
+	return lv
+}
+
+// ToLValue converts Go value to lua value, see Call.
+func ToLValue(L *lua.LState, v interface{}) lua.LValue {
+	switch val := v.(type) {
+	case nil:
+		return lua.LNil
+	case lua.LValue:
+		return val
+	case []byte:
+		return lua.LString(val)
+	case lua.LGFunction:
+		return L.NewFunction(val)
+	case func(*lua.LState) int:
+		return L.NewFunction(val)
+	}
+
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Bool:
+		return lua.LBool(rv.Bool())
+	case reflect.String:
+		return lua.LString(rv.String())
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return lua.LNumber(rv.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return lua.LNumber(rv.Uint())
+	case reflect.Float32, reflect.Float64:
+		return lua.LNumber(rv.Float())
+	case reflect.Ptr, reflect.Interface:
+		if rv.IsNil() {
+			return lua.LNil
+		}
+
+		return ToLValue(L, rv.Elem().Interface())
+	case reflect.Slice, reflect.Array:
+		if rv.Kind() == reflect.Slice && rv.IsNil() {
+			return lua.LNil
+		}
+
+		tbl := L.CreateTable(rv.Len(), 0)
+		for i := 0; i < rv.Len(); i++ {
+			tbl.RawSetInt(i+1, ToLValue(L, rv.Index(i).Interface()))
+		}
+
+		return tbl
+	case reflect.Map:
+		if rv.IsNil() {
+			return lua.LNil
+		}
+
+		tbl := L.CreateTable(0, rv.Len())
+		for _, key := range rv.MapKeys() {
+			tbl.RawSet(ToLValue(L, key.Interface()), ToLValue(L, rv.MapIndex(key).Interface()))
+		}
+
+		return tbl
+	case reflect.Struct:
+		rt := rv.Type()
+		tbl := L.CreateTable(0, rt.NumField())
+		for i := 0; i < rt.NumField(); i++ {
+			field := rt.Field(i)
+			if field.PkgPath != "" {
+				continue
+			}
+
+			key := fieldName(field)
+			if key == "" {
+				continue
+			}
+
+			tbl.RawSetString(key, ToLValue(L, rv.Field(i).Interface()))
+		}
+
+		return tbl
+	}
+
+	ud := L.NewUserData()
+	ud.Value = v
+
+	return ud
+}
+
+// fieldName returns lua table key of struct field, the key is the tag name
+// or snake case of field name which gluamapper maps back to the field.
+func fieldName(field reflect.StructField) string {
+	tag := field.Tag.Get(luaTagName)
+	if tag == "-" {
+		return ""
+	}
+
+	if i := strings.Index(tag, ","); i > -1 {
+		tag = tag[:i]
+	}
+
+	if tag != "" {
+		return tag
+	}
+
+	return toSnakeCase(field.Name)
+}
+
+// toSnakeCase converts strings from upper camel case to snake case.
+func toSnakeCase(s string) string {
+	rs := []rune(s)
+	buf := make([]rune, 0, len(rs)+4)
+	for i, r := range rs {
+		if unicode.IsUpper(r) {
+			if i > 0 && unicode.IsLower(rs[i-1]) {
+				buf = append(buf, '_')
+			}
+
+			r = unicode.ToLower(r)
+		}
+
+		buf = append(buf, r)
+	}
+
+	return string(buf)
+}
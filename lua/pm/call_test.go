@@ -0,0 +1,209 @@
+// (c) 2018, Jeff Chen <jefurry@qq.com>
+//
+// This file is part of Gola
+//
+// Copyright 2018 The Gola Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package pm
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"github.com/yuin/gopher-lua"
+	"testing"
+)
+
+type (
+	callItem struct {
+		Name     string
+		Price    float64
+		Quantity int
+	}
+
+	callOrder struct {
+		OrderID string `gluamapper:"id"`
+		Items   []callItem
+		Total   float64
+		Paid    bool
+	}
+)
+
+func newCallLPM(ctx context.Context) (*LPM, error) {
+	config, err := NewConfig(2, 1, 0, 120, "1h")
+	if err != nil {
+		return nil, err
+	}
+
+	return New(ctx, config, func(L *lua.LState) error {
+		L.PreloadModule("order", func(L *lua.LState) int {
+			fn, err := L.LoadString(`
+			local order = {}
+
+			function order.total(o)
+				local total = 0
+				for _, item in ipairs(o.items) do
+					total = total + item.price * item.quantity
+				end
+				o.total = total
+				return o
+			end
+
+			return order
+			`)
+			if err != nil {
+				L.RaiseError("%s", err.Error())
+			}
+
+			L.Push(fn)
+			L.Call(0, 1)
+
+			return 1
+		})
+
+		return L.DoString(`
+		require("order")
+
+		function add(a, b)
+			return a + b, a - b
+		end
+
+		function keys(t)
+			local ks = {}
+			for k, _ in pairs(t) do
+				table.insert(ks, k)
+			end
+			table.sort(ks)
+			return ks
+		end
+
+		function scoped()
+			return value
+		end
+		setfenv(scoped, {value = "scoped"})
+		`)
+	})
+}
+
+func TestCall(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.TODO())
+	defer cancel()
+
+	lpm, err := newCallLPM(ctx)
+	if !assert.NoError(t, err, "New should succeed") {
+		return
+	}
+
+	defer lpm.Shutdown(context.TODO())
+
+	rets, err := lpm.Call(ctx, "add", 3, 2)
+	if !assert.NoError(t, err, "Call should succeed") {
+		return
+	}
+
+	if !assert.Equal(t, []interface{}{float64(5), float64(1)}, rets, "value mismatching") {
+		return
+	}
+
+	rets, err = lpm.Call(ctx, "keys", map[string]int{"b": 2, "a": 1, "c": 3})
+	if !assert.NoError(t, err, "Call should succeed") {
+		return
+	}
+
+	if !assert.Equal(t, []interface{}{[]interface{}{"a", "b", "c"}}, rets, "value mismatching") {
+		return
+	}
+
+	// the environment set by setfenv is kept without isolation.
+	rets, err = lpm.Call(ctx, "scoped")
+	if !assert.NoError(t, err, "Call should succeed") {
+		return
+	}
+
+	if !assert.Equal(t, []interface{}{"scoped"}, rets, "value mismatching") {
+		return
+	}
+
+	rets, err = lpm.Call(ctx, "string.upper", "gola")
+	if !assert.NoError(t, err, "Call should succeed") {
+		return
+	}
+
+	if !assert.Equal(t, []interface{}{"GOLA"}, rets, "value mismatching") {
+		return
+	}
+
+	_, err = lpm.Call(ctx, "order.missing")
+	if !assert.Equal(t, ErrLSFuncNotFound, err, "Call should failed") {
+		return
+	}
+
+	_, err = lpm.Call(ctx, "add", "a", nil)
+	if !assert.Error(t, err, "Call should failed") {
+		return
+	}
+
+	if !assert.Equal(t, 0, lpm.ServingNum(), "servingNum mismatching") {
+		return
+	}
+}
+
+func TestCallDecode(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.TODO())
+	defer cancel()
+
+	lpm, err := newCallLPM(ctx)
+	if !assert.NoError(t, err, "New should succeed") {
+		return
+	}
+
+	defer lpm.Shutdown(context.TODO())
+
+	in := &callOrder{
+		OrderID: "A001",
+		Items: []callItem{
+			{Name: "apple", Price: 1.5, Quantity: 4},
+			{Name: "pear", Price: 2, Quantity: 3},
+		},
+	}
+
+	var out callOrder
+	err = lpm.CallDecode(ctx, "order.total", &out, in)
+	if !assert.NoError(t, err, "CallDecode should succeed") {
+		return
+	}
+
+	if !assert.Equal(t, "A001", out.OrderID, "id mismatching") {
+		return
+	}
+
+	if !assert.Equal(t, float64(12), out.Total, "total mismatching") {
+		return
+	}
+
+	if !assert.Equal(t, in.Items, out.Items, "items mismatching") {
+		return
+	}
+
+	err = lpm.CallDecode(ctx, "add", &out, 1, 2)
+	if !assert.Equal(t, ErrLSFuncResultTable, err, "CallDecode should failed") {
+		return
+	}
+}
+
+func TestToSnakeCase(t *testing.T) {
+	for _, v := range []struct {
+		name  string
+		snake string
+	}{
+		{"Name", "name"},
+		{"OrderID", "order_id"},
+		{"ID", "id"},
+		{"TotalPrice", "total_price"},
+	} {
+		if !assert.Equal(t, v.snake, toSnakeCase(v.name), "toSnakeCase mismatching") {
+			return
+		}
+	}
+}
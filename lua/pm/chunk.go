@@ -0,0 +1,249 @@
+// (c) 2018, Jeff Chen <jefurry@qq.com>
+//
+// This file is part of Gola
+//
+// Copyright 2018 The Gola Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package pm
+
+import (
+	"bytes"
+	"container/list"
+	"crypto/sha1"
+	"encoding/hex"
+	"github.com/yuin/gopher-lua"
+	"github.com/yuin/gopher-lua/parse"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	chunkStringName = "<string>"
+	// the max number of string chunks in cache, the least recently used
+	// ones are evicted.
+	chunkStringLimit = 1024
+)
+
+type (
+	ChunkCacheStats struct {
+		// The number of compiled chunks in cache.
+		Size int
+		// The number of chunks served from cache.
+		Hits int
+		// The number of chunks compiled.
+		Misses int
+	}
+
+	chunk struct {
+		proto   *lua.FunctionProto
+		modTime time.Time
+		size    int64
+		// element of string chunk in lru list, nil for file chunks.
+		elem *list.Element
+	}
+
+	// chunkCache caches compiled chunks, which are shared by all lua states of pool.
+	// File chunks are keyed by path and checked by modification time and size,
+	// string chunks are keyed by hash of source and limited to limit by lru.
+	// Note: File chunks are not limited, there is a chunk for each file loaded.
+	chunkCache struct {
+		lock   sync.Mutex
+		chunks map[string]*chunk
+		// keys of string chunks, the most recently used first.
+		lru    *list.List
+		limit  int
+		hits   int
+		misses int
+	}
+)
+
+func newChunkCache() *chunkCache {
+	return &chunkCache{
+		chunks: make(map[string]*chunk),
+		lru:    list.New(),
+		limit:  chunkStringLimit,
+	}
+}
+
+// file returns compiled chunk of file path.
+func (cc *chunkCache) file(path string) (*lua.FunctionProto, error) {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return nil, &lua.ApiError{Type: lua.ApiErrorFile, Object: lua.LString(err.Error()), Cause: err}
+	}
+
+	// the hit is counted after the chunk is validated, a stale chunk is
+	// counted as a miss by set.
+	if c := cc.get(path); c != nil && c.modTime.Equal(fi.ModTime()) && c.size == fi.Size() {
+		cc.hit()
+
+		return c.proto, nil
+	}
+
+	source, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, &lua.ApiError{Type: lua.ApiErrorFile, Object: lua.LString(err.Error()), Cause: err}
+	}
+
+	// skip first line of unix exec file, but keeps line number.
+	if len(source) > 0 && source[0] == '#' {
+		if i := bytes.IndexByte(source, '\n'); i > -1 {
+			source = source[i:]
+		} else {
+			source = nil
+		}
+	}
+
+	proto, err := compile(bytes.NewReader(source), path)
+	if err != nil {
+		return nil, err
+	}
+
+	cc.set(path, &chunk{proto: proto, modTime: fi.ModTime(), size: fi.Size()}, false)
+
+	return proto, nil
+}
+
+// string returns compiled chunk of source.
+func (cc *chunkCache) string(source string) (*lua.FunctionProto, error) {
+	key := stringKey(source)
+	if c := cc.get(key); c != nil {
+		cc.hit()
+
+		return c.proto, nil
+	}
+
+	proto, err := compile(strings.NewReader(source), chunkStringName)
+	if err != nil {
+		return nil, err
+	}
+
+	cc.set(key, &chunk{proto: proto}, true)
+
+	return proto, nil
+}
+
+func (cc *chunkCache) get(key string) *chunk {
+	cc.lock.Lock()
+	defer cc.lock.Unlock()
+
+	c, ok := cc.chunks[key]
+	if ok && c.elem != nil {
+		cc.lru.MoveToFront(c.elem)
+	}
+
+	return c
+}
+
+func (cc *chunkCache) hit() {
+	cc.lock.Lock()
+	defer cc.lock.Unlock()
+
+	cc.hits += 1
+}
+
+// set caches chunk, the chunks of limited are evicted by lru.
+func (cc *chunkCache) set(key string, c *chunk, limited bool) {
+	cc.lock.Lock()
+	defer cc.lock.Unlock()
+
+	cc.misses += 1
+	cc.remove(key)
+	cc.chunks[key] = c
+
+	if !limited {
+		return
+	}
+
+	c.elem = cc.lru.PushFront(key)
+	for cc.lru.Len() > cc.limit {
+		cc.remove(cc.lru.Back().Value.(string))
+	}
+}
+
+// remove removes chunk of key, the lock must be held.
+func (cc *chunkCache) remove(key string) {
+	if c, ok := cc.chunks[key]; ok {
+		if c.elem != nil {
+			cc.lru.Remove(c.elem)
+		}
+
+		delete(cc.chunks, key)
+	}
+}
+
+// invalidate removes chunks of paths, or all chunks if no path is given.
+func (cc *chunkCache) invalidate(paths ...string) {
+	cc.lock.Lock()
+	defer cc.lock.Unlock()
+
+	if len(paths) == 0 {
+		cc.chunks = make(map[string]*chunk)
+		cc.lru.Init()
+
+		return
+	}
+
+	for _, path := range paths {
+		cc.remove(path)
+	}
+}
+
+// invalidateStrings removes chunks of sources.
+func (cc *chunkCache) invalidateStrings(sources ...string) {
+	cc.lock.Lock()
+	defer cc.lock.Unlock()
+
+	for _, source := range sources {
+		cc.remove(stringKey(source))
+	}
+}
+
+func (cc *chunkCache) stats() ChunkCacheStats {
+	cc.lock.Lock()
+	defer cc.lock.Unlock()
+
+	return ChunkCacheStats{
+		Size:   len(cc.chunks),
+		Hits:   cc.hits,
+		Misses: cc.misses,
+	}
+}
+
+// compile compiles lua source into function proto, the errors are same as LState.Load.
+func compile(reader io.Reader, name string) (*lua.FunctionProto, error) {
+	stmts, err := parse.Parse(reader, name)
+	if err != nil {
+		return nil, &lua.ApiError{Type: lua.ApiErrorSyntax, Object: lua.LString(err.Error()), Cause: err}
+	}
+
+	proto, err := lua.Compile(stmts, name)
+	if err != nil {
+		return nil, &lua.ApiError{Type: lua.ApiErrorSyntax, Object: lua.LString(err.Error()), Cause: err}
+	}
+
+	return proto, nil
+}
+
+// newFunction instantiates compiled chunk in lua state.
+func newFunction(L *lua.LState, proto *lua.FunctionProto) *lua.LFunction {
+	return &lua.LFunction{
+		IsG:      false,
+		Env:      L.Env,
+		Proto:    proto,
+		Upvalues: make([]*lua.Upvalue, 0),
+	}
+}
+
+// stringKey returns cache key of source.
+func stringKey(source string) string {
+	sum := sha1.Sum([]byte(source))
+
+	return chunkStringName + hex.EncodeToString(sum[:])
+}
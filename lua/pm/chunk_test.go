@@ -0,0 +1,197 @@
+// (c) 2018, Jeff Chen <jefurry@qq.com>
+//
+// This file is part of Gola
+//
+// Copyright 2018 The Gola Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package pm
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"github.com/yuin/gopher-lua"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestChunkCache(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gola-pm")
+	if !assert.NoError(t, err, "TempDir should succeed") {
+		return
+	}
+
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "chunk.lua")
+	if !assert.NoError(t, ioutil.WriteFile(path, []byte("#!/usr/bin/env gola\nreturn 1"), 0644), "WriteFile should succeed") {
+		return
+	}
+
+	config, err := NewConfig(3, 3, 0, 120, "1h")
+	if !assert.NoError(t, err, "NewConfig should succeed") {
+		return
+	}
+
+	config.SetChunkCache(true)
+
+	ctx, cancel := context.WithCancel(context.TODO())
+	defer cancel()
+
+	lpm, err := New(ctx, config)
+	if !assert.NoError(t, err, "New should succeed") {
+		return
+	}
+
+	defer lpm.Shutdown(context.TODO())
+
+	ret := func(L *lua.LState) (lua.LValue, error) {
+		return L.Get(-1), nil
+	}
+
+	for i := 0; i < 5; i++ {
+		lv, err := lpm.DoFile(ctx, path, ret)
+		if !assert.NoError(t, err, "DoFile should succeed") {
+			return
+		}
+
+		if !assert.Equal(t, lua.LNumber(1), lv, "value mismatching") {
+			return
+		}
+
+		lv, err = lpm.DoString(ctx, `return "gola"`, ret)
+		if !assert.NoError(t, err, "DoString should succeed") {
+			return
+		}
+
+		if !assert.Equal(t, lua.LString("gola"), lv, "value mismatching") {
+			return
+		}
+	}
+
+	if !assert.Equal(t, ChunkCacheStats{Size: 2, Hits: 8, Misses: 2}, lpm.ChunkCacheStats(), "stats mismatching") {
+		return
+	}
+
+	// recompiles modified file.
+	mtime := time.Now().Add(time.Minute)
+	if !assert.NoError(t, ioutil.WriteFile(path, []byte("return 2"), 0644), "WriteFile should succeed") {
+		return
+	}
+
+	if !assert.NoError(t, os.Chtimes(path, mtime, mtime), "Chtimes should succeed") {
+		return
+	}
+
+	lv, err := lpm.DoFile(ctx, path, ret)
+	if !assert.NoError(t, err, "DoFile should succeed") {
+		return
+	}
+
+	if !assert.Equal(t, lua.LNumber(2), lv, "value mismatching") {
+		return
+	}
+
+	// the stale chunk is counted as a miss only.
+	if !assert.Equal(t, ChunkCacheStats{Size: 2, Hits: 8, Misses: 3}, lpm.ChunkCacheStats(), "stats mismatching") {
+		return
+	}
+
+	lpm.InvalidateChunks(path)
+	if !assert.Equal(t, 1, lpm.ChunkCacheStats().Size, "size mismatching") {
+		return
+	}
+
+	lpm.InvalidateStrings(`return "gola"`)
+	if !assert.Equal(t, 0, lpm.ChunkCacheStats().Size, "size mismatching") {
+		return
+	}
+
+	lpm.InvalidateChunks()
+	if !assert.Equal(t, 0, lpm.ChunkCacheStats().Size, "size mismatching") {
+		return
+	}
+
+	_, err = lpm.DoString(ctx, `return (`)
+	if !assert.Error(t, err, "DoString should failed") {
+		return
+	}
+
+	if !assert.Equal(t, lua.ApiErrorSyntax, err.(*lua.ApiError).Type, "error type mismatching") {
+		return
+	}
+}
+
+func TestChunkCacheLimit(t *testing.T) {
+	cc := newChunkCache()
+	cc.limit = 2
+
+	for _, source := range []string{"return 1", "return 2", "return 1", "return 3"} {
+		if _, err := cc.string(source); !assert.NoError(t, err, "string should succeed") {
+			return
+		}
+	}
+
+	// "return 2" is the least recently used.
+	if !assert.Equal(t, ChunkCacheStats{Size: 2, Hits: 1, Misses: 3}, cc.stats(), "stats mismatching") {
+		return
+	}
+
+	if !assert.Nil(t, cc.get(stringKey("return 2")), "chunk should be evicted") {
+		return
+	}
+
+	if !assert.NotNil(t, cc.get(stringKey("return 1")), "chunk should be cached") {
+		return
+	}
+
+	cc.invalidateStrings("return 1")
+	if !assert.Equal(t, 1, cc.lru.Len(), "length of lru mismatching") {
+		return
+	}
+
+	cc.invalidate()
+	if !assert.Equal(t, 0, cc.lru.Len(), "length of lru mismatching") {
+		return
+	}
+}
+
+func TestLoadProto(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.TODO())
+	defer cancel()
+
+	lpm, err := Default(ctx)
+	if !assert.NoError(t, err, "Default should succeed") {
+		return
+	}
+
+	defer lpm.Shutdown(context.TODO())
+
+	proto, err := compile(strings.NewReader("return 1 + 2"), "<proto>")
+	if !assert.NoError(t, err, "compile should succeed") {
+		return
+	}
+
+	for i := 0; i < 3; i++ {
+		lv, err := lpm.LoadProto(ctx, proto, func(L *lua.LState, fn *lua.LFunction) (lua.LValue, error) {
+			L.Push(fn)
+			if err := L.PCall(0, 1, nil); err != nil {
+				return lua.LNil, err
+			}
+
+			return L.Get(-1), nil
+		})
+		if !assert.NoError(t, err, "LoadProto should succeed") {
+			return
+		}
+
+		if !assert.Equal(t, lua.LNumber(3), lv, "value mismatching") {
+			return
+		}
+	}
+}
@@ -11,6 +11,7 @@ package pm
 import (
 	"fmt"
 	"github.com/yuin/gopher-lua"
+	"time"
 )
 
 type (
@@ -37,12 +38,58 @@ type (
 		maxRequest int
 
 		// The number of seconds after which on idle lua state will be killed.
+		// Idle lua states are checked by a background reaper of the pool.
 		// Available Units: s(econds), m(inutes), h(ours), or d(ays)
-		// Note: The priority is lower than `maxRequest`,
-		//       A value of 0(d, h, m, s) indicates no limit.
+		// Note: A value of 0(d, h, m, s) indicates no limit.
 		// Default Value: 1h.
 		idleTimeout string
 
+		// The minimum number of idle lua state kept in the pool, the reaper
+		// creates lua states in advance if there are fewer idle ones.
+		// Note: The number must be smaller than or equal to `maxNum`.
+		// Default Value: 0.
+		minIdle int
+
+		// The maximum number of idle lua state kept in the pool, the extra ones
+		// will be killed when they are put back.
+		// Note: A value of 0 indicates no limit.
+		//       The number must be greater than or equal to `minIdle`.
+		// Default Value: 0.
+		maxIdle int
+
+		// The maximum number of requests waiting for an available lua state
+		// when the number of lua state reaches `maxNum`, the waiting requests
+		// are served in order until their context is done.
+		// Note: A value of 0 indicates not waiting, `ErrLSPFulled` is returned immediately.
+		// Default Value: 0.
+		maxWaitNum int
+
+		// Whether to compile scripts of `DoFile`, `DoString`, `LoadFile` and `LoadString`
+		// only once, the compiled chunks are shared by all lua states of pool.
+		// File chunks are recompiled when modification time or size of file changed,
+		// and at most 1024 string chunks are kept, the least recently used evicted.
+		// Default Value: false.
+		chunkCache bool
+
+		// How requests served by the same lua state are isolated from each other.
+		// Default Value: IsolateNone.
+		isolation Isolation
+
+		// The maximum number of VM instructions a single request may execute.
+		// Note: Instructions executed by coroutines are not counted.
+		// Note: A value of 0 indicates no limit.
+		// Default Value: 0.
+		maxInstructions int
+
+		// The maximum bytes of strings and tables a single request may hold,
+		// it is approximately counted by the strings returned from string and table
+		// library functions, and the strings and tables reachable from the environment
+		// and the call stack of request, which are walked periodically.
+		// Note: Values held only by userdata, metatables or coroutines are not counted.
+		// Note: A value of 0 indicates no limit.
+		// Default Value: 0.
+		maxMemory int
+
 		// The timeout (in seconds) for serving a single request after which the worker process will be terminated.
 		// Note: A value of 0 indicates no limit.
 		// Note: A value of negative indicates `DefaultRequestTerminateTimeout`.
@@ -82,3 +129,95 @@ func (c *Config) Options() lua.Options {
 func (c *Config) SetOptions(options lua.Options) {
 	c.options = options
 }
+
+func (c *Config) MinIdle() int {
+	return c.minIdle
+}
+
+func (c *Config) SetMinIdle(minIdle int) {
+	c.minIdle = getMinIdle(c.maxNum, minIdle)
+	c.maxIdle = getMaxIdle(c.minIdle, c.maxIdle)
+}
+
+func (c *Config) MaxIdle() int {
+	return c.maxIdle
+}
+
+func (c *Config) SetMaxIdle(maxIdle int) {
+	c.maxIdle = getMaxIdle(c.minIdle, maxIdle)
+}
+
+func (c *Config) MaxWaitNum() int {
+	return c.maxWaitNum
+}
+
+func (c *Config) SetMaxWaitNum(maxWaitNum int) {
+	c.maxWaitNum = getMaxWaitNum(maxWaitNum)
+}
+
+func (c *Config) ChunkCache() bool {
+	return c.chunkCache
+}
+
+func (c *Config) SetChunkCache(chunkCache bool) {
+	c.chunkCache = chunkCache
+}
+
+func (c *Config) Isolation() Isolation {
+	return c.isolation
+}
+
+func (c *Config) SetIsolation(isolation Isolation) {
+	c.isolation = isolation
+}
+
+func (c *Config) MaxInstructions() int {
+	return c.maxInstructions
+}
+
+func (c *Config) SetMaxInstructions(maxInstructions int) {
+	c.maxInstructions = getMaxInstructions(maxInstructions)
+}
+
+func (c *Config) MaxMemory() int {
+	return c.maxMemory
+}
+
+func (c *Config) SetMaxMemory(maxMemory int) {
+	c.maxMemory = getMaxMemory(maxMemory)
+}
+
+// CallStackSize returns the maximum call stack size of lua state in `options`.
+// Note: A request exceeds the call stack quota once less than 2 frames are free,
+// the overflows of frames pushed by nested go functions like `pcall(pcall, f)`
+// are raised as lua errors.
+func (c *Config) CallStackSize() int {
+	return getCallStackSize(c.options)
+}
+
+func (c *Config) SetCallStackSize(size int) {
+	c.options.CallStackSize = size
+}
+
+// RegistrySize returns the maximum registry (data stack) size of lua state in `options`.
+// Note: A request exceeds the registry quota once less than 256 registers are free,
+// which are reserved for the instruction being executed, the registers used by
+// request are approximately counted by the prototypes of functions in the call stack.
+func (c *Config) RegistrySize() int {
+	return getRegistrySize(c.options)
+}
+
+func (c *Config) SetRegistrySize(size int) {
+	c.options.RegistrySize = size
+}
+
+// quotas reports whether any quota of requests is configured, the call stack
+// and registry quotas are configured by `SetCallStackSize` and `SetRegistrySize`.
+func (c *Config) quotas() bool {
+	return c.maxInstructions > 0 || c.maxMemory > 0 || c.options.CallStackSize > 0 || c.options.RegistrySize > 0
+}
+
+// terminateTimeout returns `requestTerminateTimeout` as duration.
+func (c *Config) terminateTimeout() time.Duration {
+	return time.Duration(c.requestTerminateTimeout) * time.Second
+}
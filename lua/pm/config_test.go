@@ -78,3 +78,33 @@ func TestConfig(t *testing.T) {
 		return
 	}
 }
+
+func TestConfigIdle(t *testing.T) {
+	c, err := NewConfig(10, -1, -1, 120, "1h")
+	if !assert.NoError(t, err, "NewConfig should succeed") {
+		return
+	}
+
+	if !assert.Equal(t, DefaultMinIdle, c.MinIdle(), "minIdle mismatching") {
+		return
+	}
+
+	if !assert.Equal(t, DefaultMaxIdle, c.MaxIdle(), "maxIdle mismatching") {
+		return
+	}
+
+	c.SetMaxIdle(4)
+	c.SetMinIdle(6)
+	if !assert.Equal(t, 6, c.MinIdle(), "minIdle mismatching") {
+		return
+	}
+
+	if !assert.Equal(t, 6, c.MaxIdle(), "maxIdle mismatching") {
+		return
+	}
+
+	c.SetMinIdle(20)
+	if !assert.Equal(t, 10, c.MinIdle(), "minIdle mismatching") {
+		return
+	}
+}
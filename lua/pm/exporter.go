@@ -0,0 +1,175 @@
+// (c) 2018, Jeff Chen <jefurry@qq.com>
+//
+// This file is part of Gola
+//
+// Copyright 2018 The Gola Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package pm
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	metricPrefix = "gola_pm_"
+
+	// content type of prometheus text exposition format.
+	exporterContentType = "text/plain; version=0.0.4; charset=utf-8"
+)
+
+type (
+	// Exporter renders statistics of registered pools in prometheus text exposition format.
+	Exporter struct {
+		lock  sync.RWMutex
+		pools map[string]*LPM
+	}
+
+	poolStats struct {
+		name  string
+		stats Stats
+	}
+)
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func NewExporter() *Exporter {
+	return &Exporter{
+		pools: make(map[string]*LPM),
+	}
+}
+
+// Register adds pool with name, the name is exported as label `pool`.
+func (e *Exporter) Register(name string, lpm *LPM) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	e.pools[name] = lpm
+}
+
+func (e *Exporter) Unregister(name string) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	delete(e.pools, name)
+}
+
+func (e *Exporter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var buf bytes.Buffer
+	if _, err := e.WriteTo(&buf); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+
+		return
+	}
+
+	w.Header().Set("Content-Type", exporterContentType)
+	w.Write(buf.Bytes())
+}
+
+// WriteTo writes statistics of registered pools to w.
+func (e *Exporter) WriteTo(w io.Writer) (int64, error) {
+	e.lock.RLock()
+	names := make([]string, 0, len(e.pools))
+	for name := range e.pools {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	pss := make([]poolStats, 0, len(names))
+	for _, name := range names {
+		pss = append(pss, poolStats{name: name, stats: e.pools[name].Stats()})
+	}
+	e.lock.RUnlock()
+
+	var buf bytes.Buffer
+
+	writeFamily(&buf, "states", "gauge", "Number of lua states in pool.", pss, func(ps poolStats) {
+		writeSample(&buf, "states", ps.name, []string{"state", "idle"}, float64(ps.stats.Idle))
+		writeSample(&buf, "states", ps.name, []string{"state", "busy"}, float64(ps.stats.Busy))
+	})
+
+	writeFamily(&buf, "waiting_requests", "gauge", "Number of requests waiting for an available lua state.", pss, func(ps poolStats) {
+		writeSample(&buf, "waiting_requests", ps.name, nil, float64(ps.stats.Waiting))
+	})
+
+	for _, c := range []struct {
+		name  string
+		help  string
+		value func(Stats) int
+	}{
+		{"states_created_total", "Total number of lua states created.", func(s Stats) int { return s.Created }},
+		{"states_destroyed_total", "Total number of lua states destroyed.", func(s Stats) int { return s.Destroyed }},
+		{"requests_total", "Total number of requests served.", func(s Stats) int { return s.Requests }},
+		{"requests_rejected_total", "Total number of requests not served.", func(s Stats) int { return s.Rejected }},
+		{"request_errors_total", "Total number of requests failed with error.", func(s Stats) int { return s.Errors }},
+		{"request_timeouts_total", "Total number of requests terminated by timeout.", func(s Stats) int { return s.Timeouts }},
+		{"requests_killed_total", "Total number of requests killed by shutdown.", func(s Stats) int { return s.Killed }},
+		{"request_quotas_exceeded_total", "Total number of requests exceeded quotas.", func(s Stats) int { return s.QuotaExceeded }},
+	} {
+		writeFamily(&buf, c.name, "counter", c.help, pss, func(ps poolStats) {
+			writeSample(&buf, c.name, ps.name, nil, float64(c.value(ps.stats)))
+		})
+	}
+
+	writeFamily(&buf, "wait_seconds", "histogram", "Time waited for an available lua state.", pss, func(ps poolStats) {
+		writeHistogram(&buf, "wait_seconds", ps.name, ps.stats.WaitTime)
+	})
+
+	writeFamily(&buf, "exec_seconds", "histogram", "Time executing requests.", pss, func(ps poolStats) {
+		writeHistogram(&buf, "exec_seconds", ps.name, ps.stats.ExecTime)
+	})
+
+	writeFamily(&buf, "script_requests_total", "counter", "Total number of requests per script.", pss, func(ps poolStats) {
+		scripts := make([]string, 0, len(ps.stats.Scripts))
+		for script := range ps.stats.Scripts {
+			scripts = append(scripts, script)
+		}
+		sort.Strings(scripts)
+
+		for _, script := range scripts {
+			writeSample(&buf, "script_requests_total", ps.name, []string{"script", script}, float64(ps.stats.Scripts[script]))
+		}
+	})
+
+	return buf.WriteTo(w)
+}
+
+func writeFamily(buf *bytes.Buffer, name, typ, help string, pss []poolStats, fn func(poolStats)) {
+	fmt.Fprintf(buf, "# HELP %s%s %s\n", metricPrefix, name, help)
+	fmt.Fprintf(buf, "# TYPE %s%s %s\n", metricPrefix, name, typ)
+
+	for _, ps := range pss {
+		fn(ps)
+	}
+}
+
+func writeHistogram(buf *bytes.Buffer, name, pool string, h Histogram) {
+	for i, b := range h.Buckets {
+		writeSample(buf, name+"_bucket", pool, []string{"le", formatFloat(b)}, float64(h.Counts[i]))
+	}
+
+	writeSample(buf, name+"_bucket", pool, []string{"le", "+Inf"}, float64(h.Count))
+	writeSample(buf, name+"_sum", pool, nil, h.Sum)
+	writeSample(buf, name+"_count", pool, nil, float64(h.Count))
+}
+
+// writeSample writes a sample line, labels are pairs of name and value.
+func writeSample(buf *bytes.Buffer, name, pool string, labels []string, value float64) {
+	fmt.Fprintf(buf, `%s%s{pool="%s"`, metricPrefix, name, labelEscaper.Replace(pool))
+	for i := 0; i+1 < len(labels); i += 2 {
+		fmt.Fprintf(buf, `,%s="%s"`, labels[i], labelEscaper.Replace(labels[i+1]))
+	}
+	fmt.Fprintf(buf, "} %s\n", formatFloat(value))
+}
+
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
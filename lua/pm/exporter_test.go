@@ -0,0 +1,74 @@
+// (c) 2018, Jeff Chen <jefurry@qq.com>
+//
+// This file is part of Gola
+//
+// Copyright 2018 The Gola Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package pm
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestExporter(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.TODO())
+	defer cancel()
+
+	lpm, err := Default(ctx)
+	if !assert.NoError(t, err, "Default should succeed") {
+		return
+	}
+
+	defer lpm.Shutdown(context.TODO())
+
+	_, err = lpm.DoString(ctx, `return true`)
+	if !assert.NoError(t, err, "DoString should succeed") {
+		return
+	}
+
+	exporter := NewExporter()
+	exporter.Register(`rules"1`, lpm)
+
+	w := httptest.NewRecorder()
+	exporter.ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+
+	if !assert.Equal(t, 200, w.Code, "status code mismatching") {
+		return
+	}
+
+	if !assert.Equal(t, exporterContentType, w.Header().Get("Content-Type"), "content type mismatching") {
+		return
+	}
+
+	body := w.Body.String()
+	for _, line := range []string{
+		"# TYPE gola_pm_states gauge",
+		`gola_pm_states{pool="rules\"1",state="idle"} 1`,
+		`gola_pm_states{pool="rules\"1",state="busy"} 0`,
+		"# TYPE gola_pm_requests_total counter",
+		`gola_pm_requests_total{pool="rules\"1"} 1`,
+		"# TYPE gola_pm_exec_seconds histogram",
+		`gola_pm_exec_seconds_bucket{pool="rules\"1",le="+Inf"} 1`,
+		`gola_pm_exec_seconds_count{pool="rules\"1"} 1`,
+		`gola_pm_script_requests_total{pool="rules\"1",script="` + stringKey(`return true`) + `"} 1`,
+	} {
+		if !assert.True(t, strings.Contains(body, line+"\n"), "missing line: %s", line) {
+			return
+		}
+	}
+
+	exporter.Unregister(`rules"1`)
+
+	w = httptest.NewRecorder()
+	exporter.ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+
+	if !assert.False(t, strings.Contains(w.Body.String(), "rules"), "pool should be unregistered") {
+		return
+	}
+}
@@ -0,0 +1,96 @@
+// (c) 2018, Jeff Chen <jefurry@qq.com>
+//
+// This file is part of Gola
+//
+// Copyright 2018 The Gola Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package pm
+
+import (
+	"github.com/jefurry/gola/core/event"
+	"github.com/yuin/gopher-lua"
+)
+
+// Lifecycle events of pool.
+// The event data of lua state events is *LSEvent, and OpStatus of EventStatus.
+const (
+	// lua state created, after NewFunc.
+	// Note: lua states created on startup are not notified, since the pool starts
+	//       before listeners registered, use NewFunc to set up lua states instead.
+	EventCreated = "created"
+	// lua state taken from pool for a request.
+	EventAcquired = "acquired"
+	// lua state released by a request, before put back into pool.
+	EventReleased = "released"
+	// lua state recycled due to `maxRequest`.
+	EventRecycled = "recycled"
+	// lua state idle past `idleTimeout` and reaped.
+	EventReaped = "reaped"
+	// request terminated by `requestTerminateTimeout`.
+	EventTimeout = "timeout"
+	// request killed by shutdown.
+	EventKilled = "killed"
+	// request exceeded quota, the event data Err is *QuotaError.
+	EventQuota = "quota"
+	// request failed with error.
+	EventError = "error"
+	// operating status of pool changed.
+	EventStatus = "status"
+)
+
+type (
+	// LSEvent is the data of lua state events.
+	LSEvent struct {
+		// Note: L has been closed on EventError if the script raised error.
+		L *lua.LState
+		// The number of requests the lua state served.
+		RequestedNum int
+		// Script name and error of request events.
+		Script string
+		Err    error
+	}
+)
+
+// On registers a listener of lifecycle event.
+// Note: Listeners are called synchronously and may be called while pool is locked,
+//       so they must not call methods of the pool.
+func (lpm *LPM) On(etype string, handler event.Handler, priority ...int) {
+	lpm.hookLock.Lock()
+	defer lpm.hookLock.Unlock()
+
+	lpm.emitter.On(etype, handler, priority...)
+}
+
+// Off removes the listener of lifecycle event.
+func (lpm *LPM) Off(etype string, handler event.Handler) {
+	lpm.hookLock.Lock()
+	defer lpm.hookLock.Unlock()
+
+	lpm.emitter.Off(etype, handler)
+}
+
+// SetMaxListeners sets the maximum listeners per event, see event.Emitter.
+func (lpm *LPM) SetMaxListeners(n int) {
+	lpm.hookLock.Lock()
+	defer lpm.hookLock.Unlock()
+
+	lpm.emitter.SetMaxListeners(n)
+}
+
+func (lpm *LPM) fire(etype string, data interface{}) {
+	lpm.hookLock.Lock()
+	defer lpm.hookLock.Unlock()
+
+	lpm.emitter.Fire(etype, data, lpm)
+}
+
+func (lpm *LPM) fireLS(etype string, ls *lState, script string, err error) {
+	lpm.fire(etype, &LSEvent{
+		L:            ls.L,
+		RequestedNum: ls.requestedNum,
+		Script:       script,
+		Err:          err,
+	})
+}
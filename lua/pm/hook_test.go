@@ -0,0 +1,101 @@
+// (c) 2018, Jeff Chen <jefurry@qq.com>
+//
+// This file is part of Gola
+//
+// Copyright 2018 The Gola Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package pm
+
+import (
+	"context"
+	"github.com/jefurry/gola/core/event"
+	"github.com/stretchr/testify/assert"
+	"github.com/yuin/gopher-lua"
+	"sync"
+	"testing"
+)
+
+func TestHooks(t *testing.T) {
+	config, err := NewConfig(2, 1, 2, 120, "1h")
+	if !assert.NoError(t, err, "NewConfig should succeed") {
+		return
+	}
+
+	ctx, cancel := context.WithCancel(context.TODO())
+	defer cancel()
+
+	lpm, err := New(ctx, config)
+	if !assert.NoError(t, err, "New should succeed") {
+		return
+	}
+
+	var lock sync.Mutex
+	events := make([]string, 0)
+	statuses := make([]OpStatus, 0)
+	for _, etype := range []string{EventCreated, EventAcquired, EventReleased, EventRecycled, EventError} {
+		etype := etype
+		lpm.On(etype, func(evt *event.Event) bool {
+			lock.Lock()
+			defer lock.Unlock()
+
+			events = append(events, etype)
+
+			return true
+		})
+	}
+
+	lpm.On(EventStatus, func(evt *event.Event) bool {
+		statuses = append(statuses, evt.Data.(OpStatus))
+
+		return true
+	})
+
+	// resets globals on release.
+	lpm.On(EventReleased, func(evt *event.Event) bool {
+		evt.Data.(*LSEvent).L.SetGlobal("counter", lua.LNil)
+
+		return true
+	})
+
+	for i := 0; i < 2; i++ {
+		lv, err := lpm.DoString(ctx, `
+		counter = (counter or 0) + 1
+		return counter
+		`, func(L *lua.LState) (lua.LValue, error) {
+			return L.Get(-1), nil
+		})
+		if !assert.NoError(t, err, "DoString should succeed") {
+			return
+		}
+
+		if !assert.Equal(t, lua.LNumber(1), lv, "globals should be reset") {
+			return
+		}
+	}
+
+	_, err = lpm.DoString(ctx, `error("failed")`)
+	if !assert.Error(t, err, "DoString should failed") {
+		return
+	}
+
+	if !assert.Equal(t, []string{
+		EventAcquired, EventReleased,
+		EventAcquired, EventReleased, EventRecycled,
+		EventCreated, EventAcquired, EventError,
+	}, events, "events mismatching") {
+		return
+	}
+
+	_, err = lpm.Restart(ctx)
+	if !assert.NoError(t, err, "Restart should succeed") {
+		return
+	}
+
+	lpm.Shutdown(context.TODO())
+
+	if !assert.Equal(t, []OpStatus{OpExiting, OpDead, OpReady, OpRunning, OpExiting, OpDead}, statuses, "statuses mismatching") {
+		return
+	}
+}
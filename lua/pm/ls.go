@@ -11,20 +11,46 @@ package pm
 import (
 	"context"
 	"github.com/yuin/gopher-lua"
+	"sync/atomic"
 	"time"
 )
 
+const (
+	terminatedTimeout int32 = iota + 1
+	terminatedKilled
+)
+
 type (
 	lState struct {
 		L                  *lua.LState
 		cancel             context.CancelFunc
 		requestedNum       int
 		startTime          int64
+		lastUsedTime       int64
 		maxRequest         int
 		idleTimeout        string
 		idleTimeoutSeconds int
 		serving            bool
 		closed             bool
+
+		// watchdog of the serving request.
+		watchdog   *time.Timer
+		terminated int32
+
+		// snapshot of globals captured after NewFunc.
+		globals *lua.LTable
+
+		// snapshot of tables reachable from globals and registry, including globals itself.
+		tables map[*lua.LTable]*tableSnapshot
+
+		// resources used by the serving request.
+		quota *quota
+	}
+
+	// tableSnapshot holds fields and metatable of a table.
+	tableSnapshot struct {
+		fields *lua.LTable
+		mt     lua.LValue
 	}
 )
 
@@ -38,6 +64,7 @@ func newLState(ctx context.Context, maxRequest int, idleTimeout string, seconds
 		}
 	}
 
+	now := time.Now().Unix()
 	lctx, cancel := context.WithCancel(ctx)
 	l.SetContext(lctx)
 
@@ -45,12 +72,15 @@ func newLState(ctx context.Context, maxRequest int, idleTimeout string, seconds
 		L:                  l,
 		cancel:             cancel,
 		requestedNum:       0,
-		startTime:          time.Now().Unix(),
+		startTime:          now,
+		lastUsedTime:       now,
 		maxRequest:         maxRequest,
 		idleTimeout:        idleTimeout,
 		idleTimeoutSeconds: seconds,
 	}
 
+	ls.snapshot()
+
 	return ls, nil
 }
 
@@ -63,23 +93,178 @@ func (ls *lState) mustTerminate() bool {
 		if ls.requestedNum >= ls.maxRequest {
 			return true
 		}
+	}
+
+	return false
+}
 
+// touch records the time the lua state was last used.
+func (ls *lState) touch() {
+	ls.lastUsedTime = time.Now().Unix()
+}
+
+// idle reports whether the lua state has been idle past `idleTimeout`.
+func (ls *lState) idle(now time.Time) bool {
+	if ls.idleTimeoutSeconds <= 0 {
 		return false
 	}
 
-	if ls.idleTimeoutSeconds > 0 {
-		if (time.Now().Unix() - ls.startTime) > int64(ls.idleTimeoutSeconds) {
-			return true
+	return (now.Unix() - ls.lastUsedTime) > int64(ls.idleTimeoutSeconds)
+}
+
+// snapshot captures globals of lua state and the tables reachable from them,
+// such as library tables and package.loaded.
+func (ls *lState) snapshot() {
+	L := ls.L
+
+	ls.tables = make(map[*lua.LTable]*tableSnapshot)
+	ls.capture(L.G.Global)
+
+	// tables of registry like package.loaded and type metatables are captured,
+	// but not the registry itself, which is also used by go code.
+	L.G.Registry.ForEach(func(k, v lua.LValue) {
+		if tbl, ok := v.(*lua.LTable); ok {
+			ls.capture(tbl)
 		}
+	})
+
+	if mt, ok := L.GetMetatable(lua.LString("")).(*lua.LTable); ok {
+		ls.capture(mt)
 	}
 
-	return false
+	ls.globals = ls.tables[L.G.Global].fields
+}
+
+// capture captures tbl and the tables reachable from its keys, values and metatable.
+func (ls *lState) capture(tbl *lua.LTable) {
+	L := ls.L
+
+	pending := []*lua.LTable{tbl}
+	for len(pending) > 0 {
+		t := pending[len(pending)-1]
+		pending = pending[:len(pending)-1]
+
+		if _, ok := ls.tables[t]; ok {
+			continue
+		}
+
+		snap := &tableSnapshot{
+			fields: L.NewTable(),
+			mt:     L.GetMetatable(t),
+		}
+		ls.tables[t] = snap
+
+		t.ForEach(func(k, v lua.LValue) {
+			snap.fields.RawSet(k, v)
+
+			if kt, ok := k.(*lua.LTable); ok {
+				pending = append(pending, kt)
+			}
+
+			if vt, ok := v.(*lua.LTable); ok {
+				pending = append(pending, vt)
+			}
+		})
+
+		if mt, ok := snap.mt.(*lua.LTable); ok {
+			pending = append(pending, mt)
+		}
+	}
+}
+
+// isolate makes the serving request run with a fresh environment table,
+// which falls through to the snapshot of globals.
+func (ls *lState) isolate() {
+	L := ls.L
+
+	env := L.NewTable()
+	env.RawSetString("_G", env)
+
+	mt := L.NewTable()
+	mt.RawSetString("__index", ls.globals)
+	L.SetMetatable(env, mt)
+
+	L.Env = env
+}
+
+// unisolate restores the environment of lua state to globals.
+func (ls *lState) unisolate() {
+	ls.L.Env = ls.L.G.Global
+}
+
+// reset restores globals and the tables captured by snapshot, which are
+// modified by the served requests, so that requests cannot observe each other's state.
+// Note: Tables created after snapshot and upvalues of functions are not restored.
+func (ls *lState) reset() {
+	L := ls.L
+
+	for tbl, snap := range ls.tables {
+		dirty := make([]lua.LValue, 0)
+		tbl.ForEach(func(k, v lua.LValue) {
+			if snap.fields.RawGet(k) != v {
+				dirty = append(dirty, k)
+			}
+		})
+
+		for _, k := range dirty {
+			tbl.RawSet(k, snap.fields.RawGet(k))
+		}
+
+		snap.fields.ForEach(func(k, v lua.LValue) {
+			if tbl.RawGet(k) != v {
+				tbl.RawSet(k, v)
+			}
+		})
+
+		if L.GetMetatable(tbl) != snap.mt {
+			L.SetMetatable(tbl, snap.mt)
+		}
+	}
 }
 
 func (ls *lState) setServing(serving bool) {
 	ls.serving = serving
 }
 
+// watch cancels the context of lua state if the serving request
+// does not finish within d. A value of 0 indicates no limit.
+func (ls *lState) watch(d time.Duration) {
+	if d <= 0 {
+		return
+	}
+
+	ls.watchdog = time.AfterFunc(d, func() {
+		ls.terminate(terminatedTimeout)
+	})
+}
+
+// unwatch stops the watchdog, it returns the reason if the lua state has been terminated.
+func (ls *lState) unwatch() error {
+	if ls.watchdog != nil {
+		ls.watchdog.Stop()
+		ls.watchdog = nil
+	}
+
+	switch atomic.LoadInt32(&ls.terminated) {
+	case terminatedTimeout:
+		return ErrLSTimeout
+	case terminatedKilled:
+		return ErrLSKilled
+	}
+
+	return nil
+}
+
+// kill cancels the context of lua state for shutdown.
+func (ls *lState) kill() {
+	ls.terminate(terminatedKilled)
+}
+
+func (ls *lState) terminate(reason int32) {
+	atomic.CompareAndSwapInt32(&ls.terminated, 0, reason)
+	ls.cancel()
+}
+
 func (ls *lState) close() {
 	ls.closed = true
 	ls.setServing(false)
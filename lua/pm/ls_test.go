@@ -110,3 +110,86 @@ func TestNewLState(t *testing.T) {
 		ls.close()
 	}
 }
+
+func TestLStateIdle(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.TODO())
+	defer cancel()
+
+	ls, err := newLState(ctx, 0, "10s", 10, lua.Options{}, nil)
+	if !assert.NoError(t, err, "newLState should succeed") {
+		return
+	}
+
+	defer ls.close()
+
+	now := time.Now()
+	if !assert.Equal(t, false, ls.idle(now), "value mismatching") {
+		return
+	}
+
+	if !assert.Equal(t, true, ls.idle(now.Add(11*time.Second)), "value mismatching") {
+		return
+	}
+
+	ls.lastUsedTime = now.Add(5 * time.Second).Unix()
+	if !assert.Equal(t, false, ls.idle(now.Add(11*time.Second)), "value mismatching") {
+		return
+	}
+
+	if !assert.Equal(t, false, ls.mustTerminate(), "value mismatching") {
+		return
+	}
+}
+
+func TestLStateReset(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.TODO())
+	defer cancel()
+
+	ls, err := newLState(ctx, 0, "1h", 3600, lua.Options{}, func(L *lua.LState) error {
+		L.SetGlobal("shared", lua.LNumber(1))
+
+		return nil
+	})
+	if !assert.NoError(t, err, "newLState should succeed") {
+		return
+	}
+
+	defer ls.close()
+
+	ls.isolate()
+	err = ls.L.DoString(`leaked = 1; _G.shared = 2; print = nil`)
+	ls.unisolate()
+	if !assert.NoError(t, err, "DoString should succeed") {
+		return
+	}
+
+	L := ls.L
+	if !assert.Equal(t, lua.LNil, L.GetGlobal("leaked"), "value mismatching") {
+		return
+	}
+
+	if !assert.Equal(t, lua.LNumber(1), L.GetGlobal("shared"), "value mismatching") {
+		return
+	}
+
+	if !assert.NoError(t, L.DoString(`leaked = 1; shared = 2; print = nil; setmetatable(_G, {})`), "DoString should succeed") {
+		return
+	}
+
+	ls.reset()
+	if !assert.Equal(t, lua.LNil, L.GetGlobal("leaked"), "value mismatching") {
+		return
+	}
+
+	if !assert.Equal(t, lua.LNumber(1), L.GetGlobal("shared"), "value mismatching") {
+		return
+	}
+
+	if !assert.NotEqual(t, lua.LNil, L.GetGlobal("print"), "value mismatching") {
+		return
+	}
+
+	if !assert.Equal(t, lua.LNil, L.GetMetatable(L.G.Global), "value mismatching") {
+		return
+	}
+}
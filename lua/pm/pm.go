@@ -10,11 +10,14 @@
 package pm
 
 import (
+	"container/list"
 	"context"
+	"github.com/jefurry/gola/core/event"
 	"github.com/pkg/errors"
 	"github.com/yuin/gopher-lua"
 	"io"
 	"sync"
+	"time"
 )
 
 const (
@@ -33,12 +36,38 @@ const (
 	DefaultStartNum    = 1
 	DefaultMaxRequest  = 0
 	DefaultIdleTimeout = "1h"
+	DefaultMinIdle     = 0
+	DefaultMaxIdle     = 0
+	DefaultMaxWaitNum  = 0
+
+	DefaultMaxInstructions = 0
+	DefaultMaxMemory       = 0
+)
+
+const (
+	minReapInterval = 1 * time.Second
+	maxReapInterval = 1 * time.Minute
+
+	// how long exit waits for the killed requests to unwind.
+	killWaitTimeout = 1 * time.Second
 )
 
 const (
 	DefaultRequestTerminateTimeout = 120
 )
 
+const (
+	// requests share globals of lua state.
+	IsolateNone Isolation = 0
+	// each request runs with a fresh environment table, which falls through
+	// to the snapshot of globals captured after NewFunc, tables reachable from
+	// globals like string and package.loaded are restored when lua state put back.
+	IsolateEnv Isolation = 1 << 0
+	// globals and tables reachable from them modified by request are restored
+	// from the snapshot when lua state put back.
+	IsolateReset Isolation = 1 << 1
+)
+
 const (
 	OpReady OpStatus = iota
 	OpRunning
@@ -52,15 +81,27 @@ var (
 	ErrLSPExiting        = errors.New("lua state pool exiting")
 	ErrLSPDead           = errors.New("lua state pool dead")
 	ErrLSClosed          = errors.New("lua status has closed")
+	ErrLSTimeout         = errors.New("lua state request terminate timeout")
+	ErrLSKilled          = errors.New("lua state killed by shutdown")
 )
 
 type (
 	// Operating status
 	OpStatus int
-	NewFunc  func(l *lua.LState) error
+	// Isolation mode of requests, see IsolateEnv and IsolateReset.
+	Isolation int
+	NewFunc   func(l *lua.LState) error
 )
 
 type (
+	// ShutdownReport reports the serving requests when pool shut down.
+	ShutdownReport struct {
+		// The number of requests finished before deadline.
+		Drained int
+		// The number of requests killed.
+		Killed int
+	}
+
 	DoHandler   func(*lua.LState) (lua.LValue, error)
 	LoadHandler func(*lua.LState, *lua.LFunction) (lua.LValue, error)
 
@@ -73,6 +114,12 @@ type (
 		// lua state container.
 		lss []*lState
 
+		// serving lua states.
+		busy map[*lState]struct{}
+
+		// requests waiting for an available lua state.
+		waiters *list.List
+
 		lock *sync.Mutex
 		cond *sync.Cond
 
@@ -81,6 +128,21 @@ type (
 		totalRequestedNum int
 
 		whenNew NewFunc
+
+		// compiled chunks shared by lua states.
+		chunks *chunkCache
+
+		metrics *metrics
+
+		// lifecycle events emitter.
+		emitter  *event.Emitter
+		hookLock sync.Mutex
+
+		// ctx is the context pool started with, it used by reaper.
+		ctx context.Context
+		// closing done stops reaper.
+		done   chan struct{}
+		reaper sync.WaitGroup
 	}
 )
 
@@ -108,8 +170,16 @@ func newLPM(ctx context.Context, config *Config, whenNews ...NewFunc) (*LPM, err
 		config:   c,
 		opStatus: OpReady,
 		whenNew:  whenNew,
+		chunks:   newChunkCache(),
+		metrics:  newMetrics(),
+		emitter:  event.NewEmitter(),
+		busy:     make(map[*lState]struct{}),
+		waiters:  list.New(),
 	}
 
+	lpm.lock = new(sync.Mutex)
+	lpm.cond = sync.NewCond(lpm.lock)
+
 	err := lpm.start(ctx)
 	if err != nil {
 		return nil, err
@@ -131,121 +201,128 @@ func New(ctx context.Context, config *Config, whenNews ...NewFunc) (*LPM, error)
 }
 
 func (lpm *LPM) Load(ctx context.Context, reader io.Reader, name string, handlers ...LoadHandler) (lua.LValue, error) {
-	ls, err := lpm.get(ctx)
-	if err != nil {
-		return lua.LNil, err
-	}
-
-	defer lpm.put(ls)
-
-	fn, err := ls.L.Load(reader, name)
-	if err != nil {
-		lpm.Close(ls)
+	return lpm.execute(ctx, name, func(ls *lState) (lua.LValue, error) {
+		fn, err := ls.L.Load(reader, name)
+		if err != nil {
+			lpm.Close(ls)
 
-		return lua.LNil, err
-	}
+			return lua.LNil, err
+		}
 
-	if len(handlers) > 0 {
-		handler := handlers[0]
+		if len(handlers) > 0 {
+			handler := handlers[0]
 
-		return handler(ls.L, fn)
-	}
+			return handler(ls.L, fn)
+		}
 
-	return fn, nil
+		return fn, nil
+	})
 }
 
 func (lpm *LPM) LoadFile(ctx context.Context, path string, handlers ...LoadHandler) (lua.LValue, error) {
-	ls, err := lpm.get(ctx)
-	if err != nil {
-		return lua.LNil, err
-	}
-
-	defer lpm.put(ls)
-
-	fn, err := ls.L.LoadFile(path)
-	if err != nil {
-		lpm.Close(ls)
+	return lpm.execute(ctx, path, func(ls *lState) (lua.LValue, error) {
+		fn, err := lpm.loadFile(ls.L, path)
+		if err != nil {
+			lpm.Close(ls)
 
-		return lua.LNil, err
-	}
+			return lua.LNil, err
+		}
 
-	if len(handlers) > 0 {
-		handler := handlers[0]
+		if len(handlers) > 0 {
+			handler := handlers[0]
 
-		return handler(ls.L, fn)
-	}
+			return handler(ls.L, fn)
+		}
 
-	return fn, nil
+		return fn, nil
+	})
 }
 
 func (lpm *LPM) LoadString(ctx context.Context, source string, handlers ...LoadHandler) (lua.LValue, error) {
-	ls, err := lpm.get(ctx)
-	if err != nil {
-		return lua.LNil, err
-	}
+	return lpm.execute(ctx, stringKey(source), func(ls *lState) (lua.LValue, error) {
+		fn, err := lpm.loadString(ls.L, source)
+		if err != nil {
+			lpm.Close(ls)
 
-	defer lpm.put(ls)
+			return lua.LNil, err
+		}
 
-	fn, err := ls.L.LoadString(source)
-	if err != nil {
-		lpm.Close(ls)
+		if len(handlers) > 0 {
+			handler := handlers[0]
 
-		return lua.LNil, err
-	}
+			return handler(ls.L, fn)
+		}
 
-	if len(handlers) > 0 {
-		handler := handlers[0]
+		return fn, nil
+	})
+}
 
-		return handler(ls.L, fn)
-	}
+// LoadProto loads the function of proto compiled by lua.Compile, the proto is
+// shared by lua states without compiling again.
+func (lpm *LPM) LoadProto(ctx context.Context, proto *lua.FunctionProto, handlers ...LoadHandler) (lua.LValue, error) {
+	return lpm.execute(ctx, proto.SourceName, func(ls *lState) (lua.LValue, error) {
+		fn := newFunction(ls.L, proto)
 
-	return fn, nil
-}
+		if len(handlers) > 0 {
+			handler := handlers[0]
 
-func (lpm *LPM) DoFile(ctx context.Context, path string, handlers ...DoHandler) (lua.LValue, error) {
-	ls, err := lpm.get(ctx)
-	if err != nil {
-		return lua.LNil, err
-	}
+			return handler(ls.L, fn)
+		}
 
-	defer lpm.put(ls)
+		return fn, nil
+	})
+}
 
-	if err := ls.L.DoFile(path); err != nil {
-		lpm.Close(ls)
+func (lpm *LPM) DoFile(ctx context.Context, path string, handlers ...DoHandler) (lua.LValue, error) {
+	return lpm.execute(ctx, path, func(ls *lState) (lua.LValue, error) {
+		if err := lpm.doFile(ls.L, path); err != nil {
+			lpm.Close(ls)
 
-		return lua.LNil, err
-	}
+			return lua.LNil, err
+		}
 
-	if len(handlers) > 0 {
-		handler := handlers[0]
+		if len(handlers) > 0 {
+			handler := handlers[0]
 
-		return handler(ls.L)
-	}
+			return handler(ls.L)
+		}
 
-	return lua.LNil, nil
+		return lua.LNil, nil
+	})
 }
 
 func (lpm *LPM) DoString(ctx context.Context, source string, handlers ...DoHandler) (lua.LValue, error) {
-	ls, err := lpm.get(ctx)
-	if err != nil {
-		return lua.LNil, err
-	}
+	return lpm.execute(ctx, stringKey(source), func(ls *lState) (lua.LValue, error) {
+		if err := lpm.doString(ls.L, source); err != nil {
+			lpm.Close(ls)
 
-	defer lpm.put(ls)
+			return lua.LNil, err
+		}
 
-	if err := ls.L.DoString(source); err != nil {
-		lpm.Close(ls)
+		if len(handlers) > 0 {
+			handler := handlers[0]
 
-		return lua.LNil, err
-	}
+			return handler(ls.L)
+		}
 
-	if len(handlers) > 0 {
-		handler := handlers[0]
+		return lua.LNil, nil
+	})
+}
 
-		return handler(ls.L)
-	}
+// ChunkCacheStats returns statistics of compiled chunk cache.
+func (lpm *LPM) ChunkCacheStats() ChunkCacheStats {
+	return lpm.chunks.stats()
+}
 
-	return lua.LNil, nil
+// InvalidateChunks removes compiled chunks of paths from cache,
+// or all chunks if no path is given.
+func (lpm *LPM) InvalidateChunks(paths ...string) {
+	lpm.chunks.invalidate(paths...)
+}
+
+// InvalidateStrings removes compiled chunks of sources from cache.
+func (lpm *LPM) InvalidateStrings(sources ...string) {
+	lpm.chunks.invalidateStrings(sources...)
 }
 
 func (lpm *LPM) Config() *Config {
@@ -289,6 +366,13 @@ func (lpm *LPM) TotalRequestedNum() int {
 	return lpm.totalRequestedNum
 }
 
+func (lpm *LPM) WaitingNum() int {
+	lpm.lock.Lock()
+	defer lpm.lock.Unlock()
+
+	return lpm.waiters.Len()
+}
+
 func (lpm *LPM) Len() int {
 	lpm.lock.Lock()
 	defer lpm.lock.Unlock()
@@ -308,54 +392,79 @@ func (lpm *LPM) Close(ls *lState) {
 		panic("lua state not running")
 	}
 
-	lpm.servingNum -= 1
+	lpm.discard(ls)
+}
 
-	if lpm.servingNum <= 0 {
-		lpm.cond.Signal()
-	}
+func (lpm *LPM) IdleNum() int {
+	lpm.lock.Lock()
+	defer lpm.lock.Unlock()
 
-	lpm.length -= 1
-	ls.close()
+	return len(lpm.lss)
 }
 
-func (lpm *LPM) Shutdown() {
+// Shutdown stops accepting requests, and waits for the serving requests until ctx is done,
+// then kills the remaining ones. It returns ctx.Err() if any request is killed.
+func (lpm *LPM) Shutdown(ctx context.Context) (ShutdownReport, error) {
 	lpm.readyExit()
-	lpm.exit()
+	report := lpm.exit(ctx)
+	lpm.reaper.Wait()
 
-	lpm.length = 0
-	lpm.servingNum = 0
-	lpm.lss = nil
+	if report.Killed > 0 {
+		return report, ctx.Err()
+	}
+
+	return report, nil
 }
 
-func (lpm *LPM) Restart(ctx context.Context) error {
-	lpm.Shutdown()
+// Restart shuts down the pool like Shutdown and starts it again with ctx.
+// The serving requests are waited up to `requestTerminateTimeout` before killed.
+func (lpm *LPM) Restart(ctx context.Context) (ShutdownReport, error) {
+	sctx := ctx
+	if d := lpm.config.terminateTimeout(); d > 0 {
+		var cancel context.CancelFunc
+		sctx, cancel = context.WithTimeout(ctx, d)
+		defer cancel()
+	}
 
+	report, serr := lpm.Shutdown(sctx)
 	if err := lpm.start(ctx); err != nil {
-		return err
+		return report, err
 	}
 
-	return nil
+	return report, serr
 }
 
 func (lpm *LPM) start(ctx context.Context) error {
+	lpm.lock.Lock()
 	lpm.lss = make([]*lState, 0, lpm.config.maxNum)
-	lpm.lock = new(sync.Mutex)
-	lpm.cond = sync.NewCond(lpm.lock)
+	lpm.setStatus(OpReady)
+	lpm.ctx = ctx
+	lpm.done = make(chan struct{})
+	lpm.lock.Unlock()
 
 	for i := 0; i < lpm.config.startNum; i++ {
 		ls, err := lpm.gen(ctx)
 		if err != nil {
-			lpm.Shutdown()
+			lpm.Shutdown(ctx)
 
 			return err
 		}
 
+		lpm.lock.Lock()
 		lpm.length += 1
+		lpm.lock.Unlock()
+
 		ls.setServing(false)
 		lpm.put(ls)
 	}
 
-	lpm.opStatus = OpRunning
+	lpm.lock.Lock()
+	lpm.setStatus(OpRunning)
+	lpm.fill()
+	lpm.lock.Unlock()
+
+	lpm.reaper.Add(1)
+	go lpm.reap(lpm.done)
 
 	return nil
 }
@@ -366,34 +475,55 @@ func (lpm *LPM) put(ls *lState) error {
 		return ErrLSClosed
 	}
 
+	if lpm.config.isolation != IsolateNone {
+		ls.reset()
+	}
+
 	lpm.lock.Lock()
 	defer lpm.lock.Unlock()
 
+	// the lua state detached by exit is not put into the pool started again.
+	if lpm.detached(ls) {
+		lpm.discard(ls)
+
+		return ErrLSPDead
+	}
+
 	if lpm.opStatus == OpExiting {
-		lpm.Close(ls)
+		lpm.discard(ls)
 
 		return ErrLSPExiting
 	}
 
 	if lpm.opStatus == OpDead {
-		lpm.Close(ls)
+		lpm.discard(ls)
 
 		return ErrLSPDead
 	}
 
 	if ls.mustTerminate() {
-		lpm.Close(ls)
+		lpm.fireLS(EventRecycled, ls, "", nil)
+		lpm.discard(ls)
+
+		return nil
+	}
+
+	if lpm.config.maxIdle > 0 && len(lpm.lss) >= lpm.config.maxIdle {
+		lpm.discard(ls)
 
 		return nil
 	}
 
 	if ls.serving {
 		lpm.servingNum -= 1
+		delete(lpm.busy, ls)
 	}
 
 	ls.setServing(false)
+	ls.touch()
 
 	lpm.lss = append(lpm.lss, ls)
+	lpm.cond.Broadcast()
 
 	return nil
 }
@@ -402,41 +532,220 @@ func (lpm *LPM) get(ctx context.Context) (*lState, error) {
 	lpm.lock.Lock()
 	defer lpm.lock.Unlock()
 
-	if lpm.opStatus == OpExiting {
-		return nil, ErrLSPExiting
-	}
+	var ls *lState
+	var w *list.Element
+	for {
+		if lpm.opStatus == OpExiting {
+			lpm.leave(w)
 
-	if lpm.opStatus == OpDead {
-		return nil, ErrLSPDead
-	}
+			return nil, ErrLSPExiting
+		}
 
-	var ls *lState
-	n := len(lpm.lss)
-	if n == 0 {
-		if lpm.config.maxNum != 0 && lpm.length >= lpm.config.maxNum {
-			return nil, ErrLSPFulled
+		if lpm.opStatus == OpDead {
+			lpm.leave(w)
+
+			return nil, ErrLSPDead
 		}
 
-		lstate, err := lpm.gen(ctx)
-		if err != nil {
+		// waiting requests are served in order.
+		turn := lpm.waiters.Len() == 0 || lpm.waiters.Front() == w
+		n := len(lpm.lss)
+		if turn && n > 0 {
+			ls = lpm.lss[n-1]
+			lpm.lss = lpm.lss[0 : n-1]
+
+			break
+		}
+
+		if turn && (lpm.config.maxNum == 0 || lpm.length < lpm.config.maxNum) {
+			lstate, err := lpm.gen(ctx)
+			if err != nil {
+				lpm.leave(w)
+
+				return nil, err
+			}
+
+			lpm.length += 1
+			ls = lstate
+
+			break
+		}
+
+		if w == nil {
+			if lpm.waiters.Len() >= lpm.config.maxWaitNum {
+				return nil, ErrLSPFulled
+			}
+
+			w = lpm.waiters.PushBack(struct{}{})
+			defer lpm.wake(ctx)()
+		}
+
+		if err := ctx.Err(); err != nil {
+			lpm.leave(w)
+
 			return nil, err
 		}
 
-		lpm.length += 1
-		ls = lstate
-	} else {
-		ls = lpm.lss[n-1]
-		lpm.lss = lpm.lss[0 : n-1]
+		lpm.cond.Wait()
 	}
 
+	lpm.leave(w)
+
 	ls.setServing(true)
 	lpm.servingNum += 1
+	lpm.busy[ls] = struct{}{}
 	lpm.totalRequestedNum += 1
 	ls.incRequestNum()
 
 	return ls, nil
 }
 
+// leave removes w from the waiting queue and wakes up the next one.
+// The caller must hold lpm.lock.
+func (lpm *LPM) leave(w *list.Element) {
+	if w == nil {
+		return
+	}
+
+	lpm.waiters.Remove(w)
+	lpm.cond.Broadcast()
+}
+
+// wake wakes up the waiting requests when ctx is done,
+// the returned function must be called after waiting.
+func (lpm *LPM) wake(ctx context.Context) func() {
+	stop := make(chan struct{})
+
+	go func() {
+		select {
+		case <-ctx.Done():
+			lpm.lock.Lock()
+			lpm.cond.Broadcast()
+			lpm.lock.Unlock()
+		case <-stop:
+		}
+	}()
+
+	return func() {
+		close(stop)
+	}
+}
+
+// loadFile loads file as lua function, the compiled chunk is shared
+// by lua states if `chunkCache` is enabled.
+func (lpm *LPM) loadFile(L *lua.LState, path string) (*lua.LFunction, error) {
+	if !lpm.config.chunkCache {
+		return L.LoadFile(path)
+	}
+
+	proto, err := lpm.chunks.file(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return newFunction(L, proto), nil
+}
+
+// loadString loads source as lua function, the compiled chunk is shared
+// by lua states if `chunkCache` is enabled.
+func (lpm *LPM) loadString(L *lua.LState, source string) (*lua.LFunction, error) {
+	if !lpm.config.chunkCache {
+		return L.LoadString(source)
+	}
+
+	proto, err := lpm.chunks.string(source)
+	if err != nil {
+		return nil, err
+	}
+
+	return newFunction(L, proto), nil
+}
+
+// doFile is like LState.DoFile, but loads file with loadFile.
+func (lpm *LPM) doFile(L *lua.LState, path string) error {
+	fn, err := lpm.loadFile(L, path)
+	if err != nil {
+		return err
+	}
+
+	L.Push(fn)
+
+	return L.PCall(0, lua.MultRet, nil)
+}
+
+// doString is like LState.DoString, but loads source with loadString.
+func (lpm *LPM) doString(L *lua.LState, source string) error {
+	fn, err := lpm.loadString(L, source)
+	if err != nil {
+		return err
+	}
+
+	L.Push(fn)
+
+	return L.PCall(0, lua.MultRet, nil)
+}
+
+// execute runs fn with a lua state taken from the pool, name is the script name for statistics.
+// The lua state is killed and discarded if fn does not return within `requestTerminateTimeout`.
+func (lpm *LPM) execute(ctx context.Context, name string, fn func(*lState) (lua.LValue, error)) (lua.LValue, error) {
+	start := time.Now()
+	ls, err := lpm.get(ctx)
+	if err != nil {
+		lpm.metrics.reject()
+
+		return lua.LNil, err
+	}
+
+	lpm.metrics.wait(time.Since(start))
+	lpm.fireLS(EventAcquired, ls, name, nil)
+
+	defer lpm.put(ls)
+
+	start = time.Now()
+	if lpm.config.isolation&IsolateEnv != 0 {
+		ls.isolate()
+	}
+
+	if ls.quota != nil {
+		ls.quota.reset()
+	}
+
+	ls.watch(lpm.config.terminateTimeout())
+	lv, err := fn(ls)
+	if uerr := ls.unwatch(); uerr != nil {
+		if uerr == ErrLSKilled {
+			lpm.fireLS(EventKilled, ls, name, uerr)
+		} else {
+			lpm.fireLS(EventTimeout, ls, name, uerr)
+		}
+
+		if !ls.closed {
+			lpm.Close(ls)
+		}
+
+		lv, err = lua.LNil, uerr
+	} else if qerr := ls.quotaExceeded(err); qerr != nil {
+		lpm.fireLS(EventQuota, ls, name, qerr)
+
+		if !ls.closed {
+			lpm.Close(ls)
+		}
+
+		lv, err = lua.LNil, qerr
+	} else if err != nil {
+		lpm.fireLS(EventError, ls, name, err)
+	}
+
+	lpm.metrics.exec(name, time.Since(start), err)
+
+	if !ls.closed {
+		ls.unisolate()
+		lpm.fireLS(EventReleased, ls, name, err)
+	}
+
+	return lv, err
+}
+
 func (lpm *LPM) gen(ctx context.Context) (*lState, error) {
 	ls, err := newLState(ctx, lpm.config.maxRequest, lpm.config.idleTimeout,
 		lpm.config.seconds, lpm.config.options, lpm.whenNew)
@@ -444,25 +753,191 @@ func (lpm *LPM) gen(ctx context.Context) (*lState, error) {
 		return nil, err
 	}
 
+	// the globals wrapped by quota are snapshotted again.
+	if lpm.config.quotas() {
+		ls.quota = newQuota(ls.L, lpm.config.maxInstructions, lpm.config.maxMemory,
+			getCallStackSize(lpm.config.options), getRegistrySize(lpm.config.options))
+		ls.snapshot()
+	}
+
+	lpm.metrics.create()
+	lpm.fireLS(EventCreated, ls, "", nil)
+
 	return ls, nil
 }
 
+// setStatus changes operating status and fires EventStatus.
+// The caller must hold lpm.lock.
+func (lpm *LPM) setStatus(opStatus OpStatus) {
+	if lpm.opStatus == opStatus {
+		return
+	}
+
+	lpm.opStatus = opStatus
+	lpm.fire(EventStatus, opStatus)
+}
+
+// discard closes the lua state and removes it from pool.
+// The caller must hold lpm.lock.
+func (lpm *LPM) discard(ls *lState) {
+	// the lua state detached by exit is already removed from pool.
+	if lpm.detached(ls) {
+		ls.close()
+
+		return
+	}
+
+	if ls.serving {
+		lpm.servingNum -= 1
+		delete(lpm.busy, ls)
+	}
+
+	lpm.length -= 1
+	ls.close()
+	lpm.metrics.destroy()
+
+	lpm.cond.Broadcast()
+}
+
+// detached reports whether the serving lua state is detached by exit.
+// The caller must hold lpm.lock.
+func (lpm *LPM) detached(ls *lState) bool {
+	if !ls.serving {
+		return false
+	}
+
+	_, ok := lpm.busy[ls]
+
+	return !ok
+}
+
+// reap periodically kills lua states idle past `idleTimeout`,
+// and keeps the number of idle lua states between `minIdle` and `maxIdle`.
+func (lpm *LPM) reap(done <-chan struct{}) {
+	defer lpm.reaper.Done()
+
+	ticker := time.NewTicker(getReapInterval(lpm.config.seconds))
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-done:
+			return
+		case <-lpm.ctx.Done():
+			return
+		case <-ticker.C:
+			lpm.lock.Lock()
+			if lpm.opStatus == OpRunning {
+				lpm.evict(time.Now())
+				lpm.fill()
+			}
+			lpm.lock.Unlock()
+		}
+	}
+}
+
+// evict kills lua states which are idle past `idleTimeout`, but keeps `minIdle` ones.
+// The caller must hold lpm.lock.
+func (lpm *LPM) evict(now time.Time) {
+	n := len(lpm.lss)
+	lss := make([]*lState, 0, n)
+
+	// the least recently used lua states are in the front.
+	for i, ls := range lpm.lss {
+		if len(lss)+n-i > lpm.config.minIdle && ls.idle(now) {
+			lpm.fireLS(EventReaped, ls, "", nil)
+			lpm.discard(ls)
+
+			continue
+		}
+
+		lss = append(lss, ls)
+	}
+
+	lpm.lss = lss
+}
+
+// fill creates lua states in advance until there are `minIdle` idle ones.
+// The caller must hold lpm.lock.
+func (lpm *LPM) fill() {
+	for len(lpm.lss) < lpm.config.minIdle {
+		if lpm.config.maxNum != 0 && lpm.length >= lpm.config.maxNum {
+			return
+		}
+
+		ls, err := lpm.gen(lpm.ctx)
+		if err != nil {
+			return
+		}
+
+		lpm.length += 1
+		lpm.lss = append(lpm.lss, ls)
+		lpm.cond.Broadcast()
+	}
+}
+
 func (lpm *LPM) readyExit() {
 	lpm.lock.Lock()
 	defer lpm.lock.Unlock()
 
-	lpm.opStatus = OpExiting
+	lpm.setStatus(OpExiting)
+	lpm.cond.Broadcast()
+
+	if lpm.done != nil {
+		close(lpm.done)
+		lpm.done = nil
+	}
 }
 
-func (lpm *LPM) exit() {
+// exit waits for the serving requests until ctx is done, then kills the remaining ones,
+// and closes the idle lua states.
+func (lpm *LPM) exit(ctx context.Context) ShutdownReport {
 	lpm.lock.Lock()
 	defer lpm.lock.Unlock()
 
-	for lpm.servingNum > 0 {
+	serving := lpm.servingNum
+	if serving > 0 {
+		defer lpm.wake(ctx)()
+	}
+
+	for lpm.servingNum > 0 && ctx.Err() == nil {
 		lpm.cond.Wait()
 	}
 
+	report := ShutdownReport{}
+	for ls := range lpm.busy {
+		ls.kill()
+		report.Killed += 1
+	}
+	report.Drained = serving - report.Killed
+
+	// waits for the killed requests to unwind, so that they are not put
+	// into the pool started again.
+	if report.Killed > 0 {
+		kctx, cancel := context.WithTimeout(context.Background(), killWaitTimeout)
+		stop := lpm.wake(kctx)
+		for lpm.servingNum > 0 && kctx.Err() == nil {
+			lpm.cond.Wait()
+		}
+		stop()
+		cancel()
+	}
+
+	// the requests still running are detached, their lua states are closed
+	// when put back.
+	for ls := range lpm.busy {
+		delete(lpm.busy, ls)
+		lpm.servingNum -= 1
+		lpm.length -= 1
+		lpm.metrics.destroy()
+	}
+
 	for _, ls := range lpm.lss {
-		ls.close()
+		lpm.discard(ls)
 	}
+
+	lpm.lss = nil
+	lpm.setStatus(OpDead)
+
+	return report
 }
@@ -84,7 +84,7 @@ func TestDefault(t *testing.T) {
 	}
 
 	lpm.put(ls2)
-	go lpm.Shutdown()
+	go lpm.Shutdown(context.TODO())
 	go lpm.Close(ls1)
 
 	<-time.After(3 * time.Second)
@@ -209,7 +209,7 @@ func TestServingNumWithDefault(t *testing.T) {
 		return
 	}
 
-	go lpm.Shutdown()
+	go lpm.Shutdown(context.TODO())
 	go lpm.Close(ls4)
 
 	<-time.After(3 * time.Second)
@@ -275,7 +275,7 @@ func TestNew_1(t *testing.T) {
 
 	fmt.Println(lpm.servingNum, lpm.Len())
 
-	go lpm.Shutdown()
+	go lpm.Shutdown(context.TODO())
 	go lpm.Close(ls1)
 
 	<-time.After(3 * time.Second)
@@ -323,7 +323,7 @@ func TestNew_2(t *testing.T) {
 	if !assert.Equal(t, 24*60*60, lpm.config.seconds, "seconds mismatching") {
 		return
 	}
-	lpm.Shutdown()
+	lpm.Shutdown(context.TODO())
 
 	config2, err := NewConfig(45, -1, 230, 120, "-2d")
 	if !assert.NoError(t, err, "NewConfig should succeed") {
@@ -355,7 +355,7 @@ func TestNew_2(t *testing.T) {
 	if !assert.Equal(t, 24*60*60, lpm.config.seconds, "seconds mismatching") {
 		return
 	}
-	lpm.Shutdown()
+	lpm.Shutdown(context.TODO())
 
 	config3, err := NewConfig(50, 30, 500, 120, "2h")
 	if !assert.NoError(t, err, "NewConfig should succeed") {
@@ -387,7 +387,7 @@ func TestNew_2(t *testing.T) {
 	if !assert.Equal(t, 2*60*60, lpm.config.seconds, "seconds mismatching") {
 		return
 	}
-	lpm.Shutdown()
+	lpm.Shutdown(context.TODO())
 
 	config4, err := NewConfig(50, 30, 500, 120, "-3h")
 	if !assert.NoError(t, err, "NewConfig should succeed") {
@@ -419,7 +419,7 @@ func TestNew_2(t *testing.T) {
 	if !assert.Equal(t, 60*60, lpm.config.seconds, "seconds mismatching") {
 		return
 	}
-	lpm.Shutdown()
+	lpm.Shutdown(context.TODO())
 
 	config5, err := NewConfig(83, 0, 450, 120, "78m")
 	if !assert.NoError(t, err, "NewConfig should succeed") {
@@ -451,7 +451,7 @@ func TestNew_2(t *testing.T) {
 	if !assert.Equal(t, 78*60, lpm.config.seconds, "seconds mismatching") {
 		return
 	}
-	lpm.Shutdown()
+	lpm.Shutdown(context.TODO())
 
 	config6, err := NewConfig(83, 0, 450, 120, "-98m")
 	if !assert.NoError(t, err, "NewConfig should succeed") {
@@ -483,7 +483,7 @@ func TestNew_2(t *testing.T) {
 	if !assert.Equal(t, 60, lpm.config.seconds, "seconds mismatching") {
 		return
 	}
-	lpm.Shutdown()
+	lpm.Shutdown(context.TODO())
 
 	config7, err := NewConfig(59, 100, 763, 120, "1583s")
 	if !assert.NoError(t, err, "NewConfig should succeed") {
@@ -515,7 +515,7 @@ func TestNew_2(t *testing.T) {
 	if !assert.Equal(t, 1583, lpm.config.seconds, "seconds mismatching") {
 		return
 	}
-	lpm.Shutdown()
+	lpm.Shutdown(context.TODO())
 
 	config8, err := NewConfig(59, 100, 763, 120, "-583s")
 	if !assert.NoError(t, err, "NewConfig should succeed") {
@@ -547,7 +547,7 @@ func TestNew_2(t *testing.T) {
 	if !assert.Equal(t, 1, lpm.config.seconds, "seconds mismatching") {
 		return
 	}
-	lpm.Shutdown()
+	lpm.Shutdown(context.TODO())
 }
 
 func TestNewLuaCode(t *testing.T) {
@@ -567,7 +567,7 @@ func TestNewLuaCode(t *testing.T) {
 		return
 	}
 
-	defer lpm.Shutdown()
+	defer lpm.Shutdown(context.TODO())
 
 	if !assert.Equal(t, 23, lpm.Len(), "length mismatching") {
 		return
@@ -643,3 +643,442 @@ func TestNewLuaCode(t *testing.T) {
 		break
 	}
 }
+
+func TestRequestTerminateTimeout(t *testing.T) {
+	config, err := NewConfig(2, 1, 0, 1, "1h")
+	if !assert.NoError(t, err, "NewConfig should succeed") {
+		return
+	}
+
+	ctx, cancel := context.WithCancel(context.TODO())
+	defer cancel()
+
+	lpm, err := New(ctx, config)
+	if !assert.NoError(t, err, "New should succeed") {
+		return
+	}
+
+	defer lpm.Shutdown(context.TODO())
+
+	code := `
+	while true do end
+	`
+
+	_, err = lpm.DoString(ctx, code)
+	if !assert.Equal(t, ErrLSTimeout, err, "DoString should be terminated") {
+		return
+	}
+
+	if !assert.Equal(t, 0, lpm.ServingNum(), "servingNum mismatching") {
+		return
+	}
+
+	if !assert.Equal(t, 0, lpm.Len(), "length mismatching") {
+		return
+	}
+
+	lv, err := lpm.DoString(ctx, `return true`, func(L *lua.LState) (lua.LValue, error) {
+		return L.Get(-1), nil
+	})
+	if !assert.NoError(t, err, "DoString should succeed") {
+		return
+	}
+
+	if !assert.Equal(t, lua.LTrue, lv, "value mismatching") {
+		return
+	}
+
+	if !assert.Equal(t, 1, lpm.Len(), "length mismatching") {
+		return
+	}
+}
+
+func TestReapIdle(t *testing.T) {
+	config, err := NewConfig(5, 4, 0, 120, "1s")
+	if !assert.NoError(t, err, "NewConfig should succeed") {
+		return
+	}
+
+	config.SetMinIdle(1)
+
+	ctx, cancel := context.WithCancel(context.TODO())
+	defer cancel()
+
+	lpm, err := New(ctx, config)
+	if !assert.NoError(t, err, "New should succeed") {
+		return
+	}
+
+	defer lpm.Shutdown(context.TODO())
+
+	if !assert.Equal(t, 4, lpm.IdleNum(), "idleNum mismatching") {
+		return
+	}
+
+	<-time.After(4500 * time.Millisecond)
+
+	if !assert.Equal(t, 1, lpm.IdleNum(), "idleNum mismatching") {
+		return
+	}
+
+	if !assert.Equal(t, 1, lpm.Len(), "length mismatching") {
+		return
+	}
+}
+
+func TestMinMaxIdle(t *testing.T) {
+	config, err := NewConfig(10, 1, 0, 120, "1h")
+	if !assert.NoError(t, err, "NewConfig should succeed") {
+		return
+	}
+
+	config.SetMinIdle(3)
+	config.SetMaxIdle(4)
+
+	ctx, cancel := context.WithCancel(context.TODO())
+	defer cancel()
+
+	lpm, err := New(ctx, config)
+	if !assert.NoError(t, err, "New should succeed") {
+		return
+	}
+
+	defer lpm.Shutdown(context.TODO())
+
+	if !assert.Equal(t, 3, lpm.IdleNum(), "idleNum mismatching") {
+		return
+	}
+
+	lss := make([]*lState, 0, 6)
+	for i := 0; i < 6; i++ {
+		ls, err := lpm.get(ctx)
+		if !assert.NoError(t, err, "get should succeed") {
+			return
+		}
+
+		lss = append(lss, ls)
+	}
+
+	if !assert.Equal(t, 6, lpm.Len(), "length mismatching") {
+		return
+	}
+
+	for _, ls := range lss {
+		lpm.put(ls)
+	}
+
+	if !assert.Equal(t, 4, lpm.IdleNum(), "idleNum mismatching") {
+		return
+	}
+
+	if !assert.Equal(t, 4, lpm.Len(), "length mismatching") {
+		return
+	}
+}
+
+func TestMaxRequest(t *testing.T) {
+	config, err := NewConfig(1, 1, 2, 120, "1h")
+	if !assert.NoError(t, err, "NewConfig should succeed") {
+		return
+	}
+
+	ctx, cancel := context.WithCancel(context.TODO())
+	defer cancel()
+
+	lpm, err := New(ctx, config)
+	if !assert.NoError(t, err, "New should succeed") {
+		return
+	}
+
+	defer lpm.Shutdown(context.TODO())
+
+	for i := 0; i < 5; i++ {
+		_, err = lpm.DoString(ctx, `return true`)
+		if !assert.NoError(t, err, "DoString should succeed") {
+			return
+		}
+	}
+
+	if !assert.Equal(t, 5, lpm.TotalRequestedNum(), "totalRequestedNum mismatching") {
+		return
+	}
+
+	if !assert.Equal(t, 0, lpm.ServingNum(), "servingNum mismatching") {
+		return
+	}
+
+	if !assert.Equal(t, 1, lpm.Len(), "length mismatching") {
+		return
+	}
+}
+
+func TestWaitQueue(t *testing.T) {
+	config, err := NewConfig(1, 1, 0, 120, "1h")
+	if !assert.NoError(t, err, "NewConfig should succeed") {
+		return
+	}
+
+	config.SetMaxWaitNum(2)
+
+	ctx, cancel := context.WithCancel(context.TODO())
+	defer cancel()
+
+	lpm, err := New(ctx, config)
+	if !assert.NoError(t, err, "New should succeed") {
+		return
+	}
+
+	defer lpm.Shutdown(context.TODO())
+
+	ls, err := lpm.get(ctx)
+	if !assert.NoError(t, err, "get should succeed") {
+		return
+	}
+
+	tctx, tcancel := context.WithTimeout(ctx, 100*time.Millisecond)
+	defer tcancel()
+
+	_, err = lpm.get(tctx)
+	if !assert.Equal(t, context.DeadlineExceeded, err, "get should be timeout") {
+		return
+	}
+
+	if !assert.Equal(t, 0, lpm.WaitingNum(), "waitingNum mismatching") {
+		return
+	}
+
+	order := make(chan int, 2)
+	for i := 0; i < 2; i++ {
+		go func(i int) {
+			ls, err := lpm.get(ctx)
+			if !assert.NoError(t, err, "get should succeed") {
+				return
+			}
+
+			order <- i
+			lpm.put(ls)
+		}(i)
+
+		for lpm.WaitingNum() != i+1 {
+			time.Sleep(time.Millisecond)
+		}
+	}
+
+	_, err = lpm.get(ctx)
+	if !assert.Equal(t, ErrLSPFulled, err, "get should failed") {
+		return
+	}
+
+	lpm.put(ls)
+
+	for i := 0; i < 2; i++ {
+		if !assert.Equal(t, i, <-order, "order mismatching") {
+			return
+		}
+	}
+
+	if !assert.Equal(t, 1, lpm.Len(), "length mismatching") {
+		return
+	}
+}
+
+func TestShutdown(t *testing.T) {
+	config, err := NewConfig(2, 2, 0, 0, "1h")
+	if !assert.NoError(t, err, "NewConfig should succeed") {
+		return
+	}
+
+	ctx, cancel := context.WithCancel(context.TODO())
+	defer cancel()
+
+	lpm, err := New(ctx, config)
+	if !assert.NoError(t, err, "New should succeed") {
+		return
+	}
+
+	errs := make(chan error, 2)
+	go func() {
+		_, err := lpm.DoString(ctx, `
+		local t0 = os.clock()
+		while os.clock() - t0 <= 0.2 do end
+		`)
+		errs <- err
+	}()
+
+	go func() {
+		_, err := lpm.DoString(ctx, `while true do end`)
+		errs <- err
+	}()
+
+	for lpm.ServingNum() != 2 {
+		time.Sleep(time.Millisecond)
+	}
+
+	sctx, scancel := context.WithTimeout(ctx, 1*time.Second)
+	defer scancel()
+
+	report, err := lpm.Shutdown(sctx)
+	if !assert.Equal(t, context.DeadlineExceeded, err, "Shutdown should be timeout") {
+		return
+	}
+
+	if !assert.Equal(t, ShutdownReport{Drained: 1, Killed: 1}, report, "report mismatching") {
+		return
+	}
+
+	if !assert.Equal(t, "Dead", lpm.StatusString(), "status string mismatching") {
+		return
+	}
+
+	// the killed request is unwound when Shutdown returns.
+	if !assert.Equal(t, 0, lpm.ServingNum(), "servingNum mismatching") {
+		return
+	}
+
+	if !assert.NoError(t, <-errs, "DoString should succeed") {
+		return
+	}
+
+	if !assert.Equal(t, ErrLSKilled, <-errs, "DoString should be killed") {
+		return
+	}
+
+	if !assert.Equal(t, 0, lpm.ServingNum(), "servingNum mismatching") {
+		return
+	}
+
+	if !assert.Equal(t, 0, lpm.Len(), "length mismatching") {
+		return
+	}
+
+	_, err = lpm.DoString(ctx, `return true`)
+	if !assert.Equal(t, ErrLSPDead, err, "DoString should failed") {
+		return
+	}
+
+	report, err = lpm.Restart(ctx)
+	if !assert.NoError(t, err, "Restart should succeed") {
+		return
+	}
+
+	if !assert.Equal(t, ShutdownReport{}, report, "report mismatching") {
+		return
+	}
+
+	if !assert.Equal(t, "Running", lpm.StatusString(), "status string mismatching") {
+		return
+	}
+
+	if !assert.Equal(t, 2, lpm.Len(), "length mismatching") {
+		return
+	}
+
+	_, err = lpm.DoString(ctx, `return true`)
+	if !assert.NoError(t, err, "DoString should succeed") {
+		return
+	}
+
+	report, err = lpm.Shutdown(ctx)
+	if !assert.NoError(t, err, "Shutdown should succeed") {
+		return
+	}
+
+	if !assert.Equal(t, 0, lpm.Len(), "length mismatching") {
+		return
+	}
+}
+
+func TestIsolation(t *testing.T) {
+	for _, isolation := range []Isolation{IsolateEnv, IsolateReset, IsolateEnv | IsolateReset} {
+		config, err := NewConfig(1, 1, 0, 0, "1h")
+		if !assert.NoError(t, err, "NewConfig should succeed") {
+			return
+		}
+
+		config.SetIsolation(isolation)
+
+		ctx, cancel := context.WithCancel(context.TODO())
+		defer cancel()
+
+		lpm, err := New(ctx, config, func(L *lua.LState) error {
+			L.SetGlobal("shared", lua.LString("gola"))
+
+			return nil
+		})
+		if !assert.NoError(t, err, "New should succeed") {
+			return
+		}
+
+		defer lpm.Shutdown(context.TODO())
+
+		_, err = lpm.DoString(ctx, `
+		leaked = 1
+		shared = nil
+		function fn() return leaked end
+		`)
+		if !assert.NoError(t, err, "DoString should succeed") {
+			return
+		}
+
+		lv, err := lpm.DoString(ctx, `return {leaked, shared}`, func(L *lua.LState) (lua.LValue, error) {
+			return L.Get(-1), nil
+		})
+		if !assert.NoError(t, err, "DoString should succeed") {
+			return
+		}
+
+		tbl := lv.(*lua.LTable)
+		if !assert.Equal(t, lua.LNil, tbl.RawGetInt(1), "global leaked") {
+			return
+		}
+
+		if !assert.Equal(t, lua.LString("gola"), tbl.RawGetInt(2), "global not restored") {
+			return
+		}
+
+		_, err = lpm.Call(ctx, "fn")
+		if !assert.Equal(t, ErrLSFuncNotFound, err, "Call should failed") {
+			return
+		}
+
+		_, err = lpm.DoString(ctx, `
+		string.upper = function() return "pwned" end
+		string.evil = 1
+		package.loaded.evil = 1
+		package.loaded._G.evil = 1
+		getmetatable("").__index = {}
+		setmetatable(table, {__index = function() return "pwned" end})
+		`)
+		if !assert.NoError(t, err, "DoString should succeed") {
+			return
+		}
+
+		lv, err = lpm.DoString(ctx, `
+		return {string.upper("a"), string.evil, package.loaded.evil, evil, ("a"):upper(), table.evil}
+		`, func(L *lua.LState) (lua.LValue, error) {
+			return L.Get(-1), nil
+		})
+		if !assert.NoError(t, err, "DoString should succeed") {
+			return
+		}
+
+		tbl = lv.(*lua.LTable)
+		if !assert.Equal(t, lua.LString("A"), tbl.RawGetInt(1), "string library not restored") {
+			return
+		}
+
+		for i := 2; i <= 4; i++ {
+			if !assert.Equal(t, lua.LNil, tbl.RawGetInt(i), "field leaked") {
+				return
+			}
+		}
+
+		if !assert.Equal(t, lua.LString("A"), tbl.RawGetInt(5), "string metatable not restored") {
+			return
+		}
+
+		if !assert.Equal(t, lua.LNil, tbl.RawGetInt(6), "metatable not restored") {
+			return
+		}
+	}
+}
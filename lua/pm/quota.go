@@ -0,0 +1,521 @@
+// (c) 2018, Jeff Chen <jefurry@qq.com>
+//
+// This file is part of Gola
+//
+// Copyright 2018 The Gola Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package pm
+
+import (
+	"context"
+	"fmt"
+	"github.com/pkg/errors"
+	"github.com/yuin/gopher-lua"
+)
+
+const (
+	QuotaInstructions Quota = iota + 1
+	QuotaMemory
+	QuotaCallStack
+	QuotaRegistry
+)
+
+const (
+	// the number of instructions between two samples of memory used by the serving request.
+	memorySampleInterval = 8
+
+	// the minimum number of instructions between two walks of values reachable
+	// from the serving request, the interval grows with the size of values.
+	memoryWalkInterval = 1024
+
+	// approximate size (in bytes) of a lua value in tables.
+	valueSize = 16
+
+	// the registers reserved for a single instruction, i.e. the registers of
+	// a called function (at most 200) and the values pushed by go functions.
+	registryHeadroom = 256
+
+	// the call frames reserved for a single instruction, i.e. a go function
+	// and the lua function called back by it.
+	callStackHeadroom = 2
+)
+
+type (
+	// Quota is the kind of resource limited per request.
+	Quota int
+
+	// QuotaError is returned when a request exceeded a quota,
+	// the lua state served the request is recycled.
+	QuotaError struct {
+		Quota Quota
+		Limit int
+	}
+
+	// quota accounts resources used by the serving request of lua state.
+	// It wraps the context of lua state, since the VM of gopher-lua checks
+	// the context once before each instruction.
+	// Note: Done updates the counters without synchronization, since it is called
+	// only by the VM of lua state, so a quota must never be shared with other lua
+	// states or goroutines. The requests running with contexts derived from it,
+	// like coroutines, are not counted.
+	quota struct {
+		context.Context
+
+		L               *lua.LState
+		maxInstructions int
+		maxMemory       int
+		callStackSize   int
+		registrySize    int
+
+		instructions int
+		memory       int
+		exceeded     *QuotaError
+
+		// size of values reachable from lua state before the serving request,
+		// the size of values reachable by the last walk minus it, and the
+		// instructions of the next walk.
+		baseline int
+		live     int
+		nextWalk int
+
+		// instructions of the next check of call stack and registry.
+		nextStack int
+	}
+)
+
+var quotaStrings = map[Quota]string{
+	QuotaInstructions: "instructions",
+	QuotaMemory:       "memory",
+	QuotaCallStack:    "call stack",
+	QuotaRegistry:     "registry",
+}
+
+// closed channel returned by Done of quota once exceeded.
+var quotaDone = make(chan struct{})
+
+func init() {
+	close(quotaDone)
+}
+
+func (q Quota) String() string {
+	if s, ok := quotaStrings[q]; ok {
+		return s
+	}
+
+	return "unknown"
+}
+
+func (e *QuotaError) Error() string {
+	return fmt.Sprintf("lua state %s quota exceeded (limit %d)", e.Quota, e.Limit)
+}
+
+func newQuota(L *lua.LState, maxInstructions, maxMemory, callStackSize, registrySize int) *quota {
+	q := &quota{
+		Context:         L.Context(),
+		L:               L,
+		maxInstructions: maxInstructions,
+		maxMemory:       maxMemory,
+		callStackSize:   callStackSize,
+		registrySize:    registrySize,
+	}
+
+	L.SetContext(q)
+	q.wrapRegistry()
+	if maxMemory > 0 {
+		q.wrapLibs()
+	}
+
+	return q
+}
+
+// Done counts the instructions executed by the serving request, checks the
+// call stack and the registry before they overflow, and samples the memory used by it.
+func (q *quota) Done() <-chan struct{} {
+	if q.exceeded == nil {
+		q.instructions += 1
+
+		if q.maxInstructions > 0 && q.instructions > q.maxInstructions {
+			q.exceed(QuotaInstructions, q.maxInstructions)
+		} else if q.instructions >= q.nextStack {
+			q.checkStack()
+		} else if q.maxMemory > 0 && q.instructions >= q.nextWalk {
+			q.walk()
+		} else if q.maxMemory > 0 && q.instructions%memorySampleInterval == 0 {
+			q.sample()
+		}
+	}
+
+	if q.exceeded != nil {
+		return quotaDone
+	}
+
+	return q.Context.Done()
+}
+
+func (q *quota) Err() error {
+	if q.exceeded != nil {
+		return q.exceeded
+	}
+
+	return q.Context.Err()
+}
+
+// reset resets the resources used for a new request.
+// Note: The environment of request must be set before, the values reachable
+// from it are not charged.
+func (q *quota) reset() {
+	q.instructions = 0
+	q.memory = 0
+	q.exceeded = nil
+	q.nextStack = 0
+
+	if q.maxMemory > 0 {
+		q.baseline = reachable(q.L, -1)
+		q.live = 0
+		q.nextWalk = memoryWalkInterval
+	}
+}
+
+func (q *quota) exceed(kind Quota, limit int) {
+	q.exceeded = &QuotaError{Quota: kind, Limit: limit}
+}
+
+// charge adds n bytes allocated by the serving request,
+// it raises lua error if the memory quota exceeded.
+func (q *quota) charge(L *lua.LState, n int) {
+	if n > q.maxMemory-q.memory-q.live {
+		q.exceed(QuotaMemory, q.maxMemory)
+		L.RaiseError("%s", q.exceeded.Error())
+	}
+
+	q.memory += n
+}
+
+// sample checks the strings and tables held by registers of the running function.
+// Note: Only the array part of tables is counted, the hash part is counted by walk.
+func (q *quota) sample() {
+	L := q.L
+
+	n := 0
+	for i := 1; i <= L.GetTop(); i++ {
+		n += sizeOf(L.Get(i))
+	}
+
+	if q.memory+q.live+n > q.maxMemory {
+		q.exceed(QuotaMemory, q.maxMemory)
+	}
+}
+
+// walk checks the values reachable from the environment and the stack of
+// serving request, the next walk is delayed by the size of values, so that
+// the cost of walks is proportional to the instructions executed.
+func (q *quota) walk() {
+	limit := q.baseline + q.maxMemory - q.memory
+	n := reachable(q.L, limit)
+	if n > limit {
+		q.exceed(QuotaMemory, q.maxMemory)
+
+		return
+	}
+
+	q.live = n - q.baseline
+	if q.live < 0 {
+		q.live = 0
+	}
+
+	interval := n / valueSize
+	if interval < memoryWalkInterval {
+		interval = memoryWalkInterval
+	}
+	q.nextWalk = q.instructions + interval
+}
+
+// checkStack checks the call stack and the registry used by the serving request,
+// the next check is delayed by the frames and registers left, since a single
+// instruction pushes at most `callStackHeadroom` frames and `registryHeadroom` registers.
+func (q *quota) checkStack() {
+	depth, top := q.stack()
+	if depth+callStackHeadroom > q.callStackSize {
+		q.exceed(QuotaCallStack, q.callStackSize)
+
+		return
+	}
+
+	if top+registryHeadroom > q.registrySize {
+		q.exceed(QuotaRegistry, q.registrySize)
+
+		return
+	}
+
+	interval := (q.callStackSize - depth) / callStackHeadroom
+	if n := (q.registrySize - top) / registryHeadroom; n < interval {
+		interval = n
+	}
+	q.nextStack = q.instructions + interval
+}
+
+// stack returns the number of call frames of lua state and the approximate
+// number of registers used by them, i.e. the registers of the running function
+// and the registers reserved by the prototypes of the other lua functions.
+// Note: The variable arguments are not visible by the api of gopher-lua, the
+// registers of the running vararg function are doubled for the arguments copied by `...`,
+// but the variable arguments of the other functions are not counted.
+func (q *quota) stack() (int, int) {
+	L := q.L
+
+	top := 0
+	depth := frames(L, q.callStackSize, func(level int, dbg *lua.Debug) {
+		lv, _ := L.GetInfo("f", dbg, lua.LNil)
+		fn, ok := lv.(*lua.LFunction)
+		if !ok {
+			return
+		}
+
+		if level == 0 {
+			top += L.GetTop()
+			if !fn.IsG && fn.Proto.IsVarArg&lua.VarArgIsVarArg != 0 {
+				top += L.GetTop()
+			}
+
+			return
+		}
+
+		// the function itself and the registers of its frame.
+		top += 1
+		if !fn.IsG {
+			top += int(fn.Proto.NumUsedRegisters)
+
+			return
+		}
+
+		for i := 1; ; i++ {
+			if name, _ := L.GetLocal(dbg, i); name == "" {
+				break
+			}
+			top += 1
+		}
+	})
+
+	return depth, top
+}
+
+// wrapRegistry checks the registry before go functions of libraries pushing
+// arbitrary number of values, since they are called by a single instruction.
+func (q *quota) wrapRegistry() {
+	L := q.L
+
+	check := func(L *lua.LState, n int) {
+		if _, top := q.stack(); n > q.registrySize-top-registryHeadroom {
+			q.exceed(QuotaRegistry, q.registrySize)
+			L.RaiseError("%s", q.exceeded.Error())
+		}
+	}
+
+	q.wrapFunc(L.G.Global, "unpack", func(L *lua.LState) {
+		tbl := L.CheckTable(1)
+		check(L, L.OptInt(3, tbl.Len())-L.OptInt(2, 1)+1)
+	})
+
+	if tbl, ok := L.GetGlobal(lua.StringLibName).(*lua.LTable); ok {
+		// the number of bytes returned as string.byte of gopher-lua, whose `j` is -1 by default.
+		q.wrapFunc(tbl, "byte", func(L *lua.LState) {
+			s := L.CheckString(1)
+			if L.GetTop() == 2 {
+				return
+			}
+
+			i, j := L.OptInt(2, 1), L.OptInt(3, -1)
+			if i < 0 {
+				i = len(s) + i + 1
+			}
+
+			if j < 0 {
+				j = len(s) + j + 1
+			}
+
+			if i < 1 {
+				i = 1
+			}
+
+			if j > len(s) {
+				j = len(s)
+			}
+			check(L, j-i+1)
+		})
+	}
+}
+
+// wrapLibs charges the strings allocated by library functions.
+func (q *quota) wrapLibs() {
+	L := q.L
+
+	if tbl, ok := L.GetGlobal(lua.StringLibName).(*lua.LTable); ok {
+		// checks before allocating the repeated string.
+		q.wrapFunc(tbl, "rep", func(L *lua.LState) {
+			s, n := L.CheckString(1), L.OptInt(2, 0)
+			if len(s) > 0 && n > (q.maxMemory-q.memory-q.live)/len(s) {
+				q.exceed(QuotaMemory, q.maxMemory)
+				L.RaiseError("%s", q.exceeded.Error())
+			}
+		})
+
+		for _, name := range []string{"char", "format", "gsub", "lower", "reverse", "upper"} {
+			q.wrapFunc(tbl, name, nil)
+		}
+	}
+
+	if tbl, ok := L.GetGlobal(lua.TabLibName).(*lua.LTable); ok {
+		q.wrapFunc(tbl, "concat", nil)
+	}
+}
+
+// wrapFunc replaces the go function of library with a wrapper which calls check
+// before calling it, and charges the results after if the memory is limited.
+func (q *quota) wrapFunc(tbl *lua.LTable, name string, check func(*lua.LState)) {
+	fn, ok := tbl.RawGetString(name).(*lua.LFunction)
+	if !ok || !fn.IsG {
+		return
+	}
+
+	gfn := fn.GFunction
+	tbl.RawSetString(name, q.L.NewFunction(func(L *lua.LState) int {
+		if check != nil {
+			check(L)
+		}
+
+		n := gfn(L)
+		if q.maxMemory <= 0 {
+			return n
+		}
+
+		for i := L.GetTop() - n + 1; i <= L.GetTop(); i++ {
+			if s, ok := L.Get(i).(lua.LString); ok {
+				q.charge(L, len(s))
+			}
+		}
+
+		return n
+	}))
+}
+
+// quotaExceeded returns the quota exceeded by the served request.
+func (ls *lState) quotaExceeded(err error) *QuotaError {
+	if ls.quota == nil {
+		return nil
+	}
+
+	if ls.quota.exceeded != nil {
+		return ls.quota.exceeded
+	}
+
+	if qerr, ok := errors.Cause(err).(*QuotaError); ok {
+		return qerr
+	}
+
+	return nil
+}
+
+// sizeOf returns the approximate size (in bytes) of lua value.
+func sizeOf(lv lua.LValue) int {
+	switch v := lv.(type) {
+	case lua.LString:
+		return len(v)
+	case *lua.LTable:
+		return v.Len() * valueSize
+	}
+
+	return 0
+}
+
+// reachable returns the approximate size (in bytes) of values reachable from
+// the environment, the locals of call stack and the registers of the running
+// function of L, it stops once the size exceeded limit if limit is not negative.
+// Note: Metatables are not walked, so that globals of isolated environment are
+// not counted.
+func reachable(L *lua.LState, limit int) int {
+	seen := make(map[lua.LValue]struct{})
+	stack := []lua.LValue{L.Env}
+
+	frames(L, L.Options.CallStackSize, func(level int, dbg *lua.Debug) {
+		for i := 1; ; i++ {
+			name, lv := L.GetLocal(dbg, i)
+			if name == "" {
+				break
+			}
+			stack = append(stack, lv)
+		}
+	})
+
+	for i := 1; i <= L.GetTop(); i++ {
+		stack = append(stack, L.Get(i))
+	}
+
+	n := 0
+	for len(stack) > 0 && (limit < 0 || n <= limit) {
+		lv := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+
+		switch v := lv.(type) {
+		case lua.LString:
+			if _, ok := seen[v]; !ok {
+				seen[v] = struct{}{}
+				n += len(v)
+			}
+		case *lua.LTable:
+			if _, ok := seen[v]; ok {
+				continue
+			}
+			seen[v] = struct{}{}
+
+			v.ForEach(func(k, val lua.LValue) {
+				n += 2 * valueSize
+				stack = append(stack, k, val)
+			})
+		case *lua.LFunction:
+			if _, ok := seen[v]; ok || v.IsG {
+				continue
+			}
+			seen[v] = struct{}{}
+
+			for _, uv := range v.Upvalues {
+				if uv != nil {
+					stack = append(stack, uv.Value())
+				}
+			}
+		}
+	}
+
+	return n
+}
+
+// frames calls fn with the call frames of L from the running function, it
+// returns the number of frames visited, at most max levels of L are visited.
+// Note: GetStack of gopher-lua returns the bottom frame for the levels of tail
+// calls, so the bottom frame is visited once, and the frames after max levels
+// are not visited.
+func frames(L *lua.LState, max int, fn func(level int, dbg *lua.Debug)) int {
+	bottom, _ := L.GetStack(-1)
+
+	n := 0
+	visited := false
+	for level := 0; level < max; level++ {
+		dbg, ok := L.GetStack(level)
+		if !ok {
+			break
+		}
+
+		if *dbg == *bottom {
+			if visited {
+				continue
+			}
+			visited = true
+		}
+
+		fn(n, dbg)
+		n += 1
+	}
+
+	return n
+}
@@ -0,0 +1,170 @@
+// (c) 2018, Jeff Chen <jefurry@qq.com>
+//
+// This file is part of Gola
+//
+// Copyright 2018 The Gola Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package pm
+
+import (
+	"context"
+	"github.com/jefurry/gola/core/event"
+	"github.com/stretchr/testify/assert"
+	"github.com/yuin/gopher-lua"
+	"testing"
+)
+
+func TestQuota(t *testing.T) {
+	config, err := NewConfig(1, 1, 0, 0, "1h")
+	if !assert.NoError(t, err, "NewConfig should succeed") {
+		return
+	}
+
+	config.SetMaxInstructions(1000000)
+	config.SetMaxMemory(1 << 20)
+	config.SetCallStackSize(64)
+
+	ctx, cancel := context.WithCancel(context.TODO())
+	defer cancel()
+
+	lpm, err := New(ctx, config)
+	if !assert.NoError(t, err, "New should succeed") {
+		return
+	}
+
+	defer lpm.Shutdown(context.TODO())
+
+	events := make([]string, 0)
+	lpm.On(EventQuota, func(evt *event.Event) bool {
+		events = append(events, evt.Data.(*LSEvent).Err.(*QuotaError).Quota.String())
+
+		return true
+	})
+
+	for _, v := range []struct {
+		code  string
+		quota Quota
+		limit int
+	}{
+		{`while true do end`, QuotaInstructions, 1000000},
+		{`local s = string.rep("x", 1e12)`, QuotaMemory, 1 << 20},
+		{`local s = "x" for i = 1, 30 do s = s .. s end`, QuotaMemory, 1 << 20},
+		{`local t = {} for i = 1, 4000 do t[i] = string.format("%0256d", i) end`, QuotaMemory, 1 << 20},
+		{`local t = {} for i = 1, 1e7 do t["k" .. i] = i end`, QuotaMemory, 1 << 20},
+		{`t = {} for i = 1, 1e7 do t[i .. ""] = {} end`, QuotaMemory, 1 << 20},
+		{`local function f() return 1 + f() end f()`, QuotaCallStack, 64},
+	} {
+		_, err := lpm.DoString(ctx, v.code)
+		if !assert.Equal(t, &QuotaError{Quota: v.quota, Limit: v.limit}, err, "error mismatching") {
+			return
+		}
+
+		// the offending lua state is recycled.
+		if !assert.Equal(t, 0, lpm.Len(), "length mismatching") {
+			return
+		}
+	}
+
+	config, err = NewConfig(1, 1, 0, 0, "1h")
+	if !assert.NoError(t, err, "NewConfig should succeed") {
+		return
+	}
+
+	config.SetRegistrySize(1024)
+
+	rlpm, err := New(ctx, config)
+	if !assert.NoError(t, err, "New should succeed") {
+		return
+	}
+
+	defer rlpm.Shutdown(context.TODO())
+
+	for _, code := range []string{
+		`local t = {} for i = 1, 1000 do t[i] = i end return unpack(t)`,
+		`local function f(...) return f(1, ...) end return f()`,
+		`return string.byte(string.rep("x", 1000), 1, -1)`,
+	} {
+		_, err := rlpm.DoString(ctx, code)
+		if !assert.Equal(t, &QuotaError{Quota: QuotaRegistry, Limit: 1024}, err, "error mismatching") {
+			return
+		}
+	}
+
+	for _, code := range []string{
+		`return select("#", unpack({1, 2, 3}))`,
+		`return select("#", string.byte(string.rep("x", 10000), 1, 3))`,
+		`local function loop(n) if n > 0 then return loop(n - 1) end return 3 end return loop(10000)`,
+	} {
+		lv, err := rlpm.DoString(ctx, code, func(L *lua.LState) (lua.LValue, error) {
+			return L.Get(-1), nil
+		})
+		if !assert.NoError(t, err, "DoString should succeed") {
+			return
+		}
+
+		if !assert.Equal(t, lua.LNumber(3), lv, "value mismatching") {
+			return
+		}
+	}
+
+	// quotas are reset per request.
+	for i := 0; i < 3; i++ {
+		lv, err := lpm.DoString(ctx, `
+		local n = 0
+		for i = 1, 1000 do n = n + #string.rep("x", 500) end
+		return n
+		`, func(L *lua.LState) (lua.LValue, error) {
+			return L.Get(-1), nil
+		})
+		if !assert.NoError(t, err, "DoString should succeed") {
+			return
+		}
+
+		if !assert.Equal(t, lua.LNumber(500000), lv, "value mismatching") {
+			return
+		}
+	}
+
+	if !assert.Equal(t, []string{"instructions", "memory", "memory", "memory", "memory", "memory", "call stack"}, events, "events mismatching") {
+		return
+	}
+
+	if !assert.Equal(t, 7, lpm.Stats().QuotaExceeded, "stats mismatching") {
+		return
+	}
+}
+
+func TestQuotaNone(t *testing.T) {
+	config, err := NewConfig(1, 1, 0, 0, "1h")
+	if !assert.NoError(t, err, "NewConfig should succeed") {
+		return
+	}
+
+	ctx, cancel := context.WithCancel(context.TODO())
+	defer cancel()
+
+	lpm, err := New(ctx, config)
+	if !assert.NoError(t, err, "New should succeed") {
+		return
+	}
+
+	defer lpm.Shutdown(context.TODO())
+
+	lv, err := lpm.DoString(ctx, `local s = string.rep("x", 10000) return string.byte(s, 1)`, func(L *lua.LState) (lua.LValue, error) {
+		// no quota is installed without limits.
+		if _, ok := L.Context().(*quota); !assert.False(t, ok, "quota installed") {
+			return lua.LNil, nil
+		}
+
+		return L.Get(-1), nil
+	})
+	if !assert.NoError(t, err, "DoString should succeed") {
+		return
+	}
+
+	if !assert.Equal(t, lua.LNumber('x'), lv, "value mismatching") {
+		return
+	}
+}
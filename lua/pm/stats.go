@@ -0,0 +1,240 @@
+// (c) 2018, Jeff Chen <jefurry@qq.com>
+//
+// This file is part of Gola
+//
+// Copyright 2018 The Gola Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package pm
+
+import (
+	"sync"
+	"time"
+)
+
+// DefaultBuckets is the upper bounds (in seconds) of wait time and execution time histograms.
+// Note: The bounds are copied when histograms are created, the changes after that are not
+// seen by the pools already created.
+var DefaultBuckets = []float64{0.001, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+type (
+	// Histogram is a snapshot of durations distribution.
+	Histogram struct {
+		// Upper bounds (in seconds) of buckets.
+		Buckets []float64
+		// Cumulative counts of durations less than or equal to the upper bound of buckets.
+		Counts []int
+		// Total number of durations.
+		Count int
+		// Sum (in seconds) of durations.
+		Sum float64
+	}
+
+	// Stats is a snapshot of pool statistics.
+	Stats struct {
+		Status string
+
+		// The number of lua states in pool.
+		Len     int
+		Idle    int
+		Busy    int
+		Waiting int
+
+		// The number of lua states created and destroyed since pool created.
+		Created   int
+		Destroyed int
+
+		// The number of requests served.
+		Requests int
+		// The number of requests not served, e.g. pool fulled or exiting.
+		Rejected int
+		// The number of requests failed with error.
+		Errors int
+		// The number of requests terminated by `requestTerminateTimeout`.
+		Timeouts int
+		// The number of requests killed by shutdown.
+		Killed int
+		// The number of requests exceeded quotas.
+		QuotaExceeded int
+
+		// Time waited for an available lua state.
+		WaitTime Histogram
+		// Time executing requests.
+		ExecTime Histogram
+
+		// The number of requests per script, keyed by file path, chunk name,
+		// function name or `<string>` followed by sha1 of the source string.
+		// Note: Each distinct source string has its own key.
+		Scripts map[string]int
+	}
+
+	histogram struct {
+		// upper bounds of buckets, copied from DefaultBuckets.
+		buckets []float64
+		counts  []int
+		count   int
+		sum     float64
+	}
+
+	metrics struct {
+		lock sync.Mutex
+
+		created   int
+		destroyed int
+		requests  int
+		rejected  int
+		errors    int
+		timeouts  int
+		killed    int
+		quotas    int
+
+		waitTime *histogram
+		execTime *histogram
+
+		scripts map[string]int
+	}
+)
+
+func newHistogram() *histogram {
+	buckets := make([]float64, len(DefaultBuckets))
+	copy(buckets, DefaultBuckets)
+
+	return &histogram{
+		buckets: buckets,
+		counts:  make([]int, len(buckets)),
+	}
+}
+
+func (h *histogram) observe(d time.Duration) {
+	sec := d.Seconds()
+	for i, b := range h.buckets {
+		if sec <= b {
+			h.counts[i] += 1
+
+			break
+		}
+	}
+
+	h.count += 1
+	h.sum += sec
+}
+
+func (h *histogram) snapshot() Histogram {
+	hist := Histogram{
+		Buckets: make([]float64, len(h.buckets)),
+		Counts:  make([]int, len(h.buckets)),
+		Count:   h.count,
+		Sum:     h.sum,
+	}
+
+	copy(hist.Buckets, h.buckets)
+
+	n := 0
+	for i, c := range h.counts {
+		n += c
+		hist.Counts[i] = n
+	}
+
+	return hist
+}
+
+func newMetrics() *metrics {
+	return &metrics{
+		waitTime: newHistogram(),
+		execTime: newHistogram(),
+		scripts:  make(map[string]int),
+	}
+}
+
+func (m *metrics) create() {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	m.created += 1
+}
+
+func (m *metrics) destroy() {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	m.destroyed += 1
+}
+
+func (m *metrics) reject() {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	m.rejected += 1
+}
+
+func (m *metrics) wait(d time.Duration) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	m.waitTime.observe(d)
+}
+
+func (m *metrics) exec(name string, d time.Duration, err error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	m.requests += 1
+	m.scripts[name] += 1
+	m.execTime.observe(d)
+
+	if _, ok := err.(*QuotaError); ok {
+		m.quotas += 1
+
+		return
+	}
+
+	switch err {
+	case nil:
+	case ErrLSTimeout:
+		m.timeouts += 1
+	case ErrLSKilled:
+		m.killed += 1
+	default:
+		m.errors += 1
+	}
+}
+
+func (m *metrics) snapshot(stats *Stats) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	stats.Created = m.created
+	stats.Destroyed = m.destroyed
+	stats.Requests = m.requests
+	stats.Rejected = m.rejected
+	stats.Errors = m.errors
+	stats.Timeouts = m.timeouts
+	stats.Killed = m.killed
+	stats.QuotaExceeded = m.quotas
+	stats.WaitTime = m.waitTime.snapshot()
+	stats.ExecTime = m.execTime.snapshot()
+
+	stats.Scripts = make(map[string]int, len(m.scripts))
+	for k, v := range m.scripts {
+		stats.Scripts[k] = v
+	}
+}
+
+// Stats returns a snapshot of pool statistics.
+func (lpm *LPM) Stats() Stats {
+	stats := Stats{
+		Status: lpm.StatusString(),
+	}
+
+	lpm.lock.Lock()
+	stats.Len = lpm.length
+	stats.Idle = len(lpm.lss)
+	stats.Busy = lpm.servingNum
+	stats.Waiting = lpm.waiters.Len()
+	lpm.lock.Unlock()
+
+	lpm.metrics.snapshot(&stats)
+
+	return stats
+}
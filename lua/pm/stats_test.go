@@ -0,0 +1,149 @@
+// (c) 2018, Jeff Chen <jefurry@qq.com>
+//
+// This file is part of Gola
+//
+// Copyright 2018 The Gola Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package pm
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestHistogram(t *testing.T) {
+	h := newHistogram()
+	h.observe(500 * time.Microsecond)
+	h.observe(3 * time.Millisecond)
+	h.observe(3 * time.Millisecond)
+	h.observe(time.Minute)
+
+	hist := h.snapshot()
+	if !assert.Equal(t, DefaultBuckets, hist.Buckets, "buckets mismatching") {
+		return
+	}
+
+	if !assert.Equal(t, []int{1, 3, 3, 3, 3, 3, 3, 3, 3, 3, 3, 3}, hist.Counts, "counts mismatching") {
+		return
+	}
+
+	if !assert.Equal(t, 4, hist.Count, "count mismatching") {
+		return
+	}
+
+	if !assert.InDelta(t, 60.0065, hist.Sum, 1e-9, "sum mismatching") {
+		return
+	}
+
+	// the bounds changed after created are not seen by histogram.
+	buckets := DefaultBuckets
+	defer func() {
+		DefaultBuckets = buckets
+	}()
+
+	DefaultBuckets = append(DefaultBuckets[:len(DefaultBuckets):len(DefaultBuckets)], 30)
+	h.observe(20 * time.Second)
+
+	if !assert.Len(t, h.snapshot().Buckets, len(buckets), "length of buckets mismatching") {
+		return
+	}
+}
+
+func TestStats(t *testing.T) {
+	config, err := NewConfig(1, 1, 0, 120, "1h")
+	if !assert.NoError(t, err, "NewConfig should succeed") {
+		return
+	}
+
+	ctx, cancel := context.WithCancel(context.TODO())
+	defer cancel()
+
+	lpm, err := New(ctx, config)
+	if !assert.NoError(t, err, "New should succeed") {
+		return
+	}
+
+	defer lpm.Shutdown(context.TODO())
+
+	for i := 0; i < 2; i++ {
+		_, err = lpm.DoString(ctx, `return true`)
+		if !assert.NoError(t, err, "DoString should succeed") {
+			return
+		}
+	}
+
+	_, err = lpm.DoString(ctx, `error("failed")`)
+	if !assert.Error(t, err, "DoString should failed") {
+		return
+	}
+
+	_, err = lpm.Call(ctx, "string.upper", "gola")
+	if !assert.NoError(t, err, "Call should succeed") {
+		return
+	}
+
+	ls, err := lpm.get(ctx)
+	if !assert.NoError(t, err, "get should succeed") {
+		return
+	}
+
+	_, err = lpm.DoString(ctx, `return true`)
+	if !assert.Equal(t, ErrLSPFulled, err, "DoString should failed") {
+		return
+	}
+
+	stats := lpm.Stats()
+	lpm.put(ls)
+
+	if !assert.Equal(t, "Running", stats.Status, "status mismatching") {
+		return
+	}
+
+	if !assert.Equal(t, 1, stats.Len, "length mismatching") {
+		return
+	}
+
+	if !assert.Equal(t, 0, stats.Idle, "idle mismatching") {
+		return
+	}
+
+	if !assert.Equal(t, 1, stats.Busy, "busy mismatching") {
+		return
+	}
+
+	if !assert.Equal(t, 2, stats.Created, "created mismatching") {
+		return
+	}
+
+	if !assert.Equal(t, 1, stats.Destroyed, "destroyed mismatching") {
+		return
+	}
+
+	if !assert.Equal(t, 4, stats.Requests, "requests mismatching") {
+		return
+	}
+
+	if !assert.Equal(t, 1, stats.Rejected, "rejected mismatching") {
+		return
+	}
+
+	if !assert.Equal(t, 1, stats.Errors, "errors mismatching") {
+		return
+	}
+
+	if !assert.Equal(t, 4, stats.WaitTime.Count, "wait time count mismatching") {
+		return
+	}
+
+	if !assert.Equal(t, 4, stats.ExecTime.Count, "exec time count mismatching") {
+		return
+	}
+
+	if !assert.Equal(t, map[string]int{stringKey(`return true`): 2, stringKey(`error("failed")`): 1, "string.upper": 1}, stats.Scripts, "scripts mismatching") {
+		return
+	}
+}
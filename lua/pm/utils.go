@@ -10,7 +10,9 @@ package pm
 
 import (
 	"bytes"
+	"github.com/yuin/gopher-lua"
 	"strconv"
+	"time"
 )
 
 func getMaxNum(maxNum int) int {
@@ -43,6 +45,70 @@ func getMaxRequest(maxRequest int) int {
 	return maxRequest
 }
 
+func getMinIdle(maxNum, minIdle int) int {
+	if minIdle < 0 {
+		return DefaultMinIdle
+	}
+
+	if maxNum > 0 && minIdle > maxNum {
+		return maxNum
+	}
+
+	return minIdle
+}
+
+func getMaxIdle(minIdle, maxIdle int) int {
+	if maxIdle < 0 {
+		return DefaultMaxIdle
+	}
+
+	if maxIdle > 0 && maxIdle < minIdle {
+		return minIdle
+	}
+
+	return maxIdle
+}
+
+func getMaxWaitNum(maxWaitNum int) int {
+	if maxWaitNum < 0 {
+		return DefaultMaxWaitNum
+	}
+
+	return maxWaitNum
+}
+
+func getMaxInstructions(maxInstructions int) int {
+	if maxInstructions < 0 {
+		return DefaultMaxInstructions
+	}
+
+	return maxInstructions
+}
+
+func getMaxMemory(maxMemory int) int {
+	if maxMemory < 0 {
+		return DefaultMaxMemory
+	}
+
+	return maxMemory
+}
+
+func getCallStackSize(options lua.Options) int {
+	if options.CallStackSize < 1 {
+		return lua.CallStackSize
+	}
+
+	return options.CallStackSize
+}
+
+func getRegistrySize(options lua.Options) int {
+	if options.RegistrySize < 128 {
+		return lua.RegistrySize
+	}
+
+	return options.RegistrySize
+}
+
 func getIdleTimeout(idleTimeout string) (int, string, error) {
 	it := []byte(idleTimeout)
 	l := len(it)
@@ -94,3 +160,17 @@ func getRequestTerminateTimeout(rtt int) int {
 
 	return rtt
 }
+
+// getReapInterval returns how often the reaper checks idle lua states.
+func getReapInterval(seconds int) time.Duration {
+	d := time.Duration(seconds) * time.Second / 2
+	if d <= 0 || d > maxReapInterval {
+		return maxReapInterval
+	}
+
+	if d < minReapInterval {
+		return minReapInterval
+	}
+
+	return d
+}
@@ -11,6 +11,7 @@ package pm
 import (
 	"github.com/stretchr/testify/assert"
 	"testing"
+	"time"
 )
 
 func TestGetMaxNum(t *testing.T) {
@@ -178,3 +179,67 @@ func TestGetIdleTimeoutSeconds(t *testing.T) {
 		return
 	}
 }
+
+func TestGetMinIdle(t *testing.T) {
+	if !assert.Equal(t, DefaultMinIdle, getMinIdle(10, -1), "getMinIdle mismatching") {
+		return
+	}
+
+	if !assert.Equal(t, 5, getMinIdle(10, 5), "getMinIdle mismatching") {
+		return
+	}
+
+	if !assert.Equal(t, 10, getMinIdle(10, 20), "getMinIdle mismatching") {
+		return
+	}
+
+	if !assert.Equal(t, 20, getMinIdle(0, 20), "getMinIdle mismatching") {
+		return
+	}
+}
+
+func TestGetMaxIdle(t *testing.T) {
+	if !assert.Equal(t, DefaultMaxIdle, getMaxIdle(0, -1), "getMaxIdle mismatching") {
+		return
+	}
+
+	if !assert.Equal(t, 0, getMaxIdle(5, 0), "getMaxIdle mismatching") {
+		return
+	}
+
+	if !assert.Equal(t, 5, getMaxIdle(5, 3), "getMaxIdle mismatching") {
+		return
+	}
+
+	if !assert.Equal(t, 8, getMaxIdle(5, 8), "getMaxIdle mismatching") {
+		return
+	}
+}
+
+func TestGetReapInterval(t *testing.T) {
+	if !assert.Equal(t, maxReapInterval, getReapInterval(0), "getReapInterval mismatching") {
+		return
+	}
+
+	if !assert.Equal(t, minReapInterval, getReapInterval(1), "getReapInterval mismatching") {
+		return
+	}
+
+	if !assert.Equal(t, 30*time.Second, getReapInterval(60), "getReapInterval mismatching") {
+		return
+	}
+
+	if !assert.Equal(t, maxReapInterval, getReapInterval(3600), "getReapInterval mismatching") {
+		return
+	}
+}
+
+func TestGetMaxWaitNum(t *testing.T) {
+	if !assert.Equal(t, DefaultMaxWaitNum, getMaxWaitNum(-1), "getMaxWaitNum mismatching") {
+		return
+	}
+
+	if !assert.Equal(t, 16, getMaxWaitNum(16), "getMaxWaitNum mismatching") {
+		return
+	}
+}
@@ -0,0 +1,970 @@
+// (c) 2018, Jeff Chen <jefurry@qq.com>
+//
+// This file is part of Gola
+//
+// Copyright 2018 The Gola Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package reng
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"fmt"
+	"github.com/jefurry/gola/lua/pm"
+	"github.com/pkg/errors"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Hit policies of decision table.
+const (
+	// Only the first matched row fires.
+	HitFirst HitPolicy = "first"
+	// At most one row matches, overlapped rows are rejected when loading.
+	HitUnique HitPolicy = "unique"
+	// Only the matched row with highest priority fires.
+	HitPriority HitPolicy = "priority"
+	// All matched rows fire, and their outputs are collected into arrays.
+	HitCollect HitPolicy = "collect"
+)
+
+// Kinds of decision table issue.
+const (
+	DecisionGap     = "gap"
+	DecisionOverlap = "overlap"
+)
+
+const (
+	// column prefixes of inputs and outputs.
+	whenPrefix = "when:"
+	thenPrefix = "then:"
+
+	nameColumn     = "name"
+	priorityColumn = "priority"
+
+	// the maximum number of issues reported by Validate.
+	maxDecisionIssues = 100
+
+	// the maximum number of combinations of inputs searched for gaps.
+	maxDecisionCombinations = 100000
+)
+
+var (
+	ErrDecisionHitPolicy = errors.New("unknown hit policy of decision table")
+	ErrDecisionColumn    = errors.New("invalid decision table column")
+	ErrDecisionNoOutput  = errors.New("decision table has no output column")
+	ErrDecisionCell      = errors.New("invalid decision table cell")
+	ErrDecisionOverlap   = errors.New("rows of unique decision table overlap")
+)
+
+var identRegexp = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+type (
+	HitPolicy string
+
+	// DecisionTable is a rule set maintained as a CSV table, each row is a rule.
+	// The header of table names the columns:
+	//
+	//	name       (optional) name of row, defaults to "row N".
+	//	priority   (required by HitPriority) priority of row, an integer.
+	//	when:FIELD input column, a condition on facts.FIELD.
+	//	then:FIELD output column, a value assigned to facts.FIELD.
+	//
+	// Condition cells are one of:
+	//
+	//	-, * or empty   any value.
+	//	10, US, "US"    equal to a number, a boolean or a string.
+	//	<10, >=10       compared with a number.
+	//	[1..10), 1..10  in a range of numbers, brackets are inclusive and
+	//	                parentheses are exclusive, bare ranges are inclusive.
+	//	US, CN, [1..5]  in a set of the above, separated by comma.
+	//	not(US, CN)     not in a set.
+	//
+	// Output cells are a number, a boolean or a string, `=` followed by a lua
+	// expression over `facts`, or empty to leave the field unchanged.
+	// Note: Fields are top-level fields of facts, and inputs cannot be outputs.
+	DecisionTable struct {
+		Name      string
+		HitPolicy HitPolicy
+		// Fields of input and output columns in order.
+		Inputs  []string
+		Outputs []string
+		Rows    []*DecisionRow
+
+		// parsed conditions of rows.
+		conds [][]*condition
+		rs    *RuleSet
+	}
+
+	// DecisionRow is a row of decision table.
+	DecisionRow struct {
+		Name     string
+		Priority int
+		// Cells of input and output columns.
+		When []string
+		Then []string
+	}
+
+	// DecisionIssue is a gap or an overlap found by Validate.
+	DecisionIssue struct {
+		// DecisionGap or DecisionOverlap.
+		Kind string
+		// Overlapped rows, numbered from 1 in order of table.
+		Rows []int
+		// Example inputs matched by no row, keyed by input field,
+		// the fields of any value are omitted.
+		Inputs map[string]string
+	}
+
+	// condition is a parsed condition cell, it matches any value if tests is empty.
+	condition struct {
+		negate bool
+		tests  []*test
+	}
+
+	// test is a literal value, or an interval of numbers if value is nil.
+	test struct {
+		value          interface{}
+		lo, hi         float64
+		loOpen, hiOpen bool
+	}
+
+	// sample is a value standing for a segment of input domain in validation.
+	sample struct {
+		value interface{}
+		label string
+	}
+
+	// otherValue stands for values not mentioned in an input column.
+	otherValue struct{}
+)
+
+// LoadDecisionTable loads decision table from CSV, and compiles it into a rule set.
+// Lines starting with `#` are comments.
+func LoadDecisionTable(name string, r io.Reader, hitPolicy HitPolicy) (*DecisionTable, error) {
+	switch hitPolicy {
+	case HitFirst, HitUnique, HitPriority, HitCollect:
+	default:
+		return nil, errors.Wrapf(ErrDecisionHitPolicy, "hit policy %q", hitPolicy)
+	}
+
+	reader := csv.NewReader(r)
+	reader.Comment = '#'
+	reader.TrimLeadingSpace = true
+	records, err := reader.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+
+	if len(records) == 0 {
+		return nil, ErrDecisionNoOutput
+	}
+
+	dt := &DecisionTable{
+		Name:      name,
+		HitPolicy: hitPolicy,
+		Inputs:    make([]string, 0),
+		Outputs:   make([]string, 0),
+		Rows:      make([]*DecisionRow, 0, len(records)-1),
+		conds:     make([][]*condition, 0, len(records)-1),
+	}
+
+	if err := dt.parse(records[0], records[1:]); err != nil {
+		return nil, err
+	}
+
+	if err := dt.compile(); err != nil {
+		return nil, err
+	}
+
+	if hitPolicy == HitUnique {
+		if overlaps := dt.overlaps(1); len(overlaps) > 0 {
+			return nil, errors.Wrapf(ErrDecisionOverlap, "rows %s", joinInts(overlaps[0].Rows))
+		}
+	}
+
+	return dt, nil
+}
+
+// LoadDecisionTableFile loads decision table from CSV file, it is named
+// by the file name without extension.
+func LoadDecisionTableFile(path string, hitPolicy HitPolicy) (*DecisionTable, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	name := strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+
+	return LoadDecisionTable(name, f, hitPolicy)
+}
+
+// RuleSet returns the rule set compiled from decision table, the rules are
+// the rows in order of evaluation.
+func (dt *DecisionTable) RuleSet() *RuleSet {
+	return dt.rs
+}
+
+// Eval evaluates decision table against facts with a lua state of pool, the
+// matched rows fire according to hit policy.
+// Note: facts is not modified, the outputs are returned in facts of result.
+func (dt *DecisionTable) Eval(ctx context.Context, lpm *pm.LPM, facts map[string]interface{}) (*Result, error) {
+	rs := dt.rs
+
+	return rs.run(ctx, lpm, facts, traceModeDecision, func(s *session) error {
+		for i := range rs.Rules {
+			yes, err := s.condition(i)
+			if err != nil {
+				return err
+			}
+
+			if !yes {
+				continue
+			}
+
+			if _, err := s.action(i, false); err != nil {
+				return err
+			}
+
+			if dt.HitPolicy != HitCollect {
+				return nil
+			}
+		}
+
+		return nil
+	})
+}
+
+// Validate checks decision table for gaps, the inputs matched by no row, and
+// overlaps, the rows matched by the same inputs. Inputs are checked against the
+// values and ranges mentioned in table, at most 100 issues are reported.
+// Note: At most 100000 combinations of inputs are searched for gaps, the gaps
+// of wider tables may be missed.
+// Note: Overlaps are expected by HitCollect, and resolved by order or priority
+// of rows for HitFirst and HitPriority.
+func (dt *DecisionTable) Validate() []*DecisionIssue {
+	issues := dt.gaps()
+
+	return append(issues, dt.overlaps(maxDecisionIssues-len(issues))...)
+}
+
+func (i *DecisionIssue) String() string {
+	if i.Kind == DecisionOverlap {
+		return fmt.Sprintf("overlap: rows %s", joinInts(i.Rows))
+	}
+
+	fields := make([]string, 0, len(i.Inputs))
+	for field := range i.Inputs {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+
+	inputs := make([]string, 0, len(fields))
+	for _, field := range fields {
+		inputs = append(inputs, fmt.Sprintf("%s=%s", field, i.Inputs[field]))
+	}
+
+	if len(inputs) == 0 {
+		return "gap: no rows"
+	}
+
+	return fmt.Sprintf("gap: no row matches %s", strings.Join(inputs, ", "))
+}
+
+// parse parses header and rows of table.
+func (dt *DecisionTable) parse(header []string, records [][]string) error {
+	nameCol, priorityCol := -1, -1
+	whenCols, thenCols := make([]int, 0), make([]int, 0)
+	seen := make(map[string]struct{})
+
+	for i, column := range header {
+		column = strings.TrimSpace(column)
+		lower := strings.ToLower(column)
+
+		var field string
+		switch {
+		case lower == nameColumn:
+			nameCol = i
+
+			continue
+		case lower == priorityColumn:
+			priorityCol = i
+
+			continue
+		case strings.HasPrefix(lower, whenPrefix):
+			field = strings.TrimSpace(column[len(whenPrefix):])
+			dt.Inputs = append(dt.Inputs, field)
+			whenCols = append(whenCols, i)
+		case strings.HasPrefix(lower, thenPrefix):
+			field = strings.TrimSpace(column[len(thenPrefix):])
+			dt.Outputs = append(dt.Outputs, field)
+			thenCols = append(thenCols, i)
+		default:
+			return errors.Wrapf(ErrDecisionColumn, "column %q", column)
+		}
+
+		if !identRegexp.MatchString(field) {
+			return errors.Wrapf(ErrDecisionColumn, "column %q", column)
+		}
+
+		if _, ok := seen[field]; ok {
+			return errors.Wrapf(ErrDecisionColumn, "duplicate field of column %q", column)
+		}
+		seen[field] = struct{}{}
+	}
+
+	if len(dt.Outputs) == 0 {
+		return ErrDecisionNoOutput
+	}
+
+	if dt.HitPolicy == HitPriority && priorityCol < 0 {
+		return errors.Wrap(ErrDecisionColumn, "priority column is required")
+	}
+
+	for n, record := range records {
+		row := &DecisionRow{
+			Name: fmt.Sprintf("row %d", n+1),
+			When: make([]string, 0, len(whenCols)),
+			Then: make([]string, 0, len(thenCols)),
+		}
+
+		if nameCol >= 0 {
+			if name := strings.TrimSpace(record[nameCol]); name != "" {
+				row.Name = name
+			}
+		}
+
+		if priorityCol >= 0 {
+			if cell := strings.TrimSpace(record[priorityCol]); cell != "" {
+				priority, err := strconv.Atoi(cell)
+				if err != nil {
+					return errors.Wrapf(ErrDecisionCell, "row %d column %q", n+1, header[priorityCol])
+				}
+
+				row.Priority = priority
+			}
+		}
+
+		conds := make([]*condition, 0, len(whenCols))
+		for _, col := range whenCols {
+			cond, err := parseCondition(record[col])
+			if err != nil {
+				return errors.Wrapf(err, "row %d column %q", n+1, header[col])
+			}
+
+			row.When = append(row.When, strings.TrimSpace(record[col]))
+			conds = append(conds, cond)
+		}
+
+		for _, col := range thenCols {
+			row.Then = append(row.Then, strings.TrimSpace(record[col]))
+		}
+
+		dt.Rows = append(dt.Rows, row)
+		dt.conds = append(dt.conds, conds)
+	}
+
+	return nil
+}
+
+// compile compiles rows into rules of rule set.
+func (dt *DecisionTable) compile() error {
+	rules := make([]*Rule, 0, len(dt.Rows))
+	for i, row := range dt.Rows {
+		conds := make([]string, 0, len(dt.Inputs))
+		for j, field := range dt.Inputs {
+			if source := dt.conds[i][j].source(factsName + "." + field); source != "" {
+				conds = append(conds, source)
+			}
+		}
+
+		if len(conds) == 0 {
+			conds = append(conds, "true")
+		}
+
+		var action bytes.Buffer
+		for j, field := range dt.Outputs {
+			value, ok := outputSource(row.Then[j])
+			if !ok {
+				continue
+			}
+
+			if dt.HitPolicy == HitCollect {
+				fmt.Fprintf(&action, "do local t = %s.%s or {} t[#t + 1] = %s %s.%s = t end\n",
+					factsName, field, value, factsName, field)
+			} else {
+				fmt.Fprintf(&action, "%s.%s = %s\n", factsName, field, value)
+			}
+		}
+
+		rule := &Rule{
+			Name:      row.Name,
+			Condition: strings.Join(conds, " and "),
+			Action:    action.String(),
+		}
+
+		if dt.HitPolicy == HitPriority {
+			rule.Salience = row.Priority
+		}
+
+		rules = append(rules, rule)
+	}
+
+	rs, err := NewRuleSet(dt.Name, rules...)
+	if err != nil {
+		return err
+	}
+
+	dt.rs = rs
+
+	return nil
+}
+
+// gaps returns example inputs matched by no row. The combinations of inputs
+// are searched column by column, and the columns left are skipped once a row
+// matches all of their samples.
+func (dt *DecisionTable) gaps() []*DecisionIssue {
+	issues := make([]*DecisionIssue, 0)
+	domains := make([][]*sample, len(dt.Inputs))
+	for col := range dt.Inputs {
+		domains[col] = dt.domain(col)
+	}
+
+	// covered[i][col] is whether row i matches all samples of columns from col.
+	covered := make([][]bool, len(dt.Rows))
+	for i := range dt.Rows {
+		covered[i] = make([]bool, len(dt.Inputs)+1)
+		covered[i][len(dt.Inputs)] = true
+		for col := len(dt.Inputs) - 1; col >= 0; col-- {
+			covered[i][col] = covered[i][col+1]
+			for _, s := range domains[col] {
+				if !covered[i][col] {
+					break
+				}
+				covered[i][col] = dt.conds[i][col].match(s.value)
+			}
+		}
+	}
+
+	rows := make([]int, len(dt.Rows))
+	for i := range rows {
+		rows[i] = i
+	}
+
+	inputs := make(map[string]string)
+	combinations := 0
+
+	var walk func(rows []int, col int)
+	walk = func(rows []int, col int) {
+		if len(issues) >= maxDecisionIssues || combinations >= maxDecisionCombinations {
+			return
+		}
+		combinations += 1
+
+		if len(rows) == 0 {
+			gap := make(map[string]string, len(inputs))
+			for field, label := range inputs {
+				gap[field] = label
+			}
+
+			issues = append(issues, &DecisionIssue{
+				Kind:   DecisionGap,
+				Inputs: gap,
+			})
+
+			return
+		}
+
+		for _, i := range rows {
+			if covered[i][col] {
+				return
+			}
+		}
+
+		field := dt.Inputs[col]
+		for _, s := range domains[col] {
+			matched := make([]int, 0, len(rows))
+			for _, i := range rows {
+				if dt.conds[i][col].match(s.value) {
+					matched = append(matched, i)
+				}
+			}
+
+			if s.label != "" {
+				inputs[field] = s.label
+			}
+			walk(matched, col+1)
+			delete(inputs, field)
+		}
+	}
+	walk(rows, 0)
+
+	return issues
+}
+
+// overlaps returns at most max pairs of rows matched by the same inputs.
+func (dt *DecisionTable) overlaps(max int) []*DecisionIssue {
+	issues := make([]*DecisionIssue, 0)
+	domains := make([][]*sample, len(dt.Inputs))
+	for col := range dt.Inputs {
+		domains[col] = dt.domain(col)
+	}
+
+	for i := 0; i < len(dt.Rows); i++ {
+		for j := i + 1; j < len(dt.Rows); j++ {
+			if len(issues) >= max {
+				return issues
+			}
+
+			overlapped := true
+			for col := range dt.Inputs {
+				intersected := false
+				for _, s := range domains[col] {
+					if dt.conds[i][col].match(s.value) && dt.conds[j][col].match(s.value) {
+						intersected = true
+
+						break
+					}
+				}
+
+				if !intersected {
+					overlapped = false
+
+					break
+				}
+			}
+
+			if overlapped {
+				issues = append(issues, &DecisionIssue{
+					Kind: DecisionOverlap,
+					Rows: []int{i + 1, j + 1},
+				})
+			}
+		}
+	}
+
+	return issues
+}
+
+// domain splits values of input column into segments in which each condition
+// of column either matches all values or none, and returns a sample of each.
+func (dt *DecisionTable) domain(col int) []*sample {
+	bounds := make([]float64, 0)
+	literals := make([]interface{}, 0)
+	numeric, other := false, false
+
+	for i := range dt.Rows {
+		cond := dt.conds[i][col]
+		if cond.negate {
+			other = true
+		}
+
+		for _, t := range cond.tests {
+			if t.value != nil {
+				if !containsValue(literals, t.value) {
+					literals = append(literals, t.value)
+				}
+
+				continue
+			}
+
+			numeric = true
+			for _, bound := range []float64{t.lo, t.hi} {
+				if !math.IsInf(bound, 0) {
+					bounds = append(bounds, bound)
+				}
+			}
+		}
+	}
+
+	samples := make([]*sample, 0)
+	if numeric {
+		sort.Float64s(bounds)
+		uniq := bounds[:0]
+		for _, bound := range bounds {
+			if len(uniq) == 0 || uniq[len(uniq)-1] != bound {
+				uniq = append(uniq, bound)
+			}
+		}
+
+		for k, bound := range uniq {
+			if k == 0 {
+				samples = append(samples, &sample{bound - 1, "<" + formatNumber(bound)})
+			} else {
+				prev := uniq[k-1]
+				samples = append(samples, &sample{prev + (bound-prev)/2,
+					fmt.Sprintf("(%s..%s)", formatNumber(prev), formatNumber(bound))})
+			}
+
+			samples = append(samples, &sample{bound, formatNumber(bound)})
+		}
+
+		if n := len(uniq); n > 0 {
+			samples = append(samples, &sample{uniq[n-1] + 1, ">" + formatNumber(uniq[n-1])})
+		}
+	}
+
+	for _, literal := range literals {
+		samples = append(samples, &sample{literal, luaLiteral(literal)})
+	}
+
+	if len(literals) > 0 || other {
+		samples = append(samples, &sample{otherValue{}, "other"})
+	}
+
+	if len(samples) == 0 {
+		// all conditions of column match any value.
+		samples = append(samples, &sample{otherValue{}, ""})
+	}
+
+	return samples
+}
+
+// parseCondition parses condition cell.
+func parseCondition(cell string) (*condition, error) {
+	s := strings.TrimSpace(cell)
+	cond := &condition{
+		tests: make([]*test, 0),
+	}
+
+	if s == "" || s == "-" || s == "*" {
+		return cond, nil
+	}
+
+	if lower := strings.ToLower(s); strings.HasPrefix(lower, "not(") && strings.HasSuffix(s, ")") {
+		cond.negate = true
+		s = s[len("not(") : len(s)-1]
+	}
+
+	for _, token := range splitCell(s) {
+		t, err := parseTest(token)
+		if err != nil {
+			return nil, err
+		}
+
+		cond.tests = append(cond.tests, t)
+	}
+
+	return cond, nil
+}
+
+// parseTest parses a value, a comparison or a range of condition cell.
+func parseTest(token string) (*test, error) {
+	if token == "" {
+		return nil, errors.Wrap(ErrDecisionCell, "empty value")
+	}
+
+	for _, op := range []string{"<=", ">=", "<", ">"} {
+		if !strings.HasPrefix(token, op) {
+			continue
+		}
+
+		n, err := parseNumber(strings.TrimSpace(token[len(op):]))
+		if err != nil {
+			return nil, errors.Wrapf(ErrDecisionCell, "%q is not a number comparison", token)
+		}
+
+		t := &test{lo: math.Inf(-1), hi: math.Inf(1), loOpen: true, hiOpen: true}
+		switch op {
+		case "<=":
+			t.hi, t.hiOpen = n, false
+		case "<":
+			t.hi = n
+		case ">=":
+			t.lo, t.loOpen = n, false
+		case ">":
+			t.lo = n
+		}
+
+		return t, nil
+	}
+
+	if t, ok, err := parseRange(token); ok || err != nil {
+		return t, err
+	}
+
+	value := parseLiteral(token)
+	if n, ok := value.(float64); ok {
+		return &test{lo: n, hi: n}, nil
+	}
+
+	return &test{value: value}, nil
+}
+
+// parseRange parses range of numbers like `[1..10)` or `1..10`, ok is false
+// if token is not a range.
+func parseRange(token string) (*test, bool, error) {
+	t := &test{}
+	s := token
+	if strings.IndexAny(s[:1], "[(") == 0 && strings.IndexAny(s[len(s)-1:], "])") == 0 {
+		t.loOpen = s[0] == '('
+		t.hiOpen = s[len(s)-1] == ')'
+		s = s[1 : len(s)-1]
+	} else if strings.IndexAny(s[:1], "[(") == 0 || strings.IndexAny(s[len(s)-1:], "])") == 0 {
+		return nil, false, errors.Wrapf(ErrDecisionCell, "%q is not a range", token)
+	}
+
+	parts := strings.Split(s, "..")
+	if len(parts) != 2 {
+		if s != token {
+			return nil, false, errors.Wrapf(ErrDecisionCell, "%q is not a range", token)
+		}
+
+		return nil, false, nil
+	}
+
+	var err error
+	if t.lo, err = parseNumber(strings.TrimSpace(parts[0])); err == nil {
+		t.hi, err = parseNumber(strings.TrimSpace(parts[1]))
+	}
+
+	if err != nil {
+		if s != token {
+			return nil, false, errors.Wrapf(ErrDecisionCell, "%q is not a range", token)
+		}
+
+		// a string like "a..b".
+		return nil, false, nil
+	}
+
+	if t.lo > t.hi {
+		return nil, false, errors.Wrapf(ErrDecisionCell, "%q is an empty range", token)
+	}
+
+	return t, true, nil
+}
+
+// parseLiteral parses number, boolean or string, strings may be quoted.
+func parseLiteral(token string) interface{} {
+	if n, err := parseNumber(token); err == nil {
+		return n
+	}
+
+	switch token {
+	case "true":
+		return true
+	case "false":
+		return false
+	}
+
+	if n := len(token); n >= 2 && (token[0] == '"' || token[0] == '\'') && token[n-1] == token[0] {
+		return token[1 : n-1]
+	}
+
+	return token
+}
+
+// splitCell splits cell by comma out of quotes.
+func splitCell(s string) []string {
+	tokens := make([]string, 0)
+
+	var quote byte
+	start := 0
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == ',':
+			tokens = append(tokens, strings.TrimSpace(s[start:i]))
+			start = i + 1
+		}
+	}
+
+	return append(tokens, strings.TrimSpace(s[start:]))
+}
+
+// outputSource returns lua expression of output cell, ok is false if cell is empty.
+func outputSource(cell string) (string, bool) {
+	if cell == "" || cell == "-" {
+		return "", false
+	}
+
+	if strings.HasPrefix(cell, "=") {
+		return "(" + cell[1:] + ")", true
+	}
+
+	return luaLiteral(parseLiteral(cell)), true
+}
+
+// source returns lua expression of condition on x, or empty if it matches any value.
+func (c *condition) source(x string) string {
+	if len(c.tests) == 0 {
+		return ""
+	}
+
+	tests := make([]string, 0, len(c.tests))
+	for _, t := range c.tests {
+		tests = append(tests, t.source(x))
+	}
+
+	source := "(" + strings.Join(tests, " or ") + ")"
+	if c.negate {
+		return "not " + source
+	}
+
+	return source
+}
+
+func (c *condition) match(v interface{}) bool {
+	if len(c.tests) == 0 {
+		return true
+	}
+
+	matched := false
+	for _, t := range c.tests {
+		if t.match(v) {
+			matched = true
+
+			break
+		}
+	}
+
+	return matched != c.negate
+}
+
+// source returns lua expression of test on x, the numbers are compared only if
+// x is a number, since comparing with other types raises error in lua.
+func (t *test) source(x string) string {
+	if t.value != nil {
+		return fmt.Sprintf("%s == %s", x, luaLiteral(t.value))
+	}
+
+	if t.lo == t.hi && !t.loOpen && !t.hiOpen {
+		return fmt.Sprintf("%s == %s", x, formatNumber(t.lo))
+	}
+
+	parts := []string{fmt.Sprintf("type(%s) == \"number\"", x)}
+	if !math.IsInf(t.lo, 0) {
+		op := ">="
+		if t.loOpen {
+			op = ">"
+		}
+
+		parts = append(parts, fmt.Sprintf("%s %s %s", x, op, formatNumber(t.lo)))
+	}
+
+	if !math.IsInf(t.hi, 0) {
+		op := "<="
+		if t.hiOpen {
+			op = "<"
+		}
+
+		parts = append(parts, fmt.Sprintf("%s %s %s", x, op, formatNumber(t.hi)))
+	}
+
+	return "(" + strings.Join(parts, " and ") + ")"
+}
+
+func (t *test) match(v interface{}) bool {
+	if t.value != nil {
+		return t.value == v
+	}
+
+	n, ok := v.(float64)
+	if !ok {
+		return false
+	}
+
+	if n < t.lo || (t.loOpen && n == t.lo) {
+		return false
+	}
+
+	if n > t.hi || (t.hiOpen && n == t.hi) {
+		return false
+	}
+
+	return true
+}
+
+// luaLiteral returns lua literal of number, boolean or string.
+func luaLiteral(v interface{}) string {
+	switch value := v.(type) {
+	case float64:
+		return formatNumber(value)
+	case bool:
+		return strconv.FormatBool(value)
+	case string:
+		return luaQuote(value)
+	}
+
+	return "nil"
+}
+
+// luaQuote quotes string with escapes of lua 5.1.
+func luaQuote(s string) string {
+	var buf bytes.Buffer
+	buf.WriteByte('"')
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; c {
+		case '"', '\\':
+			buf.WriteByte('\\')
+			buf.WriteByte(c)
+		case '\n':
+			buf.WriteString(`\n`)
+		case '\r':
+			buf.WriteString(`\r`)
+		case '\t':
+			buf.WriteString(`\t`)
+		default:
+			if c < ' ' || c == 0x7f {
+				fmt.Fprintf(&buf, "\\%03d", c)
+			} else {
+				buf.WriteByte(c)
+			}
+		}
+	}
+	buf.WriteByte('"')
+
+	return buf.String()
+}
+
+// parseNumber parses finite number.
+func parseNumber(s string) (float64, error) {
+	n, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return 0, err
+	}
+
+	if math.IsNaN(n) || math.IsInf(n, 0) {
+		return 0, strconv.ErrRange
+	}
+
+	return n, nil
+}
+
+func formatNumber(n float64) string {
+	return strconv.FormatFloat(n, 'g', -1, 64)
+}
+
+func containsValue(values []interface{}, v interface{}) bool {
+	for _, value := range values {
+		if value == v {
+			return true
+		}
+	}
+
+	return false
+}
+
+func joinInts(ns []int) string {
+	s := make([]string, 0, len(ns))
+	for _, n := range ns {
+		s = append(s, strconv.Itoa(n))
+	}
+
+	return strings.Join(s, ", ")
+}
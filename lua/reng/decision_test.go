@@ -0,0 +1,230 @@
+// (c) 2018, Jeff Chen <jefurry@qq.com>
+//
+// This file is part of Gola
+//
+// Copyright 2018 The Gola Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package reng
+
+import (
+	"context"
+	"fmt"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"strings"
+	"testing"
+)
+
+const pricingTable = `# pricing rules
+name, when:tier, when:amount, then:discount, then:total
+gold, gold, >=100, 0.2, =facts.amount * 0.8
+gold-small, gold, <100, 0.1, -
+silver, "silver", [100..500], 0.05, -
+`
+
+const tagTable = `when:country, then:tag
+US, domestic
+*, all
+"not(US, CN)", foreign
+`
+
+const levelTable = `name, priority, when:score, then:level
+low, 1, >=0, low
+high, 10, (80..100], high
+`
+
+func TestDecisionTable(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.TODO())
+	defer cancel()
+
+	lpm, err := Default(ctx)
+	if !assert.NoError(t, err, "Default should succeed") {
+		return
+	}
+
+	defer lpm.Shutdown(context.TODO())
+
+	dt, err := LoadDecisionTable("pricing", strings.NewReader(pricingTable), HitUnique)
+	if !assert.NoError(t, err, "LoadDecisionTable should succeed") {
+		return
+	}
+
+	if !assert.Equal(t, []string{"tier", "amount"}, dt.Inputs, "inputs mismatching") {
+		return
+	}
+
+	if !assert.Equal(t, []string{"discount", "total"}, dt.Outputs, "outputs mismatching") {
+		return
+	}
+
+	issues := dt.Validate()
+	gaps := make([]string, 0)
+	for _, issue := range issues {
+		gaps = append(gaps, issue.String())
+	}
+
+	if !assert.Equal(t, []string{
+		`gap: no row matches amount=<100, tier="silver"`,
+		`gap: no row matches amount=>500, tier="silver"`,
+		`gap: no row matches tier=other`,
+	}, gaps, "issues mismatching") {
+		return
+	}
+
+	result, err := dt.Eval(ctx, lpm, map[string]interface{}{"tier": "gold", "amount": 150})
+	if !assert.NoError(t, err, "Eval should succeed") {
+		return
+	}
+
+	if !assert.Equal(t, []string{"gold"}, result.Fired, "fired rules mismatching") {
+		return
+	}
+
+	if !assert.Equal(t, map[string]interface{}{
+		"tier": "gold", "amount": float64(150), "discount": 0.2, "total": float64(120),
+	}, result.Facts, "facts mismatching") {
+		return
+	}
+
+	// inputs of other types do not raise errors.
+	result, err = dt.Eval(ctx, lpm, map[string]interface{}{"tier": "silver", "amount": "150"})
+	if !assert.NoError(t, err, "Eval should succeed") {
+		return
+	}
+
+	if !assert.Equal(t, []string{}, result.Fired, "fired rules mismatching") {
+		return
+	}
+
+	_, err = LoadDecisionTable("tag", strings.NewReader(tagTable), HitUnique)
+	if !assert.Equal(t, ErrDecisionOverlap, errors.Cause(err), "error mismatching") {
+		return
+	}
+
+	dt, err = LoadDecisionTable("tag", strings.NewReader(tagTable), HitCollect)
+	if !assert.NoError(t, err, "LoadDecisionTable should succeed") {
+		return
+	}
+
+	issues = dt.Validate()
+	if !assert.Len(t, issues, 2, "issues mismatching") {
+		return
+	}
+
+	if !assert.Equal(t, "overlap: rows 1, 2", issues[0].String(), "issue mismatching") {
+		return
+	}
+
+	if !assert.Equal(t, []int{2, 3}, issues[1].Rows, "issue mismatching") {
+		return
+	}
+
+	for _, v := range []struct {
+		country string
+		tags    []interface{}
+	}{
+		{"US", []interface{}{"domestic", "all"}},
+		{"CN", []interface{}{"all"}},
+		{"FR", []interface{}{"all", "foreign"}},
+	} {
+		result, err := dt.Eval(ctx, lpm, map[string]interface{}{"country": v.country})
+		if !assert.NoError(t, err, "Eval should succeed") {
+			return
+		}
+
+		if !assert.Equal(t, v.tags, result.Facts["tag"], "tags mismatching") {
+			return
+		}
+	}
+
+	dt, err = LoadDecisionTable("level", strings.NewReader(levelTable), HitPriority)
+	if !assert.NoError(t, err, "LoadDecisionTable should succeed") {
+		return
+	}
+
+	for _, v := range []struct {
+		score float64
+		level interface{}
+		fired []string
+	}{
+		{90, "high", []string{"high"}},
+		{80, "low", []string{"low"}},
+		{-1, nil, []string{}},
+	} {
+		result, err := dt.Eval(ctx, lpm, map[string]interface{}{"score": v.score})
+		if !assert.NoError(t, err, "Eval should succeed") {
+			return
+		}
+
+		if !assert.Equal(t, v.fired, result.Fired, "fired rules mismatching") {
+			return
+		}
+
+		if !assert.Equal(t, v.level, result.Facts["level"], "level mismatching") {
+			return
+		}
+	}
+}
+
+func TestDecisionTableWide(t *testing.T) {
+	header := make([]string, 0)
+	specific := make([]string, 0)
+	wildcard := make([]string, 0)
+	for i := 1; i <= 40; i++ {
+		header = append(header, fmt.Sprintf("when:c%d", i))
+		specific = append(specific, "a")
+		wildcard = append(wildcard, "-")
+	}
+
+	table := strings.Join(append(header, "then:out"), ", ") + "\n" +
+		strings.Join(append(specific, "1"), ", ") + "\n" +
+		strings.Join(append(wildcard, "2"), ", ") + "\n"
+
+	dt, err := LoadDecisionTable("wide", strings.NewReader(table), HitFirst)
+	if !assert.NoError(t, err, "LoadDecisionTable should succeed") {
+		return
+	}
+
+	// the combinations of 40 columns are not searched one by one.
+	issues := dt.Validate()
+	if !assert.Len(t, issues, 1, "issues mismatching") {
+		return
+	}
+
+	if !assert.Equal(t, "overlap: rows 1, 2", issues[0].String(), "issue mismatching") {
+		return
+	}
+}
+
+func TestDecisionTableError(t *testing.T) {
+	for _, v := range []struct {
+		table     string
+		hitPolicy HitPolicy
+		err       error
+	}{
+		{"when:a, then:b\n1, 2\n", "any", ErrDecisionHitPolicy},
+		{"when:a, other\n1, 2\n", HitFirst, ErrDecisionColumn},
+		{"when:a, then:a\n1, 2\n", HitFirst, ErrDecisionColumn},
+		{"when:a.b, then:c\n1, 2\n", HitFirst, ErrDecisionColumn},
+		{"when:a, then:b\n1, 2\n", HitPriority, ErrDecisionColumn},
+		{"when:a\n1\n", HitFirst, ErrDecisionNoOutput},
+		{"", HitFirst, ErrDecisionNoOutput},
+		{"when:a, then:b\n[5..1], 2\n", HitFirst, ErrDecisionCell},
+		{"when:a, then:b\n[1..x], 2\n", HitFirst, ErrDecisionCell},
+		{"when:a, then:b\n<x, 2\n", HitFirst, ErrDecisionCell},
+		{"when:a, then:b\n\"1,\", 2\n", HitFirst, ErrDecisionCell},
+		{"priority, when:a, then:b\nhigh, 1, 2\n", HitPriority, ErrDecisionCell},
+	} {
+		_, err := LoadDecisionTable("error", strings.NewReader(v.table), v.hitPolicy)
+		if !assert.Equal(t, v.err, errors.Cause(err), "error mismatching: %q", v.table) {
+			return
+		}
+	}
+
+	_, err := LoadDecisionTable("error", strings.NewReader("when:a, then:b\n1, =+\n"), HitFirst)
+	if !assert.Error(t, err, "LoadDecisionTable should failed") {
+		return
+	}
+}
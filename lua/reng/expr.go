@@ -0,0 +1,230 @@
+// (c) 2018, Jeff Chen <jefurry@qq.com>
+//
+// This file is part of Gola
+//
+// Copyright 2018 The Gola Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package reng
+
+import (
+	"context"
+	"github.com/jefurry/gola/lua/pm"
+	"github.com/pkg/errors"
+	"github.com/yuin/gopher-lua"
+	"github.com/yuin/gopher-lua/ast"
+	"github.com/yuin/gopher-lua/parse"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+var (
+	ErrExprNotExpression = errors.New("not a single expression")
+	ErrExprFunction      = errors.New("function definition is not allowed in expression")
+	ErrExprVararg        = errors.New("vararg is not allowed in expression")
+)
+
+type (
+	// Expr is a compiled lua expression, e.g. `amount > 100 and country == "US"`.
+	// The identifiers of expression are the fields of environment, and then
+	// the globals of lua state.
+	Expr struct {
+		expr string
+		vars []string
+		// compiled chunk returns the value of expression, shared by lua states.
+		proto *lua.FunctionProto
+	}
+)
+
+// global names of DefaultPolicy, which are not variables of expressions.
+var builtinNames = func() map[string]struct{} {
+	names := map[string]struct{}{
+		"_G":       struct{}{},
+		"_VERSION": struct{}{},
+	}
+
+	for module, funcs := range DefaultPolicy().Allow {
+		if module != BaseModule {
+			names[module] = struct{}{}
+
+			continue
+		}
+
+		for _, fn := range funcs {
+			names[fn] = struct{}{}
+		}
+	}
+
+	return names
+}()
+
+// Compile compiles a single lua expression, statements and function definitions
+// are rejected.
+func Compile(expr string) (*Expr, error) {
+	source := conditionSource(expr)
+	chunk, err := parse.Parse(strings.NewReader(source), expr)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(chunk) != 1 {
+		return nil, ErrExprNotExpression
+	}
+
+	ret, ok := chunk[0].(*ast.ReturnStmt)
+	if !ok || len(ret.Exprs) != 1 {
+		return nil, ErrExprNotExpression
+	}
+
+	seen := make(map[string]struct{})
+	walkExpr(ret.Exprs[0], func(expr ast.Expr) bool {
+		switch e := expr.(type) {
+		case *ast.FunctionExpr:
+			err = ErrExprFunction
+
+			return false
+		case *ast.Comma3Expr:
+			err = ErrExprVararg
+		case *ast.IdentExpr:
+			if _, ok := builtinNames[e.Value]; !ok {
+				seen[e.Value] = struct{}{}
+			}
+		}
+
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	vars := make([]string, 0, len(seen))
+	for name := range seen {
+		vars = append(vars, name)
+	}
+	sort.Strings(vars)
+
+	proto, err := lua.Compile(chunk, expr)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Expr{
+		expr:  expr,
+		vars:  vars,
+		proto: proto,
+	}, nil
+}
+
+// MustCompile is like Compile but panics if the expression cannot be compiled.
+func MustCompile(expr string) *Expr {
+	e, err := Compile(expr)
+	if err != nil {
+		panic(err)
+	}
+
+	return e
+}
+
+func (e *Expr) String() string {
+	return e.expr
+}
+
+// Variables returns the identifiers read by expression in order,
+// built-in functions and modules of DefaultPolicy are excluded.
+func (e *Expr) Variables() []string {
+	vars := make([]string, len(e.vars))
+	copy(vars, e.vars)
+
+	return vars
+}
+
+// Eval evaluates expression with a lua state of pool, env is a Go map or struct
+// converted like pm.Call, and the result is converted like the facts of rule set.
+// Note: The exported fields of struct are also available by their Go names, e.g.
+// both `country_id` and `CountryID`.
+func (e *Expr) Eval(ctx context.Context, lpm *pm.LPM, env interface{}) (interface{}, error) {
+	var result interface{}
+
+	_, err := e.eval(ctx, lpm, env, func(lv lua.LValue) {
+		result = toGoValue(lv)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// EvalBool evaluates expression like Eval, and returns whether the result is
+// true in lua, i.e. neither nil nor false.
+func (e *Expr) EvalBool(ctx context.Context, lpm *pm.LPM, env interface{}) (bool, error) {
+	var result bool
+
+	_, err := e.eval(ctx, lpm, env, func(lv lua.LValue) {
+		result = lua.LVAsBool(lv)
+	})
+	if err != nil {
+		return false, err
+	}
+
+	return result, nil
+}
+
+func (e *Expr) eval(ctx context.Context, lpm *pm.LPM, env interface{}, fn func(lua.LValue)) (lua.LValue, error) {
+	return lpm.LoadProto(ctx, e.proto, func(L *lua.LState, chunk *lua.LFunction) (lua.LValue, error) {
+		tbl, ok := pm.ToLValue(L, env).(*lua.LTable)
+		if !ok {
+			tbl = L.NewTable()
+		}
+		setFieldNames(L, tbl, env)
+
+		mt := L.NewTable()
+		mt.RawSetString("__index", L.Env)
+		L.SetMetatable(tbl, mt)
+
+		if err := L.CallByParam(lua.P{
+			Fn: &lua.LFunction{
+				Env:      tbl,
+				Proto:    chunk.Proto,
+				Upvalues: chunk.Upvalues,
+			},
+			NRet:    1,
+			Protect: true,
+		}); err != nil {
+			return lua.LNil, err
+		}
+
+		fn(L.Get(-1))
+		L.Pop(1)
+
+		return lua.LNil, nil
+	})
+}
+
+// setFieldNames sets the exported fields of struct env to tbl by their Go names,
+// which are converted to snake case or tag names by pm.ToLValue.
+func setFieldNames(L *lua.LState, tbl *lua.LTable, env interface{}) {
+	rv := reflect.ValueOf(env)
+	for rv.Kind() == reflect.Ptr || rv.Kind() == reflect.Interface {
+		if rv.IsNil() {
+			return
+		}
+		rv = rv.Elem()
+	}
+
+	if rv.Kind() != reflect.Struct {
+		return
+	}
+
+	rt := rv.Type()
+	for i := 0; i < rt.NumField(); i++ {
+		field := rt.Field(i)
+		if field.PkgPath != "" || tbl.RawGetString(field.Name) != lua.LNil {
+			continue
+		}
+
+		tbl.RawSetString(field.Name, pm.ToLValue(L, rv.Field(i).Interface()))
+	}
+}
@@ -0,0 +1,160 @@
+// (c) 2018, Jeff Chen <jefurry@qq.com>
+//
+// This file is part of Gola
+//
+// Copyright 2018 The Gola Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package reng
+
+import (
+	"context"
+	"github.com/jefurry/gola/lua/pm"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+type order struct {
+	Amount  float64
+	Country string
+	Items   []string
+}
+
+func TestCompile(t *testing.T) {
+	for _, v := range []struct {
+		expr string
+		vars []string
+	}{
+		{`amount > 100 and country == 'US'`, []string{"amount", "country"}},
+		{`string.len(name) > 3 and name:upper() ~= "X"`, []string{"name"}},
+		{`#items + math.max(a.b, c["d"])`, []string{"a", "c", "items"}},
+		{`tonumber(x) or 0`, []string{"x"}},
+	} {
+		e, err := Compile(v.expr)
+		if !assert.NoError(t, err, "Compile should succeed") {
+			return
+		}
+
+		if !assert.Equal(t, v.vars, e.Variables(), "variables mismatching") {
+			return
+		}
+
+		if !assert.Equal(t, v.expr, e.String(), "string mismatching") {
+			return
+		}
+	}
+
+	for _, v := range []struct {
+		expr string
+		err  error
+	}{
+		{`(function() while true do end end)()`, ErrExprFunction},
+		{`select("#", ...)`, ErrExprVararg},
+	} {
+		_, err := Compile(v.expr)
+		if !assert.Equal(t, v.err, err, "error mismatching") {
+			return
+		}
+	}
+
+	for _, expr := range []string{
+		`x = 1`,
+		`1, 2`,
+		`1) while true do end return (1`,
+		`1) end function f() (1`,
+		`for i = 1, 10 do end`,
+		``,
+	} {
+		_, err := Compile(expr)
+		if !assert.Error(t, err, "Compile should failed: %s", expr) {
+			return
+		}
+	}
+}
+
+func TestExprEval(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.TODO())
+	defer cancel()
+
+	lpm, err := Default(ctx)
+	if !assert.NoError(t, err, "Default should succeed") {
+		return
+	}
+
+	defer lpm.Shutdown(context.TODO())
+
+	e := MustCompile(`amount > 100 and country == 'US'`)
+	for _, v := range []struct {
+		env interface{}
+		yes bool
+	}{
+		{map[string]interface{}{"amount": 200, "country": "US"}, true},
+		{map[string]interface{}{"amount": 200, "country": "CN"}, false},
+		{&order{Amount: 200, Country: "US"}, true},
+		{order{Amount: 50, Country: "US"}, false},
+	} {
+		yes, err := e.EvalBool(ctx, lpm, v.env)
+		if !assert.NoError(t, err, "EvalBool should succeed") {
+			return
+		}
+
+		if !assert.Equal(t, v.yes, yes, "result mismatching") {
+			return
+		}
+	}
+
+	e = MustCompile(`amount * 2 + #items`)
+	result, err := e.Eval(ctx, lpm, &order{Amount: 1.5, Items: []string{"a", "b"}})
+	if !assert.NoError(t, err, "Eval should succeed") {
+		return
+	}
+
+	if !assert.Equal(t, float64(5), result, "result mismatching") {
+		return
+	}
+
+	// fields of struct are available by their Go names.
+	for _, v := range []struct {
+		expr   string
+		result interface{}
+	}{
+		{`CountryID == 'US'`, true},
+		{`Amount > 100`, true},
+		{`country_id .. amount`, "US200"},
+	} {
+		e = MustCompile(v.expr)
+		result, err := e.Eval(ctx, lpm, struct {
+			Amount    int
+			CountryID string
+		}{200, "US"})
+		if !assert.NoError(t, err, "Eval should succeed") {
+			return
+		}
+
+		if !assert.Equal(t, v.result, result, "result mismatching") {
+			return
+		}
+	}
+
+	// globals of lua state are available but not modified.
+	e = MustCompile(`string.upper(name)`)
+	result, err = e.Eval(ctx, lpm, map[string]interface{}{"name": "gola"})
+	if !assert.NoError(t, err, "Eval should succeed") {
+		return
+	}
+
+	if !assert.Equal(t, "GOLA", result, "result mismatching") {
+		return
+	}
+
+	_, err = MustCompile(`missing.field`).Eval(ctx, lpm, nil)
+	if !assert.Error(t, err, "Eval should failed") {
+		return
+	}
+
+	// expressions are compiled once by Compile, not by lua states.
+	if !assert.Equal(t, pm.ChunkCacheStats{}, lpm.ChunkCacheStats(), "chunk cache stats mismatching") {
+		return
+	}
+}
@@ -0,0 +1,306 @@
+// (c) 2018, Jeff Chen <jefurry@qq.com>
+//
+// This file is part of Gola
+//
+// Copyright 2018 The Gola Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package reng
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/jefurry/gola/lua/pm"
+	"github.com/pkg/errors"
+	"github.com/pmezard/go-difflib/difflib"
+	"gopkg.in/yaml.v2"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Modes of fixture.
+const (
+	ModeEval  = traceModeEval
+	ModeInfer = traceModeInfer
+)
+
+var (
+	ErrSuiteRuleSet = errors.New("test suite has no rule set")
+	ErrFixtureMode  = errors.New("unknown mode of fixture")
+)
+
+type (
+	// Fixture is a test case of rule set.
+	Fixture struct {
+		Name string `json:"name" yaml:"name"`
+		// ModeEval or ModeInfer, defaults to ModeEval.
+		// Note: Decision tables are always evaluated by hit policy.
+		Mode string `json:"mode" yaml:"mode"`
+		// Input facts.
+		Facts map[string]interface{} `json:"facts" yaml:"facts"`
+		// Expected names of fired rules in order, nil to skip the check.
+		Fired []string `json:"fired" yaml:"fired"`
+		// Expected output facts, only the listed fields are compared.
+		Expect map[string]interface{} `json:"expect" yaml:"expect"`
+		// Expected substring of error message, empty if no error is expected.
+		Error string `json:"error" yaml:"error"`
+	}
+
+	// Suite is a file of fixtures of a rule set, like:
+	//
+	//	rule_set: discount.yaml
+	//	cases:
+	//	  - name: vip
+	//	    facts: {vip: true, age: 30}
+	//	    fired: [vip, adult]
+	//	    expect: {discount: 0.2}
+	Suite struct {
+		// Path of rule set or CSV decision table, relative to the suite file.
+		RuleSet string `json:"rule_set" yaml:"rule_set"`
+		// Hit policy of decision table, defaults to HitFirst.
+		HitPolicy HitPolicy `json:"hit_policy" yaml:"hit_policy"`
+		// Default mode of fixtures.
+		Mode  string     `json:"mode" yaml:"mode"`
+		Cases []*Fixture `json:"cases" yaml:"cases"`
+
+		path string
+	}
+
+	// TestResult is the result of a fixture.
+	TestResult struct {
+		Name   string
+		Passed bool
+		// Unified diff of expected and actual results if failed.
+		Diff string
+		// Unexpected error of evaluation.
+		Err      error
+		Duration time.Duration
+	}
+
+	evalFunc func(ctx context.Context, lpm *pm.LPM, facts map[string]interface{}) (*Result, error)
+)
+
+// LoadSuite loads test suite from YAML or JSON file.
+func LoadSuite(path string) (*Suite, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Suite{}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, s)
+	case ".json":
+		err = json.Unmarshal(data, s)
+	default:
+		return nil, ErrRuleSetFormat
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	if s.RuleSet == "" {
+		return nil, errors.Wrapf(ErrSuiteRuleSet, "suite %q", path)
+	}
+
+	s.path = path
+	for _, f := range s.Cases {
+		if f.Mode == "" {
+			f.Mode = s.Mode
+		}
+	}
+
+	return s, nil
+}
+
+// Run loads rule set of suite, and runs the fixtures with a lua state of pool.
+func (s *Suite) Run(ctx context.Context, lpm *pm.LPM) ([]*TestResult, error) {
+	path := s.RuleSet
+	if !filepath.IsAbs(path) && s.path != "" {
+		path = filepath.Join(filepath.Dir(s.path), path)
+	}
+
+	if strings.ToLower(filepath.Ext(path)) == ".csv" {
+		hitPolicy := s.HitPolicy
+		if hitPolicy == "" {
+			hitPolicy = HitFirst
+		}
+
+		dt, err := LoadDecisionTableFile(path, hitPolicy)
+		if err != nil {
+			return nil, err
+		}
+
+		return dt.Test(ctx, lpm, s.Cases...), nil
+	}
+
+	rs, err := LoadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return rs.Test(ctx, lpm, s.Cases...), nil
+}
+
+// Test runs fixtures against rule set with a lua state of pool.
+func (rs *RuleSet) Test(ctx context.Context, lpm *pm.LPM, fixtures ...*Fixture) []*TestResult {
+	results := make([]*TestResult, 0, len(fixtures))
+	for _, f := range fixtures {
+		var eval evalFunc
+		switch f.Mode {
+		case "", ModeEval:
+			eval = rs.Eval
+		case ModeInfer:
+			eval = rs.Infer
+		default:
+			results = append(results, &TestResult{
+				Name: f.Name,
+				Err:  errors.Wrapf(ErrFixtureMode, "mode %q", f.Mode),
+			})
+
+			continue
+		}
+
+		results = append(results, f.run(ctx, lpm, eval))
+	}
+
+	return results
+}
+
+// Test runs fixtures against decision table with a lua state of pool.
+func (dt *DecisionTable) Test(ctx context.Context, lpm *pm.LPM, fixtures ...*Fixture) []*TestResult {
+	results := make([]*TestResult, 0, len(fixtures))
+	for _, f := range fixtures {
+		results = append(results, f.run(ctx, lpm, dt.Eval))
+	}
+
+	return results
+}
+
+// run evaluates facts of fixture, and compares the results with expected.
+func (f *Fixture) run(ctx context.Context, lpm *pm.LPM, eval evalFunc) *TestResult {
+	start := time.Now()
+	result, err := eval(ctx, lpm, normalizeValue(f.Facts).(map[string]interface{}))
+
+	tr := &TestResult{
+		Name:     f.Name,
+		Duration: time.Since(start),
+	}
+
+	if err != nil && f.Error == "" {
+		tr.Err = err
+
+		return tr
+	}
+
+	expected := make(map[string]interface{})
+	actual := make(map[string]interface{})
+
+	if f.Error != "" {
+		expected["error"] = f.Error
+		switch {
+		case err == nil:
+			actual["error"] = nil
+		case strings.Contains(err.Error(), f.Error):
+			actual["error"] = f.Error
+		default:
+			actual["error"] = err.Error()
+		}
+	}
+
+	if err == nil {
+		if f.Fired != nil {
+			expected["fired"] = f.Fired
+			actual["fired"] = result.Fired
+		}
+
+		if f.Expect != nil {
+			facts := make(map[string]interface{}, len(f.Expect))
+			for field := range f.Expect {
+				facts[field] = result.Facts[field]
+			}
+
+			expected["facts"] = normalizeValue(f.Expect)
+			actual["facts"] = normalizeValue(facts)
+		}
+	}
+
+	a, err := json.MarshalIndent(expected, "", "  ")
+	if err != nil {
+		tr.Err = err
+
+		return tr
+	}
+
+	b, err := json.MarshalIndent(actual, "", "  ")
+	if err != nil {
+		tr.Err = err
+
+		return tr
+	}
+
+	if string(a) == string(b) {
+		tr.Passed = true
+
+		return tr
+	}
+
+	tr.Diff, tr.Err = difflib.GetUnifiedDiffString(difflib.UnifiedDiff{
+		A:        difflib.SplitLines(string(a)),
+		B:        difflib.SplitLines(string(b)),
+		FromFile: "expected",
+		ToFile:   "actual",
+		Context:  3,
+	})
+
+	return tr
+}
+
+// normalizeValue converts values decoded from YAML or JSON to the values of
+// result facts, i.e. numbers to float64, and maps to map[string]interface{}.
+// Note: Empty arrays are empty maps, since lua does not distinguish them.
+func normalizeValue(v interface{}) interface{} {
+	switch value := v.(type) {
+	case int:
+		return float64(value)
+	case int64:
+		return float64(value)
+	case uint64:
+		return float64(value)
+	case float32:
+		return float64(value)
+	case []interface{}:
+		if len(value) == 0 {
+			return make(map[string]interface{})
+		}
+
+		arr := make([]interface{}, 0, len(value))
+		for _, e := range value {
+			arr = append(arr, normalizeValue(e))
+		}
+
+		return arr
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(value))
+		for k, e := range value {
+			m[fmt.Sprint(k)] = normalizeValue(e)
+		}
+
+		return m
+	case map[string]interface{}:
+		m := make(map[string]interface{}, len(value))
+		for k, e := range value {
+			m[k] = normalizeValue(e)
+		}
+
+		return m
+	}
+
+	return v
+}
@@ -0,0 +1,153 @@
+// (c) 2018, Jeff Chen <jefurry@qq.com>
+//
+// This file is part of Gola
+//
+// Copyright 2018 The Gola Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package reng
+
+import (
+	"context"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+const yamlSuite = `
+rule_set: discount.yaml
+cases:
+  - name: vip
+    facts: {vip: true, age: 30}
+    fired: [vip, adult]
+    expect: {discount: 0.2, tags: [vip], adult: true}
+  - name: child
+    facts: {age: 10}
+    fired: [child]
+    expect: {discount: 0.4}
+  - name: nobody
+    facts: {age: 15}
+    fired: []
+  - name: error
+    facts: {age: "ten"}
+    error: attempt to compare
+`
+
+const csvSuite = `{
+	"rule_set": "tag.csv",
+	"hit_policy": "collect",
+	"cases": [
+		{"name": "us", "facts": {"country": "US"}, "expect": {"tag": ["domestic", "all"]}}
+	]
+}`
+
+func TestSuite(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.TODO())
+	defer cancel()
+
+	lpm, err := Default(ctx)
+	if !assert.NoError(t, err, "Default should succeed") {
+		return
+	}
+
+	defer lpm.Shutdown(context.TODO())
+
+	dir, err := ioutil.TempDir("", "gola-reng")
+	if !assert.NoError(t, err, "TempDir should succeed") {
+		return
+	}
+
+	defer os.RemoveAll(dir)
+
+	for name, data := range map[string]string{
+		"discount.yaml":      yamlRuleSet,
+		"discount_test.yaml": yamlSuite,
+		"tag.csv":            tagTable,
+		"tag_test.json":      csvSuite,
+	} {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(data), 0644); !assert.NoError(t, err, "WriteFile should succeed") {
+			return
+		}
+	}
+
+	suite, err := LoadSuite(filepath.Join(dir, "discount_test.yaml"))
+	if !assert.NoError(t, err, "LoadSuite should succeed") {
+		return
+	}
+
+	results, err := suite.Run(ctx, lpm)
+	if !assert.NoError(t, err, "Run should succeed") {
+		return
+	}
+
+	if !assert.Len(t, results, 4, "results mismatching") {
+		return
+	}
+
+	for i, name := range []string{"vip", "nobody", "error"} {
+		r := results[[]int{0, 2, 3}[i]]
+		if !assert.Equal(t, name, r.Name, "name mismatching") {
+			return
+		}
+
+		if !assert.True(t, r.Passed, "fixture should passed: %s %v", r.Diff, r.Err) {
+			return
+		}
+	}
+
+	r := results[1]
+	if !assert.False(t, r.Passed, "fixture should failed") {
+		return
+	}
+
+	if !assert.NoError(t, r.Err, "fixture should not raise error") {
+		return
+	}
+
+	if !assert.Contains(t, r.Diff, "-    \"discount\": 0.4\n+    \"discount\": 0.5\n", "diff mismatching") {
+		return
+	}
+
+	suite, err = LoadSuite(filepath.Join(dir, "tag_test.json"))
+	if !assert.NoError(t, err, "LoadSuite should succeed") {
+		return
+	}
+
+	results, err = suite.Run(ctx, lpm)
+	if !assert.NoError(t, err, "Run should succeed") {
+		return
+	}
+
+	if !assert.True(t, results[0].Passed, "fixture should passed: %s", results[0].Diff) {
+		return
+	}
+
+	rs, err := LoadYAML([]byte(yamlRuleSet))
+	if !assert.NoError(t, err, "LoadYAML should succeed") {
+		return
+	}
+
+	results = rs.Test(ctx, lpm, &Fixture{Name: "mode", Mode: "any"})
+	if !assert.Equal(t, ErrFixtureMode, errors.Cause(results[0].Err), "error mismatching") {
+		return
+	}
+
+	_, err = LoadSuite(filepath.Join(dir, "tag.csv"))
+	if !assert.Equal(t, ErrRuleSetFormat, err, "error mismatching") {
+		return
+	}
+
+	err = ioutil.WriteFile(filepath.Join(dir, "empty.yaml"), []byte("cases: []"), 0644)
+	if !assert.NoError(t, err, "WriteFile should succeed") {
+		return
+	}
+
+	_, err = LoadSuite(filepath.Join(dir, "empty.yaml"))
+	if !assert.Equal(t, ErrSuiteRuleSet, errors.Cause(err), "error mismatching") {
+		return
+	}
+}
@@ -0,0 +1,219 @@
+// (c) 2018, Jeff Chen <jefurry@qq.com>
+//
+// This file is part of Gola
+//
+// Copyright 2018 The Gola Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package reng
+
+import (
+	glua "github.com/jefurry/gola/lua"
+	"github.com/pkg/errors"
+	"github.com/yuin/gopher-lua"
+)
+
+const (
+	// module name of base functions in Policy.
+	BaseModule = "_G"
+
+	// The maximum bytes of string returned by string.rep.
+	DefaultMaxRepBytes = 1 << 20
+)
+
+var (
+	ErrPolicyModule = errors.New("unknown module of policy")
+	ErrStringRep    = errors.New("string.rep result too large")
+)
+
+type (
+	// Policy is the sandbox policy of rule engine, it lists the modules opened
+	// and the functions allowed in lua states.
+	Policy struct {
+		// Allowed functions of modules keyed by module name, the functions not listed
+		// are removed, a nil list allows all functions of the module. Modules are the
+		// built-in libraries of gopher-lua, BaseModule for base functions, or the
+		// modules of `Loaders`.
+		// Note: Modules not listed are not opened.
+		Allow map[string][]string
+
+		// Loaders of extra modules keyed by module name, e.g. json.Loader,
+		// the modules are opened as globals instead of by `require`.
+		Loaders map[string]lua.LGFunction
+
+		// The maximum bytes of string returned by string.rep.
+		// Note: A value of 0 indicates no limit.
+		MaxRepBytes int
+	}
+)
+
+// built-in libraries in order of opening.
+var luaLibs = []glua.LuaLib{
+	{LibName: lua.LoadLibName, LibFunc: lua.OpenPackage},
+	{LibName: lua.BaseLibName, LibFunc: lua.OpenBase},
+	{LibName: lua.TabLibName, LibFunc: lua.OpenTable},
+	{LibName: lua.IoLibName, LibFunc: lua.OpenIo},
+	{LibName: lua.OsLibName, LibFunc: lua.OpenOs},
+	{LibName: lua.StringLibName, LibFunc: lua.OpenString},
+	{LibName: lua.MathLibName, LibFunc: lua.OpenMath},
+	{LibName: lua.DebugLibName, LibFunc: lua.OpenDebug},
+	{LibName: lua.ChannelLibName, LibFunc: lua.OpenChannel},
+	{LibName: lua.CoroutineLibName, LibFunc: lua.OpenCoroutine},
+}
+
+// DefaultPolicy returns the default policy, which opens base, table, string and math,
+// allows the base functions without access to environment, metatables or loading code,
+// removes string.dump, and limits string.rep to `DefaultMaxRepBytes`.
+func DefaultPolicy() *Policy {
+	return &Policy{
+		Allow: map[string][]string{
+			BaseModule: []string{
+				"assert", "ipairs", "next", "pairs", "select",
+				"tonumber", "tostring", "type", "unpack",
+			},
+			lua.TabLibName: nil,
+			lua.StringLibName: []string{
+				"byte", "char", "find", "format", "gmatch", "gsub", "len",
+				"lower", "match", "rep", "reverse", "sub", "upper",
+			},
+			lua.MathLibName: nil,
+		},
+		Loaders:     make(map[string]lua.LGFunction),
+		MaxRepBytes: DefaultMaxRepBytes,
+	}
+}
+
+// Check checks that all modules of policy are known.
+func (p *Policy) Check() error {
+	for module := range p.Allow {
+		if _, ok := p.Loaders[module]; ok || module == BaseModule {
+			continue
+		}
+
+		if !isLuaLib(module) {
+			return errors.Wrapf(ErrPolicyModule, "module %q", module)
+		}
+	}
+
+	return nil
+}
+
+// Apply opens modules and removes functions not allowed in lua state,
+// it is used as pm.NewFunc of the pool.
+func (p *Policy) Apply(L *lua.LState) error {
+	if err := p.Check(); err != nil {
+		return err
+	}
+
+	for _, lib := range luaLibs {
+		if !p.allowed(lib.LibName) {
+			continue
+		}
+
+		L.Push(L.NewFunction(lib.LibFunc))
+		L.Push(lua.LString(lib.LibName))
+		L.Call(1, 0)
+	}
+
+	for module, loader := range p.Loaders {
+		if !p.allowed(module) {
+			continue
+		}
+
+		L.Push(L.NewFunction(loader))
+		L.Push(lua.LString(module))
+		L.Call(1, 1)
+		L.SetGlobal(module, L.Get(-1))
+		L.Pop(1)
+	}
+
+	for module, funcs := range p.Allow {
+		if funcs == nil {
+			continue
+		}
+
+		tbl := L.G.Global
+		if module != BaseModule {
+			var ok bool
+			if tbl, ok = L.GetGlobal(module).(*lua.LTable); !ok {
+				continue
+			}
+		}
+
+		removeFuncs(tbl, funcs)
+	}
+
+	if p.MaxRepBytes > 0 {
+		if tbl, ok := L.GetGlobal(lua.StringLibName).(*lua.LTable); ok {
+			limitRep(L, tbl, p.MaxRepBytes)
+		}
+	}
+
+	return nil
+}
+
+// allowed reports whether module is opened.
+func (p *Policy) allowed(module string) bool {
+	if module == lua.BaseLibName {
+		module = BaseModule
+	}
+
+	_, ok := p.Allow[module]
+
+	return ok
+}
+
+func isLuaLib(module string) bool {
+	for _, lib := range luaLibs {
+		if lib.LibName == module && module != lua.BaseLibName {
+			return true
+		}
+	}
+
+	return false
+}
+
+// removeFuncs removes functions of table not in funcs.
+func removeFuncs(tbl *lua.LTable, funcs []string) {
+	allowed := make(map[string]struct{}, len(funcs))
+	for _, fn := range funcs {
+		allowed[fn] = struct{}{}
+	}
+
+	removed := make([]lua.LValue, 0)
+	tbl.ForEach(func(k, v lua.LValue) {
+		if _, ok := v.(*lua.LFunction); !ok {
+			return
+		}
+
+		if _, ok := allowed[k.String()]; !ok {
+			removed = append(removed, k)
+		}
+	})
+
+	for _, k := range removed {
+		tbl.RawSet(k, lua.LNil)
+	}
+}
+
+// limitRep replaces string.rep with a version which raises error if
+// the result is larger than max bytes.
+func limitRep(L *lua.LState, tbl *lua.LTable, max int) {
+	fn, ok := tbl.RawGetString("rep").(*lua.LFunction)
+	if !ok || !fn.IsG {
+		return
+	}
+
+	rep := fn.GFunction
+	tbl.RawSetString("rep", L.NewFunction(func(L *lua.LState) int {
+		s, n := L.CheckString(1), L.OptInt(2, 0)
+		if len(s) > 0 && n > max/len(s) {
+			L.RaiseError("%s", ErrStringRep.Error())
+
+			return 0
+		}
+
+		return rep(L)
+	}))
+}
@@ -0,0 +1,110 @@
+// (c) 2018, Jeff Chen <jefurry@qq.com>
+//
+// This file is part of Gola
+//
+// Copyright 2018 The Gola Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package reng
+
+import (
+	"context"
+	"github.com/jefurry/gola/lua/libs/time"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/yuin/gopher-lua"
+	"testing"
+)
+
+func TestPolicyEscape(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.TODO())
+	defer cancel()
+
+	lpm, err := Default(ctx)
+	if !assert.NoError(t, err, "Default should succeed") {
+		return
+	}
+
+	defer lpm.Shutdown(context.TODO())
+
+	for _, code := range []string{
+		`return string.dump(function() end)`,
+		`return ("").dump(function() end)`,
+		`return getmetatable("").__index`,
+		`return string.rep("x", 2 * 1024 * 1024)`,
+		`return ("xx"):rep(1024 * 1024)`,
+		`return loadstring("return 1")()`,
+		`return load(function() return nil end)`,
+		`return require("os")`,
+		`return os.execute("true")`,
+		`return io.open("/etc/passwd")`,
+		`return debug.getregistry()`,
+		`return coroutine.create(function() end)`,
+		`return _G.setfenv(1, {})`,
+		`return rawset(_G, "x", 1)`,
+		`return collectgarbage("count")`,
+		`return newproxy(true)`,
+		`return dofile("/etc/passwd")`,
+		`return package.loaded`,
+	} {
+		_, err := lpm.DoString(ctx, code)
+		if !assert.Error(t, err, "escape should failed: %s", code) {
+			return
+		}
+	}
+
+	lv, err := lpm.DoString(ctx, `return #string.rep("x", 1024)`, func(L *lua.LState) (lua.LValue, error) {
+		return L.Get(-1), nil
+	})
+	if !assert.NoError(t, err, "DoString should succeed") {
+		return
+	}
+
+	if !assert.Equal(t, lua.LNumber(1024), lv, "value mismatching") {
+		return
+	}
+}
+
+func TestPolicy(t *testing.T) {
+	policy := DefaultPolicy()
+	policy.Allow[BaseModule] = append(policy.Allow[BaseModule], "pcall", "error")
+	policy.Allow[time.TimeLibName] = nil
+	policy.Loaders[time.TimeLibName] = time.Loader
+	policy.MaxRepBytes = 0
+
+	ctx, cancel := context.WithCancel(context.TODO())
+	defer cancel()
+
+	lpm, err := New(ctx, nil, policy)
+	if !assert.NoError(t, err, "New should succeed") {
+		return
+	}
+
+	defer lpm.Shutdown(context.TODO())
+
+	lv, err := lpm.DoString(ctx, `
+	local ok, err = pcall(error, "oops", 0)
+	assert(not ok)
+	assert(type(time.now) == "function")
+	assert(#string.rep("x", 2 * 1024 * 1024) == 2 * 1024 * 1024)
+	assert(string.dump == nil)
+	return err
+	`, func(L *lua.LState) (lua.LValue, error) {
+		return L.Get(-1), nil
+	})
+	if !assert.NoError(t, err, "DoString should succeed") {
+		return
+	}
+
+	if !assert.Equal(t, lua.LString("oops"), lv, "value mismatching") {
+		return
+	}
+
+	policy = DefaultPolicy()
+	policy.Allow["json"] = nil
+	_, err = New(ctx, nil, policy)
+	if !assert.Equal(t, ErrPolicyModule, errors.Cause(err), "error mismatching") {
+		return
+	}
+}
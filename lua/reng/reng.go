@@ -11,31 +11,17 @@ package reng
 
 import (
 	"context"
-	glua "github.com/jefurry/gola/lua"
 	"github.com/jefurry/gola/lua/pm"
 	"github.com/yuin/gopher-lua"
 )
 
-var luaLibs = []glua.LuaLib{
-	glua.LuaLib{lua.BaseLibName, lua.OpenBase},
-	glua.LuaLib{lua.TabLibName, lua.OpenTable},
-	glua.LuaLib{lua.StringLibName, lua.OpenString},
-	glua.LuaLib{lua.MathLibName, lua.OpenMath},
-}
-
-func whenNew(L *lua.LState) error {
-	for _, lib := range luaLibs {
-		L.Push(L.NewFunction(lib.LibFunc))
-		L.Push(lua.LString(lib.LibName))
-		L.Call(1, 0)
-	}
-
-	excludeBaseLibFuncs(L)
-
-	return nil
-}
+// Default quotas of requests, since rule scripts are untrusted.
+const (
+	DefaultMaxInstructions = 10000000
+	DefaultMaxMemory       = 64 << 20
+)
 
-func Default(ctx context.Context) (*pm.LPM, error) {
+func Default(ctx context.Context, policies ...*Policy) (*pm.LPM, error) {
 	options := lua.Options{}
 	options.SkipOpenLibs = true
 
@@ -45,59 +31,37 @@ func Default(ctx context.Context) (*pm.LPM, error) {
 		return nil, err
 	}
 
-	return pm.New(ctx, config, whenNew)
+	config.SetMaxInstructions(DefaultMaxInstructions)
+	config.SetMaxMemory(DefaultMaxMemory)
+	// rule sets are compiled once.
+	config.SetChunkCache(true)
+
+	return newLPM(ctx, config, policies...)
 }
 
-func New(ctx context.Context, config *pm.Config) (*pm.LPM, error) {
+// New creates rule engine with config, the lua states are sandboxed by
+// the first policy, or DefaultPolicy if no policy is given.
+func New(ctx context.Context, config *pm.Config, policies ...*Policy) (*pm.LPM, error) {
 	if config == nil {
-		return Default(ctx)
+		return Default(ctx, policies...)
 	}
 
 	options := config.Options()
 	options.SkipOpenLibs = true
 	config.SetOptions(options)
 
-	return pm.New(ctx, config, whenNew)
+	return newLPM(ctx, config, policies...)
 }
 
-func excludeBaseLibFuncs(L *lua.LState) {
-	g := L.GetGlobal("_G").(*lua.LTable)
+func newLPM(ctx context.Context, config *pm.Config, policies ...*Policy) (*pm.LPM, error) {
+	policy := DefaultPolicy()
+	if len(policies) > 0 && policies[0] != nil {
+		policy = policies[0]
+	}
 
-	for _, v := range baseLibFuncs {
-		g.RawSetString(v, lua.LNil)
+	if err := policy.Check(); err != nil {
+		return nil, err
 	}
-}
 
-// keeps "next", "select", "tonumber", "tostring"
-// type", "unpack", "ipairs", "pairs", "assert"
-var baseLibFuncs = []string{
-	//"assert",
-	"collectgarbage",
-	"dofile",
-	"error",
-	"getfenv",
-	"getmetatable",
-	"load",
-	"loadfile",
-	"loadstring",
-	//"next",
-	"pcall",
-	"print",
-	"rawequal",
-	"rawget",
-	"rawset",
-	//"select",
-	"_printregs",
-	"setfenv",
-	"setmetatable",
-	//"tonumber",
-	//"tostring",
-	//"type",
-	//"unpack",
-	"xpcall",
-	// loadlib
-	"module",
-	"require",
-	// hidden features
-	"newproxy",
+	return pm.New(ctx, config, policy.Apply)
 }
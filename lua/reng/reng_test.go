@@ -10,6 +10,7 @@ package reng
 
 import (
 	"context"
+	"github.com/jefurry/gola/lua/pm"
 	"github.com/stretchr/testify/assert"
 	"github.com/yuin/gopher-lua"
 	"testing"
@@ -40,7 +41,7 @@ func TestDefault(t *testing.T) {
 		return
 	}
 
-	lpm.Shutdown()
+	lpm.Shutdown(context.TODO())
 }
 
 func TestWithLuaCode(t *testing.T) {
@@ -137,7 +138,7 @@ func TestWithLuaCode(t *testing.T) {
 		return
 	}
 
-	lpm.Shutdown()
+	lpm.Shutdown(context.TODO())
 
 	if !assert.Equal(t, 0, lpm.ServingNum(), "servingNum mismatching") {
 		return
@@ -147,3 +148,29 @@ func TestWithLuaCode(t *testing.T) {
 		return
 	}
 }
+
+func TestQuota(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.TODO())
+	defer cancel()
+
+	lpm, err := Default(ctx)
+	if !assert.NoError(t, err, "Default should succeed") {
+		return
+	}
+
+	defer lpm.Shutdown(context.TODO())
+
+	if !assert.Equal(t, DefaultMaxInstructions, lpm.Config().MaxInstructions(), "maxInstructions mismatching") {
+		return
+	}
+
+	_, err = lpm.DoString(ctx, `local s = string.rep("x", 1e10)`)
+	if !assert.Equal(t, &pm.QuotaError{Quota: pm.QuotaMemory, Limit: DefaultMaxMemory}, err, "error mismatching") {
+		return
+	}
+
+	_, err = lpm.DoString(ctx, `local s = ("x"):rep(1e10)`)
+	if !assert.Equal(t, &pm.QuotaError{Quota: pm.QuotaMemory, Limit: DefaultMaxMemory}, err, "error mismatching") {
+		return
+	}
+}
@@ -0,0 +1,364 @@
+// (c) 2018, Jeff Chen <jefurry@qq.com>
+//
+// This file is part of Gola
+//
+// Copyright 2018 The Gola Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package reng
+
+import (
+	"context"
+	"github.com/jefurry/gola/lua/pm"
+	"github.com/pkg/errors"
+	"io/ioutil"
+	"math/rand"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+var (
+	ErrRepoRuleSet       = errors.New("rule set not found in repository")
+	ErrRepoRuleSetName   = errors.New("rule set name is empty")
+	ErrRepoVersion       = errors.New("rule set version not found in repository")
+	ErrRepoVersionExists = errors.New("rule set version already exists in repository")
+	ErrRepoVersionActive = errors.New("rule set version is serving traffic")
+	ErrRepoCanaryPercent = errors.New("canary percent must be between 0 and 100")
+	ErrRepoDuplicate     = errors.New("duplicate rule set name in directory")
+)
+
+type (
+	// Repository holds named versions of rule sets, and routes evaluations to
+	// the active version of rule set, or to the canary version for a percentage
+	// of evaluations. Versions are switched atomically, the evaluations in flight
+	// keep running with the version they started with.
+	Repository struct {
+		lpm  *pm.LPM
+		lock sync.RWMutex
+		sets map[string]*repoEntry
+	}
+
+	// repoEntry is the versions and route of a rule set.
+	repoEntry struct {
+		versions map[string]*repoVersion
+		active   *repoVersion
+		canary   *repoVersion
+		// percentage of evaluations routed to canary version.
+		percent int
+	}
+
+	repoVersion struct {
+		name string
+		rs   *RuleSet
+		// evaluations in flight.
+		inflight sync.WaitGroup
+	}
+)
+
+// NewRepository creates rule set repository, the rule sets are evaluated
+// with the lua states of lpm.
+func NewRepository(lpm *pm.LPM) *Repository {
+	return &Repository{
+		lpm:  lpm,
+		sets: make(map[string]*repoEntry),
+	}
+}
+
+// Add adds version of rule set, keyed by name of rule set.
+// Note: The first version of rule set is activated.
+func (r *Repository) Add(version string, rs *RuleSet) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if rs.Name == "" {
+		return ErrRepoRuleSetName
+	}
+
+	if err := r.check(version, rs.Name); err != nil {
+		return err
+	}
+
+	r.add(version, rs)
+
+	return nil
+}
+
+// LoadDir loads version of the rule sets in directory, the files of ".yaml",
+// ".yml", ".json" or ".lua" extension, and returns names of the rule sets.
+// Rule sets without name are named by the file name without extension.
+// Nothing is added if any rule set fails to load.
+func (r *Repository) LoadDir(version, dir string) ([]string, error) {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	sets := make(map[string]*RuleSet)
+	names := make([]string, 0)
+	for _, fi := range files {
+		switch strings.ToLower(filepath.Ext(fi.Name())) {
+		case ".yaml", ".yml", ".json", ".lua":
+		default:
+			continue
+		}
+
+		if fi.IsDir() {
+			continue
+		}
+
+		rs, err := LoadFile(filepath.Join(dir, fi.Name()))
+		if err != nil {
+			return nil, errors.Wrapf(err, "rule set file %q", fi.Name())
+		}
+
+		if rs.Name == "" {
+			rs.Name = strings.TrimSuffix(fi.Name(), filepath.Ext(fi.Name()))
+		}
+
+		if _, ok := sets[rs.Name]; ok {
+			return nil, errors.Wrapf(ErrRepoDuplicate, "rule set %q", rs.Name)
+		}
+
+		sets[rs.Name] = rs
+		names = append(names, rs.Name)
+	}
+	sort.Strings(names)
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	for _, name := range names {
+		if err := r.check(version, name); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, name := range names {
+		r.add(version, sets[name])
+	}
+
+	return names, nil
+}
+
+// Activate routes all evaluations of rule set to version, and stops canary.
+func (r *Repository) Activate(name, version string) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	entry, v, err := r.version(name, version)
+	if err != nil {
+		return err
+	}
+
+	entry.active = v
+	entry.canary = nil
+	entry.percent = 0
+
+	return nil
+}
+
+// Canary routes percent of evaluations of rule set to version, and the
+// others to the active version. A percent of 0 stops canary.
+func (r *Repository) Canary(name, version string, percent int) error {
+	if percent < 0 || percent > 100 {
+		return ErrRepoCanaryPercent
+	}
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	entry, v, err := r.version(name, version)
+	if err != nil {
+		return err
+	}
+
+	if percent == 0 {
+		v = nil
+	}
+
+	entry.canary = v
+	entry.percent = percent
+
+	return nil
+}
+
+// Active returns the active and canary versions of rule set, and the percentage
+// of evaluations routed to canary version.
+func (r *Repository) Active(name string) (string, string, int, error) {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+
+	entry, ok := r.sets[name]
+	if !ok {
+		return "", "", 0, errors.Wrapf(ErrRepoRuleSet, "rule set %q", name)
+	}
+
+	canary := ""
+	if entry.canary != nil {
+		canary = entry.canary.name
+	}
+
+	return entry.active.name, canary, entry.percent, nil
+}
+
+// Versions returns versions of rule set in order.
+func (r *Repository) Versions(name string) []string {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+
+	versions := make([]string, 0)
+	if entry, ok := r.sets[name]; ok {
+		for version := range entry.versions {
+			versions = append(versions, version)
+		}
+	}
+	sort.Strings(versions)
+
+	return versions
+}
+
+// Names returns names of rule sets in order.
+func (r *Repository) Names() []string {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+
+	names := make([]string, 0, len(r.sets))
+	for name := range r.sets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// Retire removes version of rule set which is not serving traffic, and waits
+// until the evaluations in flight with the version finish or ctx is done.
+// The compiled chunk of version is removed from chunk cache of pool once the
+// evaluations finish.
+// Note: The version is removed even if ctx is done, the error of ctx is returned.
+func (r *Repository) Retire(ctx context.Context, name, version string) error {
+	r.lock.Lock()
+	entry, v, err := r.version(name, version)
+	if err == nil && (v == entry.active || v == entry.canary) {
+		err = errors.Wrapf(ErrRepoVersionActive, "rule set %q version %q", name, version)
+	}
+
+	if err != nil {
+		r.lock.Unlock()
+
+		return err
+	}
+
+	delete(entry.versions, version)
+	r.lock.Unlock()
+
+	// the chunk is invalidated once the evaluations finish, even if ctx is done.
+	done := make(chan struct{})
+	go func() {
+		v.inflight.Wait()
+		r.lpm.InvalidateStrings(v.rs.source)
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-ctx.Done():
+		return errors.Wrapf(ctx.Err(), "rule set %q version %q is removed, and its chunk is invalidated after the evaluations in flight", name, version)
+	}
+
+	return nil
+}
+
+// Eval evaluates the routed version of rule set against facts like RuleSet.Eval,
+// the version is returned in result.
+func (r *Repository) Eval(ctx context.Context, name string, facts map[string]interface{}) (*Result, error) {
+	return r.run(name, func(rs *RuleSet) (*Result, error) {
+		return rs.Eval(ctx, r.lpm, facts)
+	})
+}
+
+// Infer evaluates the routed version of rule set against facts like RuleSet.Infer,
+// the version is returned in result.
+func (r *Repository) Infer(ctx context.Context, name string, facts map[string]interface{}) (*Result, error) {
+	return r.run(name, func(rs *RuleSet) (*Result, error) {
+		return rs.Infer(ctx, r.lpm, facts)
+	})
+}
+
+// run runs fn with the routed version of rule set.
+func (r *Repository) run(name string, fn func(*RuleSet) (*Result, error)) (*Result, error) {
+	r.lock.RLock()
+	entry, ok := r.sets[name]
+	if !ok {
+		r.lock.RUnlock()
+
+		return nil, errors.Wrapf(ErrRepoRuleSet, "rule set %q", name)
+	}
+
+	v := entry.active
+	if entry.canary != nil && rand.Intn(100) < entry.percent {
+		v = entry.canary
+	}
+
+	// added under lock, so that Retire never misses it.
+	v.inflight.Add(1)
+	r.lock.RUnlock()
+
+	defer v.inflight.Done()
+
+	result, err := fn(v.rs)
+	if err != nil {
+		return nil, err
+	}
+
+	result.Version = v.name
+
+	return result, nil
+}
+
+// check checks that version of rule set can be added.
+func (r *Repository) check(version, name string) error {
+	if entry, ok := r.sets[name]; ok {
+		if _, ok := entry.versions[version]; ok {
+			return errors.Wrapf(ErrRepoVersionExists, "rule set %q version %q", name, version)
+		}
+	}
+
+	return nil
+}
+
+func (r *Repository) add(version string, rs *RuleSet) {
+	v := &repoVersion{
+		name: version,
+		rs:   rs,
+	}
+
+	entry, ok := r.sets[rs.Name]
+	if !ok {
+		entry = &repoEntry{
+			versions: make(map[string]*repoVersion),
+			active:   v,
+		}
+		r.sets[rs.Name] = entry
+	}
+
+	entry.versions[version] = v
+}
+
+// version returns entry and version of rule set.
+func (r *Repository) version(name, version string) (*repoEntry, *repoVersion, error) {
+	entry, ok := r.sets[name]
+	if !ok {
+		return nil, nil, errors.Wrapf(ErrRepoRuleSet, "rule set %q", name)
+	}
+
+	v, ok := entry.versions[version]
+	if !ok {
+		return nil, nil, errors.Wrapf(ErrRepoVersion, "rule set %q version %q", name, version)
+	}
+
+	return entry, v, nil
+}
@@ -0,0 +1,219 @@
+// (c) 2018, Jeff Chen <jefurry@qq.com>
+//
+// This file is part of Gola
+//
+// Copyright 2018 The Gola Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package reng
+
+import (
+	"context"
+	"fmt"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestRepository(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.TODO())
+	defer cancel()
+
+	lpm, err := Default(ctx)
+	if !assert.NoError(t, err, "Default should succeed") {
+		return
+	}
+
+	defer lpm.Shutdown(context.TODO())
+
+	dir, err := ioutil.TempDir("", "gola-reng")
+	if !assert.NoError(t, err, "TempDir should succeed") {
+		return
+	}
+
+	defer os.RemoveAll(dir)
+
+	for name, source := range map[string]string{
+		"discount.yaml": yamlRuleSet,
+		"level.lua": `return {rules = {
+			{name = "high", condition = "facts.score > 80", action = "facts.level = 'high'"},
+		}}`,
+		"README.md": "not a rule set",
+	} {
+		if !assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, name), []byte(source), 0644), "WriteFile should succeed") {
+			return
+		}
+	}
+
+	repo := NewRepository(lpm)
+	names, err := repo.LoadDir("v1", dir)
+	if !assert.NoError(t, err, "LoadDir should succeed") {
+		return
+	}
+
+	if !assert.Equal(t, []string{"discount", "level"}, names, "names mismatching") {
+		return
+	}
+
+	_, err = repo.LoadDir("v1", dir)
+	if !assert.Equal(t, ErrRepoVersionExists, errors.Cause(err), "error mismatching") {
+		return
+	}
+
+	rs, err := NewRuleSet("discount", &Rule{Name: "all", Condition: "true", Action: "facts.discount = 0.1"})
+	if !assert.NoError(t, err, "NewRuleSet should succeed") {
+		return
+	}
+
+	if !assert.NoError(t, repo.Add("v2", rs), "Add should succeed") {
+		return
+	}
+
+	if !assert.Equal(t, []string{"v1", "v2"}, repo.Versions("discount"), "versions mismatching") {
+		return
+	}
+
+	eval := func(version string, fired ...string) bool {
+		result, err := repo.Eval(ctx, "discount", map[string]interface{}{"age": 30})
+		if !assert.NoError(t, err, "Eval should succeed") {
+			return false
+		}
+
+		if !assert.Equal(t, version, result.Version, "version mismatching") {
+			return false
+		}
+
+		return assert.Equal(t, fired, result.Fired, "fired rules mismatching")
+	}
+
+	if !eval("v1", "adult") {
+		return
+	}
+
+	if !assert.NoError(t, repo.Canary("discount", "v2", 100), "Canary should succeed") {
+		return
+	}
+
+	if !eval("v2", "all") {
+		return
+	}
+
+	active, canary, percent, err := repo.Active("discount")
+	if !assert.NoError(t, err, "Active should succeed") {
+		return
+	}
+
+	if !assert.Equal(t, []interface{}{"v1", "v2", 100}, []interface{}{active, canary, percent}, "route mismatching") {
+		return
+	}
+
+	// about half of evaluations are routed to canary.
+	if !assert.NoError(t, repo.Canary("discount", "v2", 50), "Canary should succeed") {
+		return
+	}
+
+	counts := make(map[string]int)
+	for i := 0; i < 200; i++ {
+		result, err := repo.Eval(ctx, "discount", map[string]interface{}{"age": 30})
+		if !assert.NoError(t, err, "Eval should succeed") {
+			return
+		}
+
+		counts[result.Version] += 1
+	}
+
+	if !assert.True(t, counts["v1"] > 50 && counts["v2"] > 50, "canary split mismatching: %v", counts) {
+		return
+	}
+
+	if !assert.Equal(t, ErrRepoCanaryPercent, repo.Canary("discount", "v2", 101), "error mismatching") {
+		return
+	}
+
+	if !assert.NoError(t, repo.Activate("discount", "v2"), "Activate should succeed") {
+		return
+	}
+
+	if !eval("v2", "all") {
+		return
+	}
+
+	if !assert.Equal(t, ErrRepoVersionActive, errors.Cause(repo.Retire(ctx, "discount", "v2")), "error mismatching") {
+		return
+	}
+
+	// retiring waits for evaluations in flight.
+	v1 := repo.sets["discount"].versions["v1"]
+	v1.inflight.Add(1)
+
+	tctx, tcancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer tcancel()
+
+	size := lpm.ChunkCacheStats().Size
+	if !assert.Equal(t, context.DeadlineExceeded, errors.Cause(repo.Retire(tctx, "discount", "v1")), "error mismatching") {
+		return
+	}
+
+	if !assert.Equal(t, []string{"v2"}, repo.Versions("discount"), "versions mismatching") {
+		return
+	}
+
+	// the chunk is invalidated after the evaluations finish.
+	v1.inflight.Done()
+	for i := 0; i < 100 && lpm.ChunkCacheStats().Size == size; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if !assert.Equal(t, size-1, lpm.ChunkCacheStats().Size, "size of chunk cache mismatching") {
+		return
+	}
+
+	_, err = repo.Eval(ctx, "any", nil)
+	if !assert.Equal(t, ErrRepoRuleSet, errors.Cause(err), "error mismatching") {
+		return
+	}
+
+	if !assert.Equal(t, ErrRepoVersion, errors.Cause(repo.Activate("level", "v2")), "error mismatching") {
+		return
+	}
+
+	// switches versions while evaluating.
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			for j := 0; j < 50; j++ {
+				if _, err := repo.Eval(ctx, "level", map[string]interface{}{"score": 90}); err != nil {
+					t.Error(err)
+
+					return
+				}
+			}
+		}()
+	}
+
+	for i := 0; i < 20; i++ {
+		version := fmt.Sprintf("v%d", i+2)
+		rs, err := NewRuleSet("level", &Rule{Name: "high", Condition: "facts.score > 80", Action: "facts.level = 'high'"})
+		if !assert.NoError(t, err, "NewRuleSet should succeed") {
+			return
+		}
+
+		if !assert.NoError(t, repo.Add(version, rs), "Add should succeed") {
+			return
+		}
+
+		if !assert.NoError(t, repo.Activate("level", version), "Activate should succeed") {
+			return
+		}
+	}
+	wg.Wait()
+}
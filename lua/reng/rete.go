@@ -0,0 +1,405 @@
+// (c) 2018, Jeff Chen <jefurry@qq.com>
+//
+// This file is part of Gola
+//
+// Copyright 2018 The Gola Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package reng
+
+import (
+	"context"
+	"github.com/jefurry/gola/lua/pm"
+	"github.com/pkg/errors"
+	"github.com/yuin/gopher-lua"
+	"github.com/yuin/gopher-lua/ast"
+	"github.com/yuin/gopher-lua/parse"
+	"sort"
+	"strings"
+)
+
+const (
+	DefaultMaxCycles = 1000
+)
+
+var (
+	ErrMaxCycles = errors.New("rule set exceeded max cycles")
+)
+
+type (
+	// network indexes rules by the fact fields read by their conditions,
+	// so that only conditions touching changed fields are re-evaluated.
+	network struct {
+		// rules whose conditions read the field, keyed by top-level field of facts.
+		alpha map[string][]int
+		// rules whose conditions depend on whole facts.
+		any []int
+		// fact fields read by condition of rules, nil if it depends on whole facts.
+		reads [][]string
+	}
+
+	// agenda is the activated rules waiting to fire, ordered by salience.
+	agenda struct {
+		activated []bool
+		n         int
+	}
+)
+
+func newNetwork(rules []*Rule) *network {
+	net := &network{
+		alpha: make(map[string][]int),
+		any:   make([]int, 0),
+		reads: make([][]string, len(rules)),
+	}
+
+	for i, rule := range rules {
+		fields, all := conditionFields(rule.Condition)
+		if all {
+			net.any = append(net.any, i)
+
+			continue
+		}
+
+		sort.Strings(fields)
+		net.reads[i] = fields
+		for _, field := range fields {
+			net.alpha[field] = append(net.alpha[field], i)
+		}
+	}
+
+	return net
+}
+
+// watchAll reports whether changes of any fields must be detected.
+func (net *network) watchAll() bool {
+	return len(net.any) > 0
+}
+
+// affected returns the rules whose conditions read changed fields.
+func (net *network) affected(changed []string) []int {
+	if len(changed) == 0 {
+		return nil
+	}
+
+	seen := make(map[int]struct{})
+	rules := make([]int, 0)
+	add := func(i int) {
+		if _, ok := seen[i]; !ok {
+			seen[i] = struct{}{}
+			rules = append(rules, i)
+		}
+	}
+
+	for _, field := range changed {
+		for _, i := range net.alpha[field] {
+			add(i)
+		}
+	}
+
+	for _, i := range net.any {
+		add(i)
+	}
+
+	return rules
+}
+
+func newAgenda(n int) *agenda {
+	return &agenda{
+		activated: make([]bool, n),
+	}
+}
+
+func (a *agenda) push(i int) {
+	if !a.activated[i] {
+		a.activated[i] = true
+		a.n += 1
+	}
+}
+
+func (a *agenda) remove(i int) {
+	if a.activated[i] {
+		a.activated[i] = false
+		a.n -= 1
+	}
+}
+
+// pop removes and returns the activated rule with highest salience,
+// rules are sorted by salience already.
+func (a *agenda) pop() int {
+	for i, ok := range a.activated {
+		if ok {
+			a.remove(i)
+
+			return i
+		}
+	}
+
+	return -1
+}
+
+func (a *agenda) len() int {
+	return a.n
+}
+
+// Infer evaluates rules against facts with forward chaining. The activated rules
+// are fired one by one in order of salience, after each firing, the conditions
+// reading the fact fields changed by the action are re-evaluated to activate or
+// deactivate rules. A fired rule is not activated again unless the fields read
+// by its condition changed, and never by its own action if `NoLoop` is set.
+// It returns ErrMaxCycles if rules fired more than `MaxCycles` times.
+func (rs *RuleSet) Infer(ctx context.Context, lpm *pm.LPM, facts map[string]interface{}) (*Result, error) {
+	maxCycles := rs.MaxCycles
+	if maxCycles <= 0 {
+		maxCycles = DefaultMaxCycles
+	}
+
+	return rs.run(ctx, lpm, facts, traceModeInfer, func(s *session) error {
+		agenda := newAgenda(len(rs.Rules))
+		for i := range rs.Rules {
+			yes, err := s.condition(i)
+			if err != nil {
+				return err
+			}
+
+			if yes {
+				agenda.push(i)
+			}
+		}
+
+		for cycles := 0; agenda.len() > 0; cycles++ {
+			if cycles >= maxCycles {
+				return ErrMaxCycles
+			}
+
+			i := agenda.pop()
+			changed, err := s.action(i, true)
+			if err != nil {
+				return err
+			}
+
+			for _, j := range rs.net.affected(changed) {
+				if j == i && rs.Rules[i].NoLoop {
+					continue
+				}
+
+				yes, err := s.condition(j)
+				if err != nil {
+					return err
+				}
+
+				if yes {
+					agenda.push(j)
+				} else {
+					agenda.remove(j)
+				}
+			}
+		}
+
+		return nil
+	})
+}
+
+// snapshot copies the fact fields watched by network, or all fields if all is true.
+func (s *session) snapshot(all bool) map[lua.LValue]lua.LValue {
+	net := s.rs.net
+	facts := make(map[lua.LValue]lua.LValue)
+
+	if all || net.watchAll() {
+		s.facts.ForEach(func(k, v lua.LValue) {
+			facts[k] = copyValue(s.L, v, make(map[*lua.LTable]*lua.LTable))
+		})
+
+		return facts
+	}
+
+	for field := range net.alpha {
+		k := lua.LString(field)
+		facts[k] = copyValue(s.L, s.facts.RawGet(k), make(map[*lua.LTable]*lua.LTable))
+	}
+
+	return facts
+}
+
+// changed returns the fact fields changed since snapshot.
+func (s *session) changed(before map[lua.LValue]lua.LValue, all bool) []string {
+	fields := make([]string, 0)
+	for k, v := range before {
+		if !equalValue(v, s.facts.RawGet(k), make(map[*lua.LTable]*lua.LTable)) {
+			fields = append(fields, k.String())
+		}
+	}
+
+	if all || s.rs.net.watchAll() {
+		s.facts.ForEach(func(k, v lua.LValue) {
+			if _, ok := before[k]; !ok {
+				fields = append(fields, k.String())
+			}
+		})
+	}
+
+	return fields
+}
+
+// conditionFields returns the top-level fact fields read by condition, all is
+// true if the condition depends on whole facts, e.g. passes facts to a function.
+func conditionFields(condition string) ([]string, bool) {
+	chunk, err := parse.Parse(strings.NewReader(conditionSource(condition)), "")
+	if err != nil {
+		return nil, true
+	}
+
+	all := false
+	seen := make(map[string]struct{})
+	visit := func(expr ast.Expr) bool {
+		switch e := expr.(type) {
+		case *ast.IdentExpr:
+			if e.Value == factsName {
+				all = true
+			}
+		case *ast.AttrGetExpr:
+			ident, ok := e.Object.(*ast.IdentExpr)
+			if !ok || ident.Value != factsName {
+				break
+			}
+
+			if key, ok := e.Key.(*ast.StringExpr); ok {
+				seen[key.Value] = struct{}{}
+			} else {
+				all = true
+			}
+
+			return false
+		case *ast.FunctionExpr:
+			// body of function is not analysed.
+			all = true
+
+			return false
+		}
+
+		return true
+	}
+
+	for _, stmt := range chunk {
+		ret, ok := stmt.(*ast.ReturnStmt)
+		if !ok {
+			return nil, true
+		}
+
+		for _, expr := range ret.Exprs {
+			walkExpr(expr, visit)
+		}
+	}
+
+	if all {
+		return nil, true
+	}
+
+	fields := make([]string, 0, len(seen))
+	for field := range seen {
+		fields = append(fields, field)
+	}
+
+	return fields, false
+}
+
+// walkExpr calls visit for expr and its sub-expressions in depth-first order,
+// the sub-expressions are skipped if visit returns false.
+// Note: Statements of function bodies are not walked.
+func walkExpr(expr ast.Expr, visit func(ast.Expr) bool) {
+	if expr == nil || !visit(expr) {
+		return
+	}
+
+	switch e := expr.(type) {
+	case *ast.AttrGetExpr:
+		walkExpr(e.Object, visit)
+		walkExpr(e.Key, visit)
+	case *ast.TableExpr:
+		for _, field := range e.Fields {
+			walkExpr(field.Key, visit)
+			walkExpr(field.Value, visit)
+		}
+	case *ast.FuncCallExpr:
+		walkExpr(e.Func, visit)
+		walkExpr(e.Receiver, visit)
+		for _, arg := range e.Args {
+			walkExpr(arg, visit)
+		}
+	case *ast.LogicalOpExpr:
+		walkExpr(e.Lhs, visit)
+		walkExpr(e.Rhs, visit)
+	case *ast.RelationalOpExpr:
+		walkExpr(e.Lhs, visit)
+		walkExpr(e.Rhs, visit)
+	case *ast.StringConcatOpExpr:
+		walkExpr(e.Lhs, visit)
+		walkExpr(e.Rhs, visit)
+	case *ast.ArithmeticOpExpr:
+		walkExpr(e.Lhs, visit)
+		walkExpr(e.Rhs, visit)
+	case *ast.UnaryMinusOpExpr:
+		walkExpr(e.Expr, visit)
+	case *ast.UnaryNotOpExpr:
+		walkExpr(e.Expr, visit)
+	case *ast.UnaryLenOpExpr:
+		walkExpr(e.Expr, visit)
+	}
+}
+
+// copyValue deeply copies lua tables.
+func copyValue(L *lua.LState, lv lua.LValue, copied map[*lua.LTable]*lua.LTable) lua.LValue {
+	tbl, ok := lv.(*lua.LTable)
+	if !ok {
+		return lv
+	}
+
+	if c, ok := copied[tbl]; ok {
+		return c
+	}
+
+	c := L.NewTable()
+	copied[tbl] = c
+	tbl.ForEach(func(k, v lua.LValue) {
+		c.RawSet(k, copyValue(L, v, copied))
+	})
+
+	return c
+}
+
+// equalValue deeply compares lua tables.
+func equalValue(a, b lua.LValue, compared map[*lua.LTable]*lua.LTable) bool {
+	ta, ok := a.(*lua.LTable)
+	if !ok {
+		return a == b
+	}
+
+	tb, ok := b.(*lua.LTable)
+	if !ok {
+		return false
+	}
+
+	if c, ok := compared[ta]; ok {
+		return c == tb
+	}
+	compared[ta] = tb
+
+	n := 0
+	equal := true
+	ta.ForEach(func(k, v lua.LValue) {
+		n += 1
+		if equal && !equalValue(v, tb.RawGet(k), compared) {
+			equal = false
+		}
+	})
+
+	if !equal {
+		return false
+	}
+
+	tb.ForEach(func(k, v lua.LValue) {
+		n -= 1
+	})
+
+	return n == 0
+}
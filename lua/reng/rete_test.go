@@ -0,0 +1,186 @@
+// (c) 2018, Jeff Chen <jefurry@qq.com>
+//
+// This file is part of Gola
+//
+// Copyright 2018 The Gola Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package reng
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"sort"
+	"testing"
+)
+
+func TestConditionFields(t *testing.T) {
+	for _, v := range []struct {
+		condition string
+		fields    []string
+		all       bool
+	}{
+		{`facts.a > 1 and facts["b"] == facts.c.d`, []string{"a", "b", "c"}, false},
+		{`#facts.items > 0 and string.len(facts.name) > 1`, []string{"items", "name"}, false},
+		{`true`, []string{}, false},
+		{`check(facts)`, nil, true},
+		{`facts[key] ~= nil`, nil, true},
+		{`(function() return facts.a end)()`, nil, true},
+	} {
+		fields, all := conditionFields(v.condition)
+		sort.Strings(fields)
+
+		if !assert.Equal(t, v.all, all, "all mismatching") {
+			return
+		}
+
+		if !assert.Equal(t, v.fields, fields, "fields mismatching") {
+			return
+		}
+	}
+}
+
+func TestInfer(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.TODO())
+	defer cancel()
+
+	lpm, err := Default(ctx)
+	if !assert.NoError(t, err, "Default should succeed") {
+		return
+	}
+
+	defer lpm.Shutdown(context.TODO())
+
+	// chaining.
+	rs, err := LoadYAML([]byte(`
+name: order
+rules:
+  - name: final
+    condition: facts.discount ~= nil
+    action: facts.final = facts.total - facts.discount
+  - name: discount
+    condition: facts.total ~= nil and facts.total > 100
+    action: facts.discount = 10
+  - name: total
+    condition: facts.items ~= nil and facts.total == nil
+    action: |
+      local total = 0
+      for _, item in ipairs(facts.items) do total = total + item.price end
+      facts.total = total
+`))
+	if !assert.NoError(t, err, "LoadYAML should succeed") {
+		return
+	}
+
+	facts := map[string]interface{}{
+		"items": []map[string]interface{}{{"price": 60}, {"price": 70}},
+	}
+
+	result, err := rs.Infer(ctx, lpm, facts)
+	if !assert.NoError(t, err, "Infer should succeed") {
+		return
+	}
+
+	if !assert.Equal(t, []string{"total", "discount", "final"}, result.Fired, "fired rules mismatching") {
+		return
+	}
+
+	if !assert.Equal(t, float64(120), result.Facts["final"], "facts mismatching") {
+		return
+	}
+
+	// Eval does not chain.
+	result, err = rs.Eval(ctx, lpm, facts)
+	if !assert.NoError(t, err, "Eval should succeed") {
+		return
+	}
+
+	if !assert.Equal(t, []string{"total"}, result.Fired, "fired rules mismatching") {
+		return
+	}
+
+	// salience and deactivation.
+	rs, err = NewRuleSet("status",
+		&Rule{Name: "reject", Condition: `facts.status == "new"`, Action: `facts.status = "rejected"`},
+		&Rule{Name: "approve", Salience: 10, Condition: `facts.status == "new"`, Action: `facts.status = "approved"`},
+	)
+	if !assert.NoError(t, err, "NewRuleSet should succeed") {
+		return
+	}
+
+	result, err = rs.Infer(ctx, lpm, map[string]interface{}{"status": "new"})
+	if !assert.NoError(t, err, "Infer should succeed") {
+		return
+	}
+
+	if !assert.Equal(t, []string{"approve"}, result.Fired, "fired rules mismatching") {
+		return
+	}
+
+	if !assert.Equal(t, "approved", result.Facts["status"], "facts mismatching") {
+		return
+	}
+
+	// loop and no-loop.
+	for _, v := range []struct {
+		noLoop bool
+		fired  int
+	}{
+		{false, 5},
+		{true, 1},
+	} {
+		rs, err = NewRuleSet("counter", &Rule{
+			Name:      "inc",
+			Condition: `facts.n < 5`,
+			Action:    `facts.n = facts.n + 1`,
+			NoLoop:    v.noLoop,
+		})
+		if !assert.NoError(t, err, "NewRuleSet should succeed") {
+			return
+		}
+
+		result, err = rs.Infer(ctx, lpm, map[string]interface{}{"n": 0})
+		if !assert.NoError(t, err, "Infer should succeed") {
+			return
+		}
+
+		if !assert.Equal(t, v.fired, len(result.Fired), "fired rules mismatching") {
+			return
+		}
+	}
+
+	// max cycles.
+	rs, err = NewRuleSet("endless", &Rule{
+		Name:      "inc",
+		Condition: `facts.n >= 0`,
+		Action:    `facts.n = facts.n + 1`,
+	})
+	if !assert.NoError(t, err, "NewRuleSet should succeed") {
+		return
+	}
+
+	rs.MaxCycles = 10
+	_, err = rs.Infer(ctx, lpm, map[string]interface{}{"n": 0})
+	if !assert.Equal(t, ErrMaxCycles, err, "error mismatching") {
+		return
+	}
+
+	// conditions depending on whole facts.
+	rs, err = NewRuleSet("nested",
+		&Rule{Name: "age", Condition: `next(facts) ~= nil and facts.user.age == nil`, Action: `facts.user.age = 20`},
+		&Rule{Name: "adult", Condition: `(facts.user.age or 0) >= 18`, Action: `facts.adult = true`},
+	)
+	if !assert.NoError(t, err, "NewRuleSet should succeed") {
+		return
+	}
+
+	result, err = rs.Infer(ctx, lpm, map[string]interface{}{"user": map[string]interface{}{}})
+	if !assert.NoError(t, err, "Infer should succeed") {
+		return
+	}
+
+	if !assert.Equal(t, []string{"age", "adult"}, result.Fired, "fired rules mismatching") {
+		return
+	}
+}
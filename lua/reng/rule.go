@@ -0,0 +1,461 @@
+// (c) 2018, Jeff Chen <jefurry@qq.com>
+//
+// This file is part of Gola
+//
+// Copyright 2018 The Gola Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package reng
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/jefurry/gola/lua/pm"
+	"github.com/pkg/errors"
+	"github.com/yuin/gluamapper"
+	"github.com/yuin/gopher-lua"
+	"github.com/yuin/gopher-lua/parse"
+	"gopkg.in/yaml.v2"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	// variable name of facts table in conditions and actions.
+	factsName = "facts"
+)
+
+var (
+	ErrRuleName        = errors.New("rule name is empty")
+	ErrRuleCondition   = errors.New("rule condition is empty")
+	ErrRuleSetFormat   = errors.New("not a supported rule set format")
+	ErrRuleSetNotTable = errors.New("lua rule set is not a table")
+)
+
+// LoadTimeout is the maximum time of running lua chunk by LoadLua,
+// since rule files are untrusted.
+var LoadTimeout = 10 * time.Second
+
+type (
+	// Rule is a when/then rule over facts.
+	// Condition is a lua expression and Action is lua statements, both of them
+	// access the facts table by the variable `facts`.
+	Rule struct {
+		Name string `json:"name" yaml:"name"`
+		// Rules with higher salience are evaluated first,
+		// rules with the same salience are evaluated in order of declaration.
+		Salience  int    `json:"salience" yaml:"salience"`
+		Condition string `json:"condition" yaml:"condition"`
+		Action    string `json:"action" yaml:"action"`
+		// Whether changes made by the action do not activate the rule itself
+		// again in forward chaining.
+		NoLoop bool `json:"no_loop" yaml:"no_loop"`
+	}
+
+	// RuleSet is a set of rules evaluated against facts.
+	RuleSet struct {
+		Name  string  `json:"name" yaml:"name"`
+		Rules []*Rule `json:"rules" yaml:"rules"`
+		// The maximum number of rules fired in forward chaining.
+		// Note: A value of 0 indicates `DefaultMaxCycles`.
+		MaxCycles int `json:"max_cycles" yaml:"max_cycles"`
+		// Whether to record trace of evaluations in result.
+		Trace bool `json:"trace" yaml:"trace"`
+
+		// lua chunk returns the compiled rules in order of evaluation.
+		source string
+		// matching network of forward chaining.
+		net *network
+	}
+
+	// session evaluates compiled rules of rule set in a lua state.
+	session struct {
+		rs  *RuleSet
+		L   *lua.LState
+		fns *lua.LTable
+		// facts table passed to conditions and actions.
+		facts  *lua.LTable
+		result *Result
+		// nil if tracing is disabled.
+		trace *Trace
+	}
+
+	// Result is the result of evaluating rule set.
+	Result struct {
+		// Names of the fired rules in order.
+		Fired []string
+		// Facts modified by actions of the fired rules.
+		Facts map[string]interface{}
+		// Trace of evaluation if `Trace` of rule set is set, or nil.
+		Trace *Trace
+		// Version of rule set if evaluated by Repository.
+		Version string
+	}
+)
+
+// NewRuleSet validates rules and sorts them by salience.
+func NewRuleSet(name string, rules ...*Rule) (*RuleSet, error) {
+	rs := &RuleSet{
+		Name:  name,
+		Rules: rules,
+	}
+
+	if err := rs.init(); err != nil {
+		return nil, err
+	}
+
+	return rs, nil
+}
+
+// LoadYAML loads rule set from YAML document.
+func LoadYAML(data []byte) (*RuleSet, error) {
+	rs := &RuleSet{}
+	if err := yaml.Unmarshal(data, rs); err != nil {
+		return nil, err
+	}
+
+	if err := rs.init(); err != nil {
+		return nil, err
+	}
+
+	return rs, nil
+}
+
+// LoadJSON loads rule set from JSON document.
+func LoadJSON(data []byte) (*RuleSet, error) {
+	rs := &RuleSet{}
+	if err := json.Unmarshal(data, rs); err != nil {
+		return nil, err
+	}
+
+	if err := rs.init(); err != nil {
+		return nil, err
+	}
+
+	return rs, nil
+}
+
+// LoadLua loads rule set from lua chunk which returns a table like:
+//
+//	return {
+//	  name = "discount",
+//	  rules = {
+//	    {name = "vip", salience = 10, condition = "facts.vip", action = "facts.discount = 0.2"},
+//	  },
+//	}
+//
+// The chunk runs with the same restricted libraries as the rule engine,
+// and is stopped after `LoadTimeout`.
+func LoadLua(source string) (*RuleSet, error) {
+	L := lua.NewState(lua.Options{SkipOpenLibs: true})
+	defer L.Close()
+
+	if err := DefaultPolicy().Apply(L); err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), LoadTimeout)
+	defer cancel()
+	L.SetContext(ctx)
+
+	if err := L.DoString(source); err != nil {
+		if ctx.Err() != nil {
+			return nil, errors.Wrap(ctx.Err(), "lua rule set")
+		}
+
+		return nil, err
+	}
+
+	tbl, ok := L.Get(-1).(*lua.LTable)
+	if !ok {
+		return nil, ErrRuleSetNotTable
+	}
+
+	rs := &RuleSet{}
+	if err := gluamapper.Map(tbl, rs); err != nil {
+		return nil, err
+	}
+
+	if err := rs.init(); err != nil {
+		return nil, err
+	}
+
+	return rs, nil
+}
+
+// LoadFile loads rule set from file, the format is determined by extension
+// of file, one of ".yaml", ".yml", ".json" or ".lua".
+func LoadFile(path string) (*RuleSet, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		return LoadYAML(data)
+	case ".json":
+		return LoadJSON(data)
+	case ".lua":
+		return LoadLua(string(data))
+	}
+
+	return nil, ErrRuleSetFormat
+}
+
+// init validates rules, sorts them by salience, and generates lua chunk of them.
+func (rs *RuleSet) init() error {
+	for i, rule := range rs.Rules {
+		if rule == nil || rule.Name == "" {
+			return errors.Wrapf(ErrRuleName, "rule #%d", i+1)
+		}
+
+		if strings.TrimSpace(rule.Condition) == "" {
+			return errors.Wrapf(ErrRuleCondition, "rule %q", rule.Name)
+		}
+
+		if err := checkSyntax(rule.Name, conditionSource(rule.Condition)); err != nil {
+			return err
+		}
+
+		if err := checkSyntax(rule.Name, rule.Action); err != nil {
+			return err
+		}
+	}
+
+	sort.SliceStable(rs.Rules, func(i, j int) bool {
+		return rs.Rules[i].Salience > rs.Rules[j].Salience
+	})
+
+	var buf bytes.Buffer
+	buf.WriteString("return {\n")
+	for _, rule := range rs.Rules {
+		fmt.Fprintf(&buf, "{\nfunction(%s)\n%s\nend,\nfunction(%s)\n%s\nend,\n},\n",
+			factsName, conditionSource(rule.Condition), factsName, rule.Action)
+	}
+	buf.WriteString("}\n")
+
+	rs.source = buf.String()
+	rs.net = newNetwork(rs.Rules)
+
+	return nil
+}
+
+// Eval evaluates rules against facts with a lua state of pool. The condition of
+// each rule is evaluated in order, and the action is executed if it is true.
+// Note: facts is not modified, the modified facts are returned in result.
+func (rs *RuleSet) Eval(ctx context.Context, lpm *pm.LPM, facts map[string]interface{}) (*Result, error) {
+	return rs.run(ctx, lpm, facts, traceModeEval, func(s *session) error {
+		for i := range rs.Rules {
+			yes, err := s.condition(i)
+			if err != nil {
+				return err
+			}
+
+			if !yes {
+				continue
+			}
+
+			if _, err := s.action(i, false); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+// run runs fn with a session of rule set in a lua state of pool.
+func (rs *RuleSet) run(ctx context.Context, lpm *pm.LPM, facts map[string]interface{}, mode string, fn func(*session) error) (*Result, error) {
+	result := &Result{
+		Fired: make([]string, 0),
+	}
+
+	if rs.Trace {
+		result.Trace = newTrace(rs.Name, mode)
+	}
+
+	_, err := lpm.LoadString(ctx, rs.source, func(L *lua.LState, chunk *lua.LFunction) (lua.LValue, error) {
+		L.Push(chunk)
+		if err := L.PCall(0, 1, nil); err != nil {
+			return lua.LNil, err
+		}
+
+		fns := L.Get(-1).(*lua.LTable)
+		L.Pop(1)
+
+		tbl, ok := pm.ToLValue(L, facts).(*lua.LTable)
+		if !ok {
+			tbl = L.NewTable()
+		}
+
+		s := &session{
+			rs:     rs,
+			L:      L,
+			fns:    fns,
+			facts:  tbl,
+			result: result,
+			trace:  result.Trace,
+		}
+
+		if err := fn(s); err != nil {
+			return lua.LNil, err
+		}
+
+		result.Facts = toFacts(tbl)
+		if s.trace != nil {
+			s.trace.finish()
+		}
+
+		return lua.LNil, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// condition evaluates condition of the i-th rule.
+func (s *session) condition(i int) (bool, error) {
+	start := time.Now()
+
+	L := s.L
+	if err := L.CallByParam(lua.P{
+		Fn:      s.fns.RawGetInt(i + 1).(*lua.LTable).RawGetInt(1),
+		NRet:    1,
+		Protect: true,
+	}, s.facts); err != nil {
+		return false, errors.Wrapf(err, "rule %q condition", s.rs.Rules[i].Name)
+	}
+
+	yes := lua.LVAsBool(L.Get(-1))
+	L.Pop(1)
+
+	if s.trace != nil {
+		s.trace.add(&TraceStep{
+			Kind:     TraceCondition,
+			Rule:     s.rs.Rules[i].Name,
+			Cycle:    len(s.result.Fired),
+			Matched:  &yes,
+			Facts:    s.reads(i),
+			Duration: time.Since(start),
+		})
+	}
+
+	return yes, nil
+}
+
+// action executes action of the i-th rule, it returns the fact fields
+// changed by the action if watch is true or tracing is enabled.
+func (s *session) action(i int, watch bool) ([]string, error) {
+	start := time.Now()
+
+	var before map[lua.LValue]lua.LValue
+	watch = watch || s.trace != nil
+	if watch {
+		before = s.snapshot(s.trace != nil)
+	}
+
+	if err := s.L.CallByParam(lua.P{
+		Fn:      s.fns.RawGetInt(i + 1).(*lua.LTable).RawGetInt(2),
+		NRet:    0,
+		Protect: true,
+	}, s.facts); err != nil {
+		return nil, errors.Wrapf(err, "rule %q action", s.rs.Rules[i].Name)
+	}
+
+	var changed []string
+	if watch {
+		changed = s.changed(before, s.trace != nil)
+	}
+
+	if s.trace != nil {
+		sort.Strings(changed)
+		s.trace.add(&TraceStep{
+			Kind:     TraceAction,
+			Rule:     s.rs.Rules[i].Name,
+			Cycle:    len(s.result.Fired),
+			Changed:  changed,
+			Duration: time.Since(start),
+		})
+	}
+
+	s.result.Fired = append(s.result.Fired, s.rs.Rules[i].Name)
+
+	return changed, nil
+}
+
+// reads returns the fact values read by condition of the i-th rule.
+func (s *session) reads(i int) map[string]interface{} {
+	fields := s.rs.net.reads[i]
+	if fields == nil {
+		return toFacts(s.facts)
+	}
+
+	facts := make(map[string]interface{}, len(fields))
+	for _, field := range fields {
+		facts[field] = toGoValue(s.facts.RawGetString(field))
+	}
+
+	return facts
+}
+
+func conditionSource(condition string) string {
+	return fmt.Sprintf("return (\n%s\n)", condition)
+}
+
+// checkSyntax checks syntax of lua code of rule.
+func checkSyntax(name, source string) error {
+	if _, err := parse.Parse(strings.NewReader(source), name); err != nil {
+		return errors.Wrapf(err, "rule %q", name)
+	}
+
+	return nil
+}
+
+// toFacts converts lua table to facts.
+func toFacts(tbl *lua.LTable) map[string]interface{} {
+	facts := make(map[string]interface{})
+	tbl.ForEach(func(k, v lua.LValue) {
+		facts[k.String()] = toGoValue(v)
+	})
+
+	return facts
+}
+
+// toGoValue converts lua value to Go value, lua tables are converted to
+// []interface{} if they are arrays, or map[string]interface{} otherwise.
+func toGoValue(lv lua.LValue) interface{} {
+	switch v := lv.(type) {
+	case *lua.LNilType:
+		return nil
+	case lua.LBool:
+		return bool(v)
+	case lua.LString:
+		return string(v)
+	case lua.LNumber:
+		return float64(v)
+	case *lua.LTable:
+		if n := v.MaxN(); n > 0 {
+			arr := make([]interface{}, 0, n)
+			for i := 1; i <= n; i++ {
+				arr = append(arr, toGoValue(v.RawGetInt(i)))
+			}
+
+			return arr
+		}
+
+		return toFacts(v)
+	case *lua.LUserData:
+		return v.Value
+	}
+
+	return lv
+}
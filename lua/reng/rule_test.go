@@ -0,0 +1,192 @@
+// (c) 2018, Jeff Chen <jefurry@qq.com>
+//
+// This file is part of Gola
+//
+// Copyright 2018 The Gola Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package reng
+
+import (
+	"context"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+const yamlRuleSet = `
+name: discount
+rules:
+  - name: adult
+    condition: facts.age >= 18
+    action: facts.adult = true
+  - name: vip
+    salience: 10
+    condition: facts.vip
+    action: |
+      facts.discount = 0.2
+      facts.tags = {"vip"}
+  - name: child
+    condition: facts.age < 12
+    action: facts.discount = 0.5
+`
+
+const jsonRuleSet = `{
+	"name": "discount",
+	"rules": [
+		{"name": "adult", "condition": "facts.age >= 18", "action": "facts.adult = true"},
+		{"name": "vip", "salience": 10, "condition": "facts.vip", "action": "facts.discount = 0.2 facts.tags = {\"vip\"}"},
+		{"name": "child", "condition": "facts.age < 12", "action": "facts.discount = 0.5"}
+	]
+}`
+
+const luaRuleSet = `
+return {
+	name = "discount",
+	rules = {
+		{name = "adult", condition = "facts.age >= 18", action = "facts.adult = true"},
+		{name = "vip", salience = 10, condition = "facts.vip", action = [[
+			facts.discount = 0.2
+			facts.tags = {"vip"}
+		]]},
+		{name = "child", condition = "facts.age < 12", action = "facts.discount = 0.5"},
+	},
+}
+`
+
+func TestRuleSet(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.TODO())
+	defer cancel()
+
+	lpm, err := Default(ctx)
+	if !assert.NoError(t, err, "Default should succeed") {
+		return
+	}
+
+	defer lpm.Shutdown(context.TODO())
+
+	dir, err := ioutil.TempDir("", "gola-reng")
+	if !assert.NoError(t, err, "TempDir should succeed") {
+		return
+	}
+
+	defer os.RemoveAll(dir)
+
+	for name, source := range map[string]string{
+		"rules.yaml": yamlRuleSet,
+		"rules.json": jsonRuleSet,
+		"rules.lua":  luaRuleSet,
+	} {
+		path := filepath.Join(dir, name)
+		if !assert.NoError(t, ioutil.WriteFile(path, []byte(source), 0644), "WriteFile should succeed") {
+			return
+		}
+
+		rs, err := LoadFile(path)
+		if !assert.NoError(t, err, "LoadFile should succeed") {
+			return
+		}
+
+		if !assert.Equal(t, "discount", rs.Name, "name mismatching") {
+			return
+		}
+
+		facts := map[string]interface{}{"age": 30, "vip": true}
+		result, err := rs.Eval(ctx, lpm, facts)
+		if !assert.NoError(t, err, "Eval should succeed") {
+			return
+		}
+
+		if !assert.Equal(t, []string{"vip", "adult"}, result.Fired, "fired rules mismatching") {
+			return
+		}
+
+		if !assert.Equal(t, map[string]interface{}{
+			"age":      float64(30),
+			"vip":      true,
+			"adult":    true,
+			"discount": 0.2,
+			"tags":     []interface{}{"vip"},
+		}, result.Facts, "facts mismatching") {
+			return
+		}
+
+		if !assert.Equal(t, map[string]interface{}{"age": 30, "vip": true}, facts, "facts should not be modified") {
+			return
+		}
+
+		result, err = rs.Eval(ctx, lpm, map[string]interface{}{"age": 8})
+		if !assert.NoError(t, err, "Eval should succeed") {
+			return
+		}
+
+		if !assert.Equal(t, []string{"child"}, result.Fired, "fired rules mismatching") {
+			return
+		}
+	}
+
+	_, err = LoadFile(filepath.Join(dir, "rules.txt"))
+	if !assert.Error(t, err, "LoadFile should failed") {
+		return
+	}
+}
+
+func TestRuleSetInvalid(t *testing.T) {
+	_, err := NewRuleSet("invalid", &Rule{Condition: "true"})
+	if !assert.Equal(t, ErrRuleName, errors.Cause(err), "error mismatching") {
+		return
+	}
+
+	_, err = NewRuleSet("invalid", &Rule{Name: "empty"})
+	if !assert.Equal(t, ErrRuleCondition, errors.Cause(err), "error mismatching") {
+		return
+	}
+
+	_, err = NewRuleSet("invalid", &Rule{Name: "syntax", Condition: "facts.a ==", Action: ""})
+	if !assert.Error(t, err, "NewRuleSet should failed") {
+		return
+	}
+
+	_, err = NewRuleSet("invalid", &Rule{Name: "syntax", Condition: "true", Action: "facts.a = "})
+	if !assert.Error(t, err, "NewRuleSet should failed") {
+		return
+	}
+
+	_, err = LoadLua(`return 1`)
+	if !assert.Equal(t, ErrRuleSetNotTable, err, "error mismatching") {
+		return
+	}
+
+	timeout := LoadTimeout
+	LoadTimeout = 100 * time.Millisecond
+	_, err = LoadLua(`while true do end`)
+	LoadTimeout = timeout
+	if !assert.Equal(t, context.DeadlineExceeded, errors.Cause(err), "error mismatching") {
+		return
+	}
+
+	ctx, cancel := context.WithCancel(context.TODO())
+	defer cancel()
+
+	lpm, err := Default(ctx)
+	if !assert.NoError(t, err, "Default should succeed") {
+		return
+	}
+
+	defer lpm.Shutdown(context.TODO())
+
+	rs, err := NewRuleSet("runtime", &Rule{Name: "nil", Condition: "facts.a.b"})
+	if !assert.NoError(t, err, "NewRuleSet should succeed") {
+		return
+	}
+
+	_, err = rs.Eval(ctx, lpm, nil)
+	if !assert.Error(t, err, "Eval should failed") {
+		return
+	}
+}
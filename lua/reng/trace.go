@@ -0,0 +1,151 @@
+// (c) 2018, Jeff Chen <jefurry@qq.com>
+//
+// This file is part of Gola
+//
+// Copyright 2018 The Gola Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package reng
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	traceModeEval  = "eval"
+	traceModeInfer = "infer"
+	// evaluation of decision table.
+	traceModeDecision = "decision"
+)
+
+// Kinds of trace step.
+const (
+	TraceCondition = "condition"
+	TraceAction    = "action"
+)
+
+type (
+	// Trace records the steps of an evaluation of rule set.
+	// Durations are serialized to JSON in nanoseconds.
+	Trace struct {
+		RuleSet string `json:"rule_set"`
+		// "eval", "infer" or "decision".
+		Mode     string        `json:"mode"`
+		Start    time.Time     `json:"start"`
+		Duration time.Duration `json:"duration"`
+		Steps    []*TraceStep  `json:"steps"`
+	}
+
+	// TraceStep is a condition evaluated or an action executed.
+	TraceStep struct {
+		// TraceCondition or TraceAction.
+		Kind string `json:"kind"`
+		Rule string `json:"rule"`
+		// The number of rules fired before the step.
+		Cycle int `json:"cycle"`
+		// Result of condition, it is nil for action.
+		Matched *bool `json:"matched,omitempty"`
+		// Fact values read by condition, keyed by top-level field of facts.
+		Facts map[string]interface{} `json:"facts,omitempty"`
+		// Fact fields changed by action.
+		Changed  []string      `json:"changed,omitempty"`
+		Duration time.Duration `json:"duration"`
+	}
+)
+
+func newTrace(name, mode string) *Trace {
+	return &Trace{
+		RuleSet: name,
+		Mode:    mode,
+		Start:   time.Now(),
+		Steps:   make([]*TraceStep, 0),
+	}
+}
+
+func (t *Trace) add(step *TraceStep) {
+	t.Steps = append(t.Steps, step)
+}
+
+func (t *Trace) finish() {
+	t.Duration = time.Since(t.Start)
+}
+
+// Rule returns the steps of rule.
+func (t *Trace) Rule(name string) []*TraceStep {
+	steps := make([]*TraceStep, 0)
+	for _, step := range t.Steps {
+		if step.Rule == name {
+			steps = append(steps, step)
+		}
+	}
+
+	return steps
+}
+
+// JSON returns the JSON encoding of trace for audit logs.
+func (t *Trace) JSON() ([]byte, error) {
+	return json.Marshal(t)
+}
+
+// Explain returns the human readable explanation of trace, like:
+//
+//	rule set "order" (infer): 2 rules fired in 1.2ms
+//	[0] condition "reject": true, read: status="new" (15µs)
+//	[0] action "reject": changed: status (20µs)
+func (t *Trace) Explain() string {
+	fired := 0
+	for _, step := range t.Steps {
+		if step.Kind == TraceAction {
+			fired += 1
+		}
+	}
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "rule set %q (%s): %d rules fired in %s\n", t.RuleSet, t.Mode, fired, t.Duration)
+
+	for _, step := range t.Steps {
+		switch step.Kind {
+		case TraceCondition:
+			fmt.Fprintf(&buf, "[%d] condition %q: %t", step.Cycle, step.Rule, step.Matched != nil && *step.Matched)
+			if len(step.Facts) > 0 {
+				fmt.Fprintf(&buf, ", read: %s", explainFacts(step.Facts))
+			}
+		case TraceAction:
+			fmt.Fprintf(&buf, "[%d] action %q", step.Cycle, step.Rule)
+			if len(step.Changed) > 0 {
+				fmt.Fprintf(&buf, ": changed: %s", strings.Join(step.Changed, ", "))
+			}
+		}
+
+		fmt.Fprintf(&buf, " (%s)\n", step.Duration)
+	}
+
+	return buf.String()
+}
+
+// explainFacts formats facts in order of fields.
+func explainFacts(facts map[string]interface{}) string {
+	fields := make([]string, 0, len(facts))
+	for field := range facts {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+
+	pairs := make([]string, 0, len(fields))
+	for _, field := range fields {
+		value, err := json.Marshal(facts[field])
+		if err != nil {
+			value = []byte(fmt.Sprint(facts[field]))
+		}
+
+		pairs = append(pairs, fmt.Sprintf("%s=%s", field, value))
+	}
+
+	return strings.Join(pairs, " ")
+}
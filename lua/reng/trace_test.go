@@ -0,0 +1,158 @@
+// (c) 2018, Jeff Chen <jefurry@qq.com>
+//
+// This file is part of Gola
+//
+// Copyright 2018 The Gola Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package reng
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"strings"
+	"testing"
+)
+
+func TestTrace(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.TODO())
+	defer cancel()
+
+	lpm, err := Default(ctx)
+	if !assert.NoError(t, err, "Default should succeed") {
+		return
+	}
+
+	defer lpm.Shutdown(context.TODO())
+
+	rs, err := LoadYAML([]byte(`
+name: order
+trace: true
+rules:
+  - name: reject
+    salience: 10
+    condition: facts.amount > facts.limit
+    action: facts.status = "rejected"
+  - name: notify
+    condition: facts.status == "rejected"
+    action: facts.notified = true
+`))
+	if !assert.NoError(t, err, "LoadYAML should succeed") {
+		return
+	}
+
+	facts := map[string]interface{}{"amount": 200, "limit": 100, "status": "new"}
+
+	result, err := rs.Eval(ctx, lpm, facts)
+	if !assert.NoError(t, err, "Eval should succeed") {
+		return
+	}
+
+	trace := result.Trace
+	if !assert.NotNil(t, trace, "trace should be recorded") {
+		return
+	}
+
+	if !assert.Equal(t, "eval", trace.Mode, "mode mismatching") {
+		return
+	}
+
+	kinds := make([]string, 0)
+	for _, step := range trace.Steps {
+		kinds = append(kinds, step.Kind+" "+step.Rule)
+	}
+
+	if !assert.Equal(t, []string{"condition reject", "action reject", "condition notify", "action notify"}, kinds, "steps mismatching") {
+		return
+	}
+
+	steps := trace.Rule("reject")
+	if !assert.Equal(t, 2, len(steps), "steps mismatching") {
+		return
+	}
+
+	if !assert.Equal(t, map[string]interface{}{"amount": float64(200), "limit": float64(100)}, steps[0].Facts, "facts mismatching") {
+		return
+	}
+
+	if !assert.Equal(t, true, *steps[0].Matched, "matched mismatching") {
+		return
+	}
+
+	if !assert.Nil(t, steps[1].Matched, "matched of action should be nil") {
+		return
+	}
+
+	if !assert.Equal(t, []string{"status"}, steps[1].Changed, "changed mismatching") {
+		return
+	}
+
+	data, err := trace.JSON()
+	if !assert.NoError(t, err, "JSON should succeed") {
+		return
+	}
+
+	var decoded Trace
+	if !assert.NoError(t, json.Unmarshal(data, &decoded), "Unmarshal should succeed") {
+		return
+	}
+
+	if !assert.Equal(t, len(trace.Steps), len(decoded.Steps), "steps mismatching") {
+		return
+	}
+
+	explain := trace.Explain()
+	if !assert.True(t, strings.HasPrefix(explain, `rule set "order" (eval): 2 rules fired in`), "explain mismatching") {
+		return
+	}
+
+	if !assert.Contains(t, explain, `[0] condition "reject": true, read: amount=200 limit=100`, "explain mismatching") {
+		return
+	}
+
+	if !assert.Contains(t, explain, `[0] action "reject": changed: status`, "explain mismatching") {
+		return
+	}
+
+	// conditions not matched are serialized.
+	result, err = rs.Eval(ctx, lpm, map[string]interface{}{"amount": 50, "limit": 100, "status": "new"})
+	if !assert.NoError(t, err, "Eval should succeed") {
+		return
+	}
+
+	data, err = result.Trace.JSON()
+	if !assert.NoError(t, err, "JSON should succeed") {
+		return
+	}
+
+	if !assert.Contains(t, string(data), `"kind":"condition","rule":"reject","cycle":0,"matched":false`, "JSON mismatching") {
+		return
+	}
+
+	// infer records re-evaluated conditions.
+	result, err = rs.Infer(ctx, lpm, facts)
+	if !assert.NoError(t, err, "Infer should succeed") {
+		return
+	}
+
+	if !assert.Equal(t, "infer", result.Trace.Mode, "mode mismatching") {
+		return
+	}
+
+	if !assert.Equal(t, 3, len(result.Trace.Rule("notify")), "steps mismatching") {
+		return
+	}
+
+	// tracing is opt-in.
+	rs.Trace = false
+	result, err = rs.Eval(ctx, lpm, facts)
+	if !assert.NoError(t, err, "Eval should succeed") {
+		return
+	}
+
+	if !assert.Nil(t, result.Trace, "trace should not be recorded") {
+		return
+	}
+}